@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
 
 	"github.com/matmerr/kubectl-vmss/pkg/cmd"
 	"github.com/spf13/pflag"
@@ -12,9 +14,15 @@ func main() {
 	flags := pflag.NewFlagSet("kubectl-vmss", pflag.ExitOnError)
 	pflag.CommandLine = flags
 
+	// Cancel the context on Ctrl-C so long-running commands (logs -f) can
+	// stop polling cleanly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	streams := genericclioptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr}
 	root := cmd.NewCmdVMSS(streams)
-	if err := root.Execute(); err != nil {
+	if err := root.ExecuteContext(ctx); err != nil {
+		stop()
 		os.Exit(1)
 	}
 }
@@ -5,17 +5,19 @@ import (
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
-// NewCmdACN returns the parent "acn" command with subcommands logs and state.
+// NewCmdACN returns the parent "acn" command with subcommands logs, state, cns and ipam.
 func NewCmdACN(streams genericclioptions.IOStreams) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:     "acn",
 		Aliases: []string{"azcni", "cni"},
 		Short:   "Inspect Azure CNI / CNS on a node via VMSS run-command",
-		Long:    "Retrieve Azure CNI / Azure CNS logs and state files from AKS nodes via VMSS run-command.",
+		Long:    "Retrieve Azure CNI / Azure CNS logs and state files, and query CNS's local API, on AKS nodes via VMSS run-command.",
 	}
 
 	cmd.AddCommand(NewCmdACNLogs(streams))
 	cmd.AddCommand(NewCmdACNState(streams))
+	cmd.AddCommand(NewCmdACNCNS(streams))
+	cmd.AddCommand(NewCmdACNIPAM(streams))
 
 	return cmd
 }
@@ -0,0 +1,526 @@
+package acn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
+	"github.com/matmerr/kubectl-vmss/pkg/transfer"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// CNSAddress is Azure CNS's REST API. It only listens on the node itself,
+// which is why it is queried through run-command.
+const CNSAddress = "http://localhost:10090"
+
+// cnsHealthURL is the health endpoint CNS serves next to its metrics.
+const cnsHealthURL = "http://localhost:10092/healthz"
+
+// ipStates are the states CNS keeps pool IPs in; asking for all of them
+// returns the whole pool.
+var ipStates = []string{"Assigned", "Available", "PendingRelease", "PendingProgramming"}
+
+// cnsQuery is a named CNS request, how to decode its response and how to
+// print the decoded value as a table. Only read-only requests are named.
+type cnsQuery struct {
+	method string
+	url    string
+	body   string
+	help   string
+	decode func([]byte) (any, error)
+	table  func(io.Writer, any) error
+}
+
+// cnsQueries are the queries "acn cns" knows by name.
+var cnsQueries = map[string]cnsQuery{
+	"ipconfigs": {
+		method: "POST",
+		url:    CNSAddress + "/debug/ipaddresses",
+		body:   ipConfigFilter(),
+		help:   "pool IPs per network container, counted by state",
+		decode: func(b []byte) (any, error) {
+			ips, err := decodeIPConfigs(b)
+			if err != nil {
+				return nil, err
+			}
+			return summarizeIPConfigs(ips), nil
+		},
+		table: func(w io.Writer, v any) error { return writePoolTable(w, v.([]ipPoolSummary)) },
+	},
+	"debug/ipaddresses": {
+		method: "POST",
+		url:    CNSAddress + "/debug/ipaddresses",
+		body:   ipConfigFilter(),
+		help:   "every pool IP with its state and pod",
+		decode: func(b []byte) (any, error) { return decodeIPConfigs(b) },
+		table:  func(w io.Writer, v any) error { return writeIPConfigTable(w, v.([]ipConfig)) },
+	},
+	"podcontext": {
+		method: "GET",
+		url:    CNSAddress + "/debug/podcontext",
+		help:   "IP config IDs held by each pod interface",
+		decode: func(b []byte) (any, error) { return decodePodContext(b) },
+		table:  func(w io.Writer, v any) error { return writePodContextTable(w, v.([]podContext)) },
+	},
+	"nc": {
+		method: "GET",
+		url:    CNSAddress + "/network/networkcontainers",
+		help:   "network containers programmed into CNS",
+		decode: func(b []byte) (any, error) { return decodeNetworkContainers(b) },
+		table:  func(w io.Writer, v any) error { return writeNCTable(w, v.([]networkContainer)) },
+	},
+	"health": {
+		method: "GET",
+		url:    cnsHealthURL,
+		help:   "CNS's own health check",
+		decode: func(b []byte) (any, error) { return strings.TrimSpace(string(b)), nil },
+		table: func(w io.Writer, v any) error {
+			_, err := fmt.Fprintln(w, v)
+			return err
+		},
+	},
+}
+
+func ipConfigFilter() string {
+	b, _ := json.Marshal(map[string][]string{"IPConfigStateFilter": ipStates})
+	return string(b)
+}
+
+// cnsQueryNames returns the named queries in a stable order for help text.
+func cnsQueryNames() []string {
+	names := make([]string, 0, len(cnsQueries))
+	for n := range cnsQueries {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func cnsQueryHelp() string {
+	var b strings.Builder
+	for _, n := range cnsQueryNames() {
+		fmt.Fprintf(&b, "  %-18s %s\n", n, cnsQueries[n].help)
+	}
+	return b.String()
+}
+
+type acnCNSOptions struct {
+	node      string
+	query     string
+	output    string
+	chunkSize int
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdACNCNS returns a cobra command for "kubectl vmss acn cns".
+func NewCmdACNCNS(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &acnCNSOptions{
+		chunkSize: transfer.DefaultChunkSize,
+		streams:   streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "cns <node> <query | /path>",
+		Short: "Query Azure CNS's local REST API on a node",
+		Long: `Query Azure CNS's REST API (` + CNSAddress + `) on the node and print the
+response as a table, or as JSON with -o json. CNS's in-memory view of its
+network containers and IP pool is what pods actually get, and can differ
+from the state files "acn state" prints.
+
+Named queries:
+` + cnsQueryHelp() + `
+Any other CNS path starting with "/" is fetched with GET and printed as
+JSON. Responses are staged on the node and downloaded in chunks, so a large
+pool takes a few extra run-command calls.`,
+		Example: `  # IP pool usage by state
+  kubectl vmss acn cns aks-nodepool1-vmss000000 ipconfigs
+
+  # Which pod holds which IP, as JSON
+  kubectl vmss acn cns aks-nodepool1-vmss000000 debug/ipaddresses -o json
+
+  # Any other read-only endpoint
+  kubectl vmss acn cns aks-nodepool1-vmss000000 /debug/restdata`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.node, o.query = args[0], args[1]
+			if _, ok := cnsQueries[o.query]; !ok && !strings.HasPrefix(o.query, "/") {
+				return fmt.Errorf("unknown query %q (known: %s, or a path starting with /)", o.query, strings.Join(cnsQueryNames(), ", "))
+			}
+			if o.output != "" && o.output != "json" {
+				return fmt.Errorf("unsupported output format %q (supported: json)", o.output)
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format: json")
+	cmd.Flags().IntVar(&o.chunkSize, "chunk-size", o.chunkSize, "Raw bytes transferred per run-command call")
+
+	return cmd
+}
+
+// Run executes the cns command.
+func (o *acnCNSOptions) Run(ctx context.Context) error {
+	q, ok := cnsQueries[o.query]
+	if !ok {
+		q = cnsQuery{method: "GET", url: CNSAddress + o.query, decode: decodeAny}
+	}
+
+	info, err := o.runner.ResolveVMSS(ctx, o.node)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.streams.ErrOut, "Querying CNS %s on %s/%s...\n", strings.TrimPrefix(q.url, "http://localhost"), info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, buildCNSScript(q))
+	if err != nil {
+		return err
+	}
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+	status, hasBody := parseCNSStatus(result.Stdout)
+	if status == 0 {
+		return fmt.Errorf("no response from CNS on %s", o.node)
+	}
+	var body []byte
+	if hasBody {
+		d := &transfer.Downloader{Runner: o.runner, Info: info, ChunkSize: o.chunkSize, Progress: o.streams.ErrOut}
+		if body, err = d.FetchStaged(ctx, result.Stdout); err != nil {
+			return err
+		}
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("CNS answered HTTP %d: %s", status, strings.TrimSpace(string(body)))
+	}
+
+	v, err := q.decode(body)
+	if err != nil {
+		return err
+	}
+	if o.output == "json" || q.table == nil {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.streams.Out, string(data))
+		return nil
+	}
+	return q.table(o.streams.Out, v)
+}
+
+// buildCNSScript returns the script that sends q to CNS with curl and
+// prints "status <http-code>", followed, when there is a body, by the
+// sizes and checksums of its gzipped copy expected by transfer.ParseStaged.
+func buildCNSScript(q cnsQuery) string {
+	data := ""
+	if q.body != "" {
+		data = " -H 'Content-Type: application/json' --data " + vmss.ShellQuote(q.body)
+	}
+	return fmt.Sprintf(`set -e
+OUT=$(mktemp /tmp/kubectl-vmss-cns.XXXXXX)
+if ! CODE=$(curl -sS -m 20 -o "$OUT" -w '%%{http_code}' -X %s%s %s); then
+  rm -f "$OUT"
+  echo "Error: CNS did not answer; is azure-cns running? (systemctl status azure-cns, or the azure-cns pod)" >&2
+  exit 1
+fi
+echo "status $CODE"
+if [ ! -s "$OUT" ]; then
+  rm -f "$OUT"
+  exit 0
+fi
+%s`,
+		q.method, data, vmss.ShellQuote(q.url), nodescript.StageGzip("$OUT"))
+}
+
+// parseCNSStatus returns the HTTP status printed by buildCNSScript, 0 when
+// there is none, and whether staging lines follow it.
+func parseCNSStatus(out string) (int, bool) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for i, line := range lines {
+		if code, ok := strings.CutPrefix(strings.TrimSpace(line), "status "); ok {
+			n, _ := strconv.Atoi(code)
+			return n, len(lines) > i+1
+		}
+	}
+	return 0, false
+}
+
+// cnsResponse is the status every CNS API response carries. A non-zero
+// ReturnCode is an error even with HTTP 200.
+type cnsResponse struct {
+	Response struct {
+		ReturnCode int
+		Message    string
+	}
+}
+
+func (r cnsResponse) err() error {
+	if r.Response.ReturnCode != 0 {
+		return fmt.Errorf("CNS returned code %d: %s", r.Response.ReturnCode, r.Response.Message)
+	}
+	return nil
+}
+
+func decodeAny(b []byte) (any, error) {
+	var v any
+	if len(b) == 0 {
+		return v, nil
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("decoding CNS response: %w", err)
+	}
+	return v, nil
+}
+
+// ipConfig is one IP of the pool, as returned by /debug/ipaddresses.
+type ipConfig struct {
+	NCID      string `json:"ncID"`
+	ID        string `json:"id"`
+	IPAddress string `json:"ipAddress"`
+	State     string `json:"state"`
+	Pod       string `json:"pod,omitempty"`
+}
+
+func decodeIPConfigs(b []byte) ([]ipConfig, error) {
+	var resp struct {
+		cnsResponse
+		IPConfigurationStatus []struct {
+			NCID      string
+			ID        string
+			IPAddress string
+			State     string
+			PodInfo   *struct {
+				PodName      string
+				PodNamespace string
+			}
+		}
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("decoding CNS response: %w", err)
+	}
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+	ips := make([]ipConfig, 0, len(resp.IPConfigurationStatus))
+	for _, s := range resp.IPConfigurationStatus {
+		ip := ipConfig{NCID: s.NCID, ID: s.ID, IPAddress: s.IPAddress, State: s.State}
+		if s.PodInfo != nil && s.PodInfo.PodName != "" {
+			ip.Pod = s.PodInfo.PodNamespace + "/" + s.PodInfo.PodName
+		}
+		ips = append(ips, ip)
+	}
+	sort.Slice(ips, func(i, j int) bool {
+		if ips[i].NCID != ips[j].NCID {
+			return ips[i].NCID < ips[j].NCID
+		}
+		return lessIP(ips[i].IPAddress, ips[j].IPAddress)
+	})
+	return ips, nil
+}
+
+// lessIP orders dotted IPv4 addresses numerically and anything else as text.
+func lessIP(a, b string) bool {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	if len(pa) == 4 && len(pb) == 4 {
+		for i := range pa {
+			x, err1 := strconv.Atoi(pa[i])
+			y, err2 := strconv.Atoi(pb[i])
+			if err1 != nil || err2 != nil {
+				break
+			}
+			if x != y {
+				return x < y
+			}
+		}
+	}
+	return a < b
+}
+
+func writeIPConfigTable(out io.Writer, ips []ipConfig) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "IP\tSTATE\tPOD\tNC")
+	for _, ip := range ips {
+		pod := ip.Pod
+		if pod == "" {
+			pod = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ip.IPAddress, ip.State, pod, ip.NCID)
+	}
+	return w.Flush()
+}
+
+// ipPoolSummary counts one network container's pool IPs by state.
+type ipPoolSummary struct {
+	NCID   string         `json:"ncID"`
+	Total  int            `json:"total"`
+	States map[string]int `json:"states"`
+}
+
+func summarizeIPConfigs(ips []ipConfig) []ipPoolSummary {
+	var pools []ipPoolSummary
+	index := map[string]int{}
+	for _, ip := range ips {
+		i, ok := index[ip.NCID]
+		if !ok {
+			i = len(pools)
+			index[ip.NCID] = i
+			pools = append(pools, ipPoolSummary{NCID: ip.NCID, States: map[string]int{}})
+		}
+		pools[i].Total++
+		pools[i].States[ip.State]++
+	}
+	return pools
+}
+
+// writePoolTable prints a column per known state, so pools line up even
+// when a state is empty, and notes IPs stuck in a pending state.
+func writePoolTable(out io.Writer, pools []ipPoolSummary) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NC\tTOTAL\tASSIGNED\tAVAILABLE\tPENDING RELEASE\tPENDING PROGRAMMING")
+	var notes []string
+	for _, p := range pools {
+		fmt.Fprintf(w, "%s\t%d", p.NCID, p.Total)
+		for _, s := range ipStates {
+			fmt.Fprintf(w, "\t%d", p.States[s])
+		}
+		fmt.Fprintln(w)
+		if p.Total > 0 && p.States["Available"] == 0 {
+			notes = append(notes, fmt.Sprintf("%s: no IPs available; new pods on this node will wait for the pool to grow", p.NCID))
+		}
+		if n := p.States["PendingRelease"] + p.States["PendingProgramming"]; n > 0 {
+			notes = append(notes, fmt.Sprintf("%s: %d IPs pending; if this persists, check the NodeNetworkConfig status", p.NCID, n))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(pools) == 0 {
+		fmt.Fprintln(out, "\nCNS has no pool IPs; the node may use overlay or a CNI without CNS IPAM")
+	}
+	if len(notes) > 0 {
+		fmt.Fprintln(out)
+		for _, n := range notes {
+			fmt.Fprintln(out, n)
+		}
+	}
+	return nil
+}
+
+// podContext is the IP config IDs CNS tracks for one pod interface.
+type podContext struct {
+	Pod string   `json:"pod"`
+	IDs []string `json:"ipConfigIDs"`
+}
+
+// decodePodContext accepts both a list of IDs per pod and the single ID
+// older CNS versions kept before dual-stack.
+func decodePodContext(b []byte) ([]podContext, error) {
+	var resp struct {
+		cnsResponse
+		PodContext map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("decoding CNS response: %w", err)
+	}
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+	pods := make([]podContext, 0, len(resp.PodContext))
+	for pod, raw := range resp.PodContext {
+		p := podContext{Pod: pod}
+		if err := json.Unmarshal(raw, &p.IDs); err != nil {
+			var id string
+			if err := json.Unmarshal(raw, &id); err != nil {
+				return nil, fmt.Errorf("decoding pod context of %s: %w", pod, err)
+			}
+			p.IDs = []string{id}
+		}
+		pods = append(pods, p)
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Pod < pods[j].Pod })
+	return pods, nil
+}
+
+func writePodContextTable(out io.Writer, pods []podContext) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "POD\tIP CONFIG IDS")
+	for _, p := range pods {
+		fmt.Fprintf(w, "%s\t%s\n", p.Pod, strings.Join(p.IDs, ","))
+	}
+	return w.Flush()
+}
+
+// networkContainer is the part of a CNS network container that tells which
+// subnet it serves.
+type networkContainer struct {
+	ID               string `json:"id"`
+	Subnet           string `json:"subnet"`
+	Gateway          string `json:"gateway,omitempty"`
+	PrimaryInterface string `json:"primaryInterface,omitempty"`
+}
+
+func decodeNetworkContainers(b []byte) ([]networkContainer, error) {
+	var resp struct {
+		cnsResponse
+		NetworkContainers []struct {
+			NetworkContainerID string
+			IPConfiguration    struct {
+				IPSubnet struct {
+					IPAddress    string
+					PrefixLength int
+				}
+				GatewayIPAddress string
+			}
+			PrimaryInterfaceIdentifier string
+		}
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("decoding CNS response: %w", err)
+	}
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+	ncs := make([]networkContainer, 0, len(resp.NetworkContainers))
+	for _, n := range resp.NetworkContainers {
+		nc := networkContainer{
+			ID:               n.NetworkContainerID,
+			Gateway:          n.IPConfiguration.GatewayIPAddress,
+			PrimaryInterface: n.PrimaryInterfaceIdentifier,
+		}
+		if s := n.IPConfiguration.IPSubnet; s.IPAddress != "" {
+			nc.Subnet = fmt.Sprintf("%s/%d", s.IPAddress, s.PrefixLength)
+		}
+		ncs = append(ncs, nc)
+	}
+	return ncs, nil
+}
+
+func writeNCTable(out io.Writer, ncs []networkContainer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NC\tSUBNET\tGATEWAY\tPRIMARY INTERFACE")
+	for _, nc := range ncs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", nc.ID, orDash(nc.Subnet), orDash(nc.Gateway), orDash(nc.PrimaryInterface))
+	}
+	return w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
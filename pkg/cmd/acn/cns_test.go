@@ -0,0 +1,125 @@
+package acn
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const debugIPAddresses = `{
+  "IPConfigurationStatus": [
+    {"NCID": "nc-1", "ID": "a", "IPAddress": "10.224.0.10", "State": "Available"},
+    {"NCID": "nc-1", "ID": "b", "IPAddress": "10.224.0.9", "State": "Assigned",
+     "PodInfo": {"PodName": "web-0", "PodNamespace": "default", "PodInterfaceID": "c0ffee-eth0"}},
+    {"NCID": "nc-1", "ID": "c", "IPAddress": "10.224.0.11", "State": "PendingRelease"}
+  ],
+  "Response": {"ReturnCode": 0, "Message": ""}
+}`
+
+func TestDecodeIPConfigs(t *testing.T) {
+	ips, err := decodeIPConfigs([]byte(debugIPAddresses))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 3 {
+		t.Fatalf("got %d IPs, want 3", len(ips))
+	}
+	if ip := ips[0]; ip.IPAddress != "10.224.0.9" || ip.State != "Assigned" || ip.Pod != "default/web-0" {
+		t.Errorf("IPs not sorted numerically or pod lost: %+v", ips)
+	}
+	if ips[1].Pod != "" {
+		t.Errorf("available IP has pod %q", ips[1].Pod)
+	}
+}
+
+func TestDecodeIPConfigsReturnCode(t *testing.T) {
+	_, err := decodeIPConfigs([]byte(`{"Response": {"ReturnCode": 23, "Message": "not ready"}}`))
+	if err == nil || !strings.Contains(err.Error(), "code 23: not ready") {
+		t.Errorf("err = %v, want the CNS return code", err)
+	}
+}
+
+func TestWritePoolTable(t *testing.T) {
+	ips, err := decodeIPConfigs([]byte(debugIPAddresses))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := writePoolTable(&out, summarizeIPConfigs(ips)); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"nc-1  3      1         1          1                0",
+		"nc-1: 1 IPs pending",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("table missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "no IPs available") {
+		t.Errorf("pool with a free IP reported as exhausted:\n%s", got)
+	}
+}
+
+func TestDecodePodContext(t *testing.T) {
+	for _, body := range []string{
+		`{"PodContext": {"web-0:default": ["b"], "api-0:default": ["d", "e"]}}`,
+		`{"PodContext": {"web-0:default": "b", "api-0:default": ["d", "e"]}}`,
+	} {
+		pods, err := decodePodContext([]byte(body))
+		if err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+		if len(pods) != 2 || pods[0].Pod != "api-0:default" || len(pods[0].IDs) != 2 || pods[1].IDs[0] != "b" {
+			t.Errorf("%s: got %+v", body, pods)
+		}
+	}
+}
+
+func TestDecodeNetworkContainers(t *testing.T) {
+	ncs, err := decodeNetworkContainers([]byte(`{
+  "NetworkContainers": [{
+    "NetworkContainerID": "nc-1",
+    "IPConfiguration": {"IPSubnet": {"IPAddress": "10.224.0.4", "PrefixLength": 16}, "GatewayIPAddress": "10.224.0.1"},
+    "PrimaryInterfaceIdentifier": "10.224.0.0/16"
+  }],
+  "Response": {"ReturnCode": 0}
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ncs) != 1 || ncs[0].Subnet != "10.224.0.4/16" || ncs[0].Gateway != "10.224.0.1" {
+		t.Errorf("got %+v", ncs)
+	}
+}
+
+func TestParseCNSStatus(t *testing.T) {
+	for _, tt := range []struct {
+		out     string
+		status  int
+		hasBody bool
+	}{
+		{"status 200\n812 abc\n/tmp/kubectl-vmss-cns.X.gz 300 def\n", 200, true},
+		{"status 404\n", 404, false},
+		{"", 0, false},
+	} {
+		status, hasBody := parseCNSStatus(tt.out)
+		if status != tt.status || hasBody != tt.hasBody {
+			t.Errorf("parseCNSStatus(%q) = %d, %t, want %d, %t", tt.out, status, hasBody, tt.status, tt.hasBody)
+		}
+	}
+}
+
+func TestBuildCNSScript(t *testing.T) {
+	script := buildCNSScript(cnsQueries["ipconfigs"])
+	for _, want := range []string{
+		`-X POST -H 'Content-Type: application/json' --data '{"IPConfigStateFilter":["Assigned","Available","PendingRelease","PendingProgramming"]}'`,
+		`'http://localhost:10090/debug/ipaddresses'`,
+		`echo "status $CODE"`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q:\n%s", want, script)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package acn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// IPAMStateFile is where azure-vnet-ipam keeps its address pool when Azure
+// CNI runs without CNS.
+const IPAMStateFile = "/var/run/azure-vnet-ipam.json"
+
+// imdsInterfaces is the IMDS view of the node's NICs and their IPs.
+const imdsInterfaces = "http://169.254.169.254/metadata/instance/network/interface?api-version=2021-02-01"
+
+// azureReservedIPs is how many addresses Azure keeps in every subnet.
+const azureReservedIPs = 5
+
+type acnIPAMOptions struct {
+	nodes    []string
+	allNodes bool
+	selector string
+	parallel int
+	warn     int
+	output   string
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdACNIPAM returns a cobra command for "kubectl vmss acn ipam".
+func NewCmdACNIPAM(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &acnIPAMOptions{
+		parallel: 10,
+		warn:     90,
+		streams:  streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "ipam (<node>... | -l <selector> | --all-nodes)",
+		Short: "Report pod IP pool usage per node for Azure CNI",
+		Long: `Collect each node's pod IP pool and print how much of it is in use, to find
+nodes where "Failed to allocate IP" is coming from.
+
+With CNS, the pool comes from CNS's API (` + CNSAddress + `/debug/restdata): IPs
+by state, and the cached NodeNetworkConfig with the requested IP count, batch
+size and the subnet. Without CNS, the azure-vnet-ipam pool in
+` + IPAMStateFile + ` is used. The NIC's IPs come from IMDS and the
+pod cap from kubelet's --max-pods.
+
+A node is flagged when its assigned IPs reach --warn percent of its cap
+(the lower of max pods and the pool's maxIPCount), when no IP is available,
+when the pool holds fewer IPs than the NodeNetworkConfig requested (often an
+exhausted subnet) and when IPs are stuck pending. The subnets are summed up
+at the end; other consumers of a subnet are not visible from the nodes.`,
+		Example: `  # One node
+  kubectl vmss acn ipam aks-nodepool1-vmss000000
+
+  # A node pool, as JSON
+  kubectl vmss acn ipam -l agentpool=nodepool1 -o json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.nodes = args
+			fanOut := o.allNodes || o.selector != ""
+			if fanOut == (len(args) > 0) {
+				return fmt.Errorf("requires node names, or one of --all-nodes and --selector")
+			}
+			if o.parallel < 1 {
+				return fmt.Errorf("--parallel must be at least 1")
+			}
+			if o.warn < 1 || o.warn > 100 {
+				return fmt.Errorf("--warn must be between 1 and 100")
+			}
+			if o.output != "" && o.output != "json" {
+				return fmt.Errorf("unsupported output format %q (supported: json)", o.output)
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().BoolVar(&o.allNodes, "all-nodes", false, "Report on every node")
+	cmd.Flags().StringVarP(&o.selector, "selector", "l", "", "Report on the nodes matching this label selector")
+	cmd.Flags().IntVar(&o.parallel, "parallel", o.parallel, "Nodes to query at once")
+	cmd.Flags().IntVar(&o.warn, "warn", o.warn, "Flag nodes using at least this much of their pod IP cap (percent)")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format: json")
+
+	return cmd
+}
+
+// Run executes the ipam command.
+func (o *acnIPAMOptions) Run(ctx context.Context) error {
+	nodes := o.nodes
+	if len(nodes) == 0 {
+		var err error
+		if nodes, err = o.runner.ListNodes(ctx, o.selector); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(o.streams.ErrOut, "Collecting IP pools from %d nodes...\n", len(nodes))
+	script := buildIPAMScript()
+	results := vmss.RunOnNodes(ctx, o.runner, nodes, o.parallel, func(string) string { return script }, o.streams.ErrOut)
+	pools := make([]ipamNode, len(results))
+	for i, r := range results {
+		pools[i] = parseIPAMNode(r)
+		pools[i].evaluate(o.warn)
+	}
+
+	if o.output == "json" {
+		data, err := json.MarshalIndent(pools, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.streams.Out, string(data))
+		return nil
+	}
+	return writeIPAMTable(o.streams.Out, pools)
+}
+
+// buildIPAMScript returns the script that prints a node's pool as
+// key=value lines. The CNS and IMDS documents are reduced with grep on the
+// node: restdata lists every IP of the pool and would not fit in
+// run-command's output.
+func buildIPAMScript() string {
+	return fmt.Sprintf(`CNS=$(curl -sS -m 10 %s/debug/restdata 2>/dev/null) || CNS=""
+if [ -n "$CNS" ]; then
+  echo "mode=cns"
+  num() { printf '%%s' "$CNS" | grep -o "\"$1\": *[0-9]*" | head -1 | tr -cd '0-9'; }
+  str() { printf '%%s' "$CNS" | grep -o "\"$1\": *\"[^\"]*\"" | head -1 | cut -d'"' -f4; }
+  for s in Assigned Available PendingRelease PendingProgramming; do
+    echo "$s=$(printf '%%s' "$CNS" | grep -o "\"State\": *\"$s\"" | wc -l)"
+  done
+  echo "requested=$(num requestedIPCount)"
+  echo "batch=$(num batchSize)"
+  echo "max-ips=$(num maxIPCount)"
+  echo "subnet=$(str subnetName) $(str subnetAddressSpace)"
+elif [ -f %s ]; then
+  echo "mode=azure-vnet-ipam"
+  echo "Assigned=$(grep -o '"InUse": *true' %s | wc -l)"
+  echo "Available=$(grep -o '"InUse": *false' %s | wc -l)"
+else
+  echo "mode=none"
+fi
+NIC=$(curl -sS -m 5 -H Metadata:true %s 2>/dev/null) && echo "nic-ips=$(printf '%%s' "$NIC" | grep -o '"privateIpAddress": *"[^"]*"' | wc -l)"
+KUBELET=$(pgrep -o -x kubelet)
+MAXPODS=$(tr '\0' '\n' < "/proc/$KUBELET/cmdline" 2>/dev/null | sed -n 's/^--max-pods=//p')
+[ -n "$MAXPODS" ] || MAXPODS=$(grep -o -- '--max-pods=[0-9]*' /etc/default/kubelet 2>/dev/null | head -1 | cut -d= -f2)
+[ -n "$MAXPODS" ] || MAXPODS=$(sed -n 's/^maxPods: *//p' /var/lib/kubelet/config.yaml 2>/dev/null)
+echo "max-pods=$MAXPODS"`,
+		CNSAddress, IPAMStateFile, IPAMStateFile, IPAMStateFile, vmss.ShellQuote(imdsInterfaces))
+}
+
+// ipamNode is one node's pod IP pool.
+type ipamNode struct {
+	Node               string   `json:"node"`
+	Mode               string   `json:"mode,omitempty"`
+	Assigned           int      `json:"assigned"`
+	Available          int      `json:"available"`
+	PendingRelease     int      `json:"pendingRelease,omitempty"`
+	PendingProgramming int      `json:"pendingProgramming,omitempty"`
+	Requested          int      `json:"requested,omitempty"`
+	Batch              int      `json:"batchSize,omitempty"`
+	MaxIPs             int      `json:"maxIPCount,omitempty"`
+	Subnet             string   `json:"subnet,omitempty"`
+	SubnetCIDR         string   `json:"subnetCIDR,omitempty"`
+	NICIPs             int      `json:"nicIPs,omitempty"`
+	MaxPods            int      `json:"maxPods,omitempty"`
+	Cap                int      `json:"cap,omitempty"`
+	Use                float64  `json:"usePercent"`
+	Status             string   `json:"status"`
+	Warnings           []string `json:"warnings,omitempty"`
+	Error              string   `json:"error,omitempty"`
+}
+
+// pool is the number of IPs the node holds, in any state.
+func (n *ipamNode) pool() int {
+	return n.Assigned + n.Available + n.PendingRelease + n.PendingProgramming
+}
+
+// parseIPAMNode reads the key=value lines of buildIPAMScript.
+func parseIPAMNode(r vmss.NodeResult) ipamNode {
+	n := ipamNode{Node: r.Node}
+	if r.Err != nil {
+		n.Error = r.Err.Error()
+		return n
+	}
+	for _, line := range strings.Split(r.Result.Stdout, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		num, _ := strconv.Atoi(value)
+		switch key {
+		case "mode":
+			n.Mode = value
+		case "Assigned":
+			n.Assigned = num
+		case "Available":
+			n.Available = num
+		case "PendingRelease":
+			n.PendingRelease = num
+		case "PendingProgramming":
+			n.PendingProgramming = num
+		case "requested":
+			n.Requested = num
+		case "batch":
+			n.Batch = num
+		case "max-ips":
+			n.MaxIPs = num
+		case "subnet":
+			n.Subnet, n.SubnetCIDR, _ = strings.Cut(value, " ")
+		case "nic-ips":
+			n.NICIPs = num
+		case "max-pods":
+			n.MaxPods = num
+		}
+	}
+	if n.Mode == "" {
+		n.Error = "no pool information from node"
+		if r.Result.Stderr != "" {
+			n.Error = strings.SplitN(r.Result.Stderr, "\n", 2)[0]
+		}
+	}
+	return n
+}
+
+// evaluate sets the node's cap, use and status and explains the findings.
+func (n *ipamNode) evaluate(warn int) {
+	switch {
+	case n.Error != "":
+		n.Status = "UNKNOWN"
+		return
+	case n.Mode == "none":
+		n.Status = "-"
+		n.Warnings = append(n.Warnings, "neither CNS nor "+IPAMStateFile+" found; not Azure CNI, or overlay without a node pool")
+		return
+	}
+
+	limits := []int{n.MaxPods, n.MaxIPs}
+	if n.Mode == "azure-vnet-ipam" && n.NICIPs > 1 {
+		// the NIC's first IP is the node's own
+		limits = append(limits, n.NICIPs-1)
+	}
+	for _, l := range limits {
+		if l > 0 && (n.Cap == 0 || l < n.Cap) {
+			n.Cap = l
+		}
+	}
+	if n.Cap > 0 {
+		n.Use = float64(n.Assigned) * 100 / float64(n.Cap)
+	}
+
+	n.Status = "OK"
+	if n.Cap > 0 && n.Use >= float64(warn) {
+		n.Status = "WARN"
+		n.Warnings = append(n.Warnings, fmt.Sprintf("%d of %d pod IPs in use", n.Assigned, n.Cap))
+	}
+	if n.Available == 0 && n.pool() > 0 {
+		n.Status = "FULL"
+		n.Warnings = append(n.Warnings, "no IP available; new pods fail with \"Failed to allocate IP\" until the pool grows")
+	}
+	if n.Requested > n.pool() {
+		n.Status = "FULL"
+		n.Warnings = append(n.Warnings, fmt.Sprintf("NodeNetworkConfig requests %d IPs but the pool holds %d; the subnet may be exhausted (kubectl get nnc -n kube-system %s)", n.Requested, n.pool(), n.Node))
+	}
+	if n.PendingRelease+n.PendingProgramming > 0 {
+		if n.Status == "OK" {
+			n.Status = "WARN"
+		}
+		n.Warnings = append(n.Warnings, fmt.Sprintf("%d IPs pending release, %d pending programming", n.PendingRelease, n.PendingProgramming))
+	}
+}
+
+// subnetUsage is the pools of the reported nodes in one subnet.
+type subnetUsage struct {
+	name, cidr string
+	nodes      int
+	assigned   int
+	pool       int
+}
+
+// usableIPs returns the addresses Azure lets VMs and pods use in cidr, or
+// 0 when it does not parse.
+func usableIPs(cidr string) int {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil || !p.Addr().Is4() {
+		return 0
+	}
+	n := 1<<(32-p.Bits()) - azureReservedIPs
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func writeIPAMTable(out io.Writer, nodes []ipamNode) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tMODE\tSUBNET\tASSIGNED\tAVAILABLE\tPOOL\tREQUESTED\tNIC IPS\tCAP\tUSE\tSTATUS")
+	var notes []string
+	var subnets []*subnetUsage
+	bySubnet := map[string]*subnetUsage{}
+	for i := range nodes {
+		n := &nodes[i]
+		if n.Error != "" {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t-\t-\t-\t-\t%s\n", n.Node, n.Status)
+			notes = append(notes, fmt.Sprintf("%s: %s", n.Node, n.Error))
+			continue
+		}
+		use := "-"
+		if n.Cap > 0 {
+			use = fmt.Sprintf("%.0f%%", n.Use)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n", n.Node, n.Mode, orDash(n.Subnet),
+			n.Assigned, n.Available, n.pool(), countOrDash(n.Requested), countOrDash(n.NICIPs), countOrDash(n.Cap), use, n.Status)
+		for _, warning := range n.Warnings {
+			notes = append(notes, fmt.Sprintf("%s: %s", n.Node, warning))
+		}
+		if n.Subnet != "" {
+			s, ok := bySubnet[n.Subnet]
+			if !ok {
+				s = &subnetUsage{name: n.Subnet, cidr: n.SubnetCIDR}
+				bySubnet[n.Subnet] = s
+				subnets = append(subnets, s)
+			}
+			s.nodes++
+			s.assigned += n.Assigned
+			s.pool += n.pool()
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(notes) > 0 {
+		fmt.Fprintln(out)
+		for _, note := range notes {
+			fmt.Fprintln(out, note)
+		}
+	}
+	sort.Slice(subnets, func(i, j int) bool { return subnets[i].name < subnets[j].name })
+	if len(subnets) > 0 {
+		fmt.Fprintln(out)
+	}
+	for _, s := range subnets {
+		line := fmt.Sprintf("Subnet %s (%s): %d nodes hold %d IPs, %d assigned", s.name, orDash(s.cidr), s.nodes, s.pool, s.assigned)
+		if usable := usableIPs(s.cidr); usable > 0 {
+			line += fmt.Sprintf("; that is %.0f%% of its %d usable addresses", float64(s.pool)*100/float64(usable), usable)
+		}
+		fmt.Fprintln(out, line)
+	}
+	return nil
+}
+
+func countOrDash(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.Itoa(n)
+}
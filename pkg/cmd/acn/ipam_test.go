@@ -0,0 +1,119 @@
+package acn
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+)
+
+func ipamResult(node, stdout string) vmss.NodeResult {
+	return vmss.NodeResult{Node: node, Result: &vmss.CommandResult{Stdout: stdout}}
+}
+
+func TestParseIPAMNode(t *testing.T) {
+	n := parseIPAMNode(ipamResult("n0", `mode=cns
+Assigned=28
+Available=4
+PendingRelease=0
+PendingProgramming=0
+requested=32
+batch=16
+max-ips=250
+subnet=podsub 10.0.0.0/24
+nic-ips=1
+max-pods=30`))
+	if n.Mode != "cns" || n.Assigned != 28 || n.Available != 4 || n.Requested != 32 || n.MaxIPs != 250 ||
+		n.Subnet != "podsub" || n.SubnetCIDR != "10.0.0.0/24" || n.MaxPods != 30 || n.pool() != 32 {
+		t.Errorf("got %+v", n)
+	}
+	n.evaluate(90)
+	if n.Cap != 30 || n.Status != "WARN" || len(n.Warnings) != 1 || n.Warnings[0] != "28 of 30 pod IPs in use" {
+		t.Errorf("evaluate: %+v", n)
+	}
+
+	n = parseIPAMNode(vmss.NodeResult{Node: "n1", Err: errors.New("timeout")})
+	n.evaluate(90)
+	if n.Error != "timeout" || n.Status != "UNKNOWN" {
+		t.Errorf("failed node: %+v", n)
+	}
+}
+
+func TestEvaluateIPAM(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    string
+		status string
+		cap    int
+		warn   string
+	}{
+		{
+			name:   "pool short of request",
+			out:    "mode=cns\nAssigned=16\nAvailable=0\nrequested=32\nmax-ips=250\nmax-pods=110",
+			status: "FULL",
+			cap:    110,
+			warn:   "requests 32 IPs but the pool holds 16",
+		},
+		{
+			name:   "stuck pending",
+			out:    "mode=cns\nAssigned=3\nAvailable=10\nPendingRelease=3\nrequested=16\nmax-pods=30",
+			status: "WARN",
+			cap:    30,
+			warn:   "3 IPs pending release",
+		},
+		{
+			name:   "azure-vnet-ipam capped by the NIC",
+			out:    "mode=azure-vnet-ipam\nAssigned=10\nAvailable=20\nnic-ips=31\nmax-pods=110",
+			status: "OK",
+			cap:    30,
+		},
+		{
+			name:   "not azure cni",
+			out:    "mode=none\nmax-pods=110",
+			status: "-",
+			warn:   "neither CNS nor",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := parseIPAMNode(ipamResult("n0", tt.out))
+			n.evaluate(90)
+			if n.Status != tt.status || n.Cap != tt.cap {
+				t.Errorf("status %s cap %d, want %s cap %d", n.Status, n.Cap, tt.status, tt.cap)
+			}
+			warnings := strings.Join(n.Warnings, "\n")
+			if (tt.warn == "") != (warnings == "") || !strings.Contains(warnings, tt.warn) {
+				t.Errorf("warnings %q, want %q", warnings, tt.warn)
+			}
+		})
+	}
+}
+
+func TestWriteIPAMTable(t *testing.T) {
+	nodes := []ipamNode{
+		parseIPAMNode(ipamResult("n0", "mode=cns\nAssigned=28\nAvailable=4\nrequested=32\nsubnet=podsub 10.0.0.0/24\nmax-pods=30")),
+		parseIPAMNode(ipamResult("n1", "mode=cns\nAssigned=2\nAvailable=14\nrequested=16\nsubnet=podsub 10.0.0.0/24\nmax-pods=30")),
+		parseIPAMNode(vmss.NodeResult{Node: "n2", Err: errors.New("timeout")}),
+	}
+	for i := range nodes {
+		nodes[i].evaluate(90)
+	}
+	var out bytes.Buffer
+	if err := writeIPAMTable(&out, nodes); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"n0    cns   podsub  28        4          32    32         -        30   93%  WARN",
+		"n2    -     -       -         -          -     -          -        -    -    UNKNOWN",
+		"n0: 28 of 30 pod IPs in use",
+		"n2: timeout",
+		"Subnet podsub (10.0.0.0/24): 2 nodes hold 48 IPs, 30 assigned; that is 19% of its 251 usable addresses",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("table missing %q:\n%s", want, got)
+		}
+	}
+}
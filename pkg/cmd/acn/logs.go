@@ -2,16 +2,33 @@ package acn
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/matmerr/kubectl-vmss/pkg/nodelog"
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
+	"github.com/matmerr/kubectl-vmss/pkg/transfer"
 	"github.com/matmerr/kubectl-vmss/pkg/vmss"
 	"github.com/spf13/cobra"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
 type acnLogsOptions struct {
-	node string
-	tail int
+	node       string
+	tail       int
+	since      time.Duration
+	sinceTime  string
+	timestamps bool
+	grep       string
+	errorsOnly bool
+	output     string
+	maxSize    int64
+	chunkSize  int
 
 	runner  vmss.Runner
 	streams genericclioptions.IOStreams
@@ -20,21 +37,59 @@ type acnLogsOptions struct {
 // NewCmdACNLogs returns a cobra command for "kubectl vmss acn logs".
 func NewCmdACNLogs(streams genericclioptions.IOStreams) *cobra.Command {
 	o := &acnLogsOptions{
-		streams: streams,
+		maxSize:   1 << 20,
+		chunkSize: transfer.DefaultChunkSize,
+		streams:   streams,
 	}
 
 	cmd := &cobra.Command{
 		Use:   "logs <node>",
 		Short: "Get Azure CNI log files from a node",
-		Long:  "Retrieve Azure CNI / Azure CNS log files from an AKS node via VMSS run-command.",
+		Long: `Retrieve Azure CNI / Azure CNS log files from an AKS node via VMSS run-command.
+
+With --errors-only or -o json the lines are parsed instead of printed:
+timestamp, level, CNI operation (ADD/DEL/CHECK), container ID, pod and
+error are extracted from the azure-vnet, azure-vnet-ipam and CNS formats.
+--errors-only groups the errors by signature (the error with IDs, IPs, pod
+names and numbers masked) with counts and first and last seen times; -o json
+prints one JSON event per line, or the groups with --errors-only.
+
+Parsed lines are staged on the node and downloaded in chunks of a few KB.
+Only the newest --max-size bytes are kept, so narrow with --since.`,
 		Example: `  # Get Azure CNI logs from a node
   kubectl vmss acn logs aks-nodepool1-vmss000000
 
   # Show last 500 lines
-  kubectl vmss acn logs aks-nodepool1-vmss000000 --tail 500`,
+  kubectl vmss acn logs aks-nodepool1-vmss000000 --tail 500
+
+  # Errors from the last hour only, filtered on the node
+  kubectl vmss acn logs aks-nodepool1-vmss000000 --since 1h --grep '[Ee]rror|[Ff]ail'
+
+  # The recurring errors of the last day, grouped
+  kubectl vmss acn logs aks-nodepool1-vmss000000 --since 24h --errors-only
+
+  # Parsed events for jq
+  kubectl vmss acn logs aks-nodepool1-vmss000000 --since 1h -o json | jq 'select(.operation == "ADD")'`,
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			o.node = args[0]
+			if o.since != 0 && o.sinceTime != "" {
+				return fmt.Errorf("at most one of --since or --since-time may be specified")
+			}
+			if o.since < 0 {
+				return fmt.Errorf("--since must be greater than 0")
+			}
+			if o.sinceTime != "" {
+				if _, err := time.Parse(time.RFC3339, o.sinceTime); err != nil {
+					return fmt.Errorf("--since-time must be an RFC3339 timestamp: %w", err)
+				}
+			}
+			if o.output != "" && o.output != "json" {
+				return fmt.Errorf("unsupported output format %q (supported: json)", o.output)
+			}
+			if o.timestamps && o.parsed() {
+				return fmt.Errorf("--timestamps only applies to the raw logs, not --errors-only or -o json")
+			}
 			if o.runner == nil {
 				o.runner = vmss.NewDefaultRunner()
 			}
@@ -43,10 +98,23 @@ func NewCmdACNLogs(streams genericclioptions.IOStreams) *cobra.Command {
 	}
 
 	cmd.Flags().IntVar(&o.tail, "tail", 0, "Number of log lines to show per file (0 = all)")
+	cmd.Flags().DurationVar(&o.since, "since", 0, "Only return lines newer than a relative duration like 5s, 2m, or 3h")
+	cmd.Flags().StringVar(&o.sinceTime, "since-time", "", "Only return lines after a specific date (RFC3339)")
+	cmd.Flags().BoolVar(&o.timestamps, "timestamps", false, "Show precise ISO-8601 timestamps for journald entries (log files carry their own)")
+	cmd.Flags().StringVar(&o.grep, "grep", "", "Only return lines matching this extended regular expression (filtered on the node)")
+	cmd.Flags().BoolVar(&o.errorsOnly, "errors-only", false, "Summarize errors grouped by signature instead of printing the logs")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format: json (parsed events, or error groups with --errors-only)")
+	cmd.Flags().Int64Var(&o.maxSize, "max-size", o.maxSize, "Keep only the newest this many bytes of log lines when parsing")
+	cmd.Flags().IntVar(&o.chunkSize, "chunk-size", o.chunkSize, "Raw bytes transferred per run-command call")
 
 	return cmd
 }
 
+// parsed reports whether the logs are parsed rather than printed as-is.
+func (o *acnLogsOptions) parsed() bool {
+	return o.errorsOnly || o.output == "json"
+}
+
 func (o *acnLogsOptions) Run(ctx context.Context) error {
 	node := o.node
 
@@ -54,41 +122,304 @@ func (o *acnLogsOptions) Run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if o.parsed() {
+		return o.runParsed(ctx, info)
+	}
 
-	var script string
-	if o.tail > 0 {
-		script = fmt.Sprintf(`for f in /var/log/azure-vnet.log /var/log/azure-vnet-ipam.log /var/log/azure-vnet-ipamv2.log /var/log/azure-vnet-telemetry.log /var/log/azure-cnimonitor.log /var/log/azure-cns/azure-cns.log; do
-  if [ -f "$f" ]; then
-    echo "=== $f (last %d lines) ==="
-    tail -n %d "$f"
-    echo ""
-  fi
-done
-echo "=== azure-cns (journalctl, last %d lines) ==="
-journalctl -u azure-cns -n %d --no-pager 2>/dev/null || echo "(not available)"`, o.tail, o.tail, o.tail, o.tail)
+	script := buildACNLogsScript(o.tail, o.cutoff(), o.timestamps, o.grep)
+
+	fmt.Fprintf(o.streams.ErrOut, "Running on %s/%s...\n", info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, script)
+	if err != nil {
+		return err
+	}
+
+	if result.Stdout != "" {
+		fmt.Fprintln(o.streams.Out, result.Stdout)
+	}
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+	return nil
+}
+
+// LogFiles are the Azure CNI / CNS log files on an AKS node.
+var LogFiles = []string{
+	"/var/log/azure-vnet.log",
+	"/var/log/azure-vnet-ipam.log",
+	"/var/log/azure-vnet-ipamv2.log",
+	"/var/log/azure-vnet-telemetry.log",
+	"/var/log/azure-cnimonitor.log",
+	"/var/log/azure-cns/azure-cns.log",
+}
+
+// sinceAwk keeps lines whose leading timestamp is at or after $CUTOFF
+// ("YYYY-MM-DD HH:MM:SS", UTC). It understands both the "2006/01/02 15:04:05"
+// prefix of azure-vnet logs and the ISO-8601 timestamps of CNS. Lines without
+// a timestamp (stack traces, wrapped JSON) follow the preceding line.
+const sinceAwk = `awk -v c="$CUTOFF" 'match($0, /[0-9][0-9][0-9][0-9][-\/][0-9][0-9][-\/][0-9][0-9][ T][0-9][0-9]:[0-9][0-9]:[0-9][0-9]/) { t = substr($0, RSTART, RLENGTH); gsub(/\//, "-", t); sub(/T/, " ", t); keep = (t >= c) } keep'`
+
+// cutoff returns a shell snippet that sets $CUTOFF on the node, or "" when
+// no --since / --since-time was given. Relative durations are resolved
+// against the node's clock.
+func (o *acnLogsOptions) cutoff() string {
+	switch {
+	case o.since > 0:
+		return fmt.Sprintf(`CUTOFF=$(date -u -d "@$(( $(date +%%s) - %d ))" '+%%Y-%%m-%%d %%H:%%M:%%S')`, int64(o.since/time.Second))
+	case o.sinceTime != "":
+		t, _ := time.Parse(time.RFC3339, o.sinceTime)
+		return "CUTOFF=" + vmss.ShellQuote(t.UTC().Format("2006-01-02 15:04:05"))
+	}
+	return ""
+}
+
+// buildACNLogsScript returns the script that dumps each log file and the
+// azure-cns journal. All filtering happens on the node: since, then grep,
+// then tail, so --tail counts relevant lines rather than raw ones.
+func buildACNLogsScript(tail int, cutoff string, timestamps bool, grep string) string {
+	var desc []string
+	if cutoff != "" {
+		desc = append(desc, "since $CUTOFF UTC")
+	}
+	stages, stageDesc := filterStages(tail, grep, false)
+	desc = append(desc, stageDesc...)
+
+	fileCmd := `cat "$f"`
+	if cutoff != "" {
+		fileCmd += " | " + sinceAwk
+	}
+	for _, st := range stages {
+		fileCmd += " | " + st
+	}
+
+	journal := "journalctl -u azure-cns --no-pager"
+	if cutoff != "" {
+		journal += ` --since "$CUTOFF UTC"`
+	}
+	if timestamps {
+		journal += " -o short-iso-precise"
+	}
+	if len(stages) > 0 {
+		journal += " 2>/dev/null | " + strings.Join(stages, " | ")
 	} else {
-		script = `for f in /var/log/azure-vnet.log /var/log/azure-vnet-ipam.log /var/log/azure-vnet-ipamv2.log /var/log/azure-vnet-telemetry.log /var/log/azure-cnimonitor.log /var/log/azure-cns/azure-cns.log; do
+		journal += ` 2>/dev/null || echo "(not available)"`
+	}
+
+	fileHeader, journalHeader := "", ""
+	if len(desc) > 0 {
+		fileHeader = " (" + strings.Join(desc, ", ") + ")"
+		journalHeader = ", " + strings.Join(desc, ", ")
+	}
+
+	var b strings.Builder
+	if cutoff != "" {
+		b.WriteString(cutoff + "\n")
+	}
+	fmt.Fprintf(&b, `for f in %s; do
   if [ -f "$f" ]; then
-    echo "=== $f ==="
-    cat "$f"
+    echo "=== $f%s ==="
+    %s
     echo ""
   fi
 done
-echo "=== azure-cns (journalctl) ==="
-journalctl -u azure-cns --no-pager 2>/dev/null || echo "(not available)"`
+echo "=== azure-cns (journalctl%s) ==="
+%s`, strings.Join(LogFiles, " "), fileHeader, fileCmd, journalHeader, journal)
+	return b.String()
+}
+
+// errorLinePattern preselects lines that may carry an error for
+// --errors-only, so fewer bytes leave the node. The parser decides.
+const errorLinePattern = `err|fail|panic|fatal|unable|cannot|refused|timed? ?out`
+
+// nilErrorPattern drops the "... err:<nil>." lines of successful calls.
+const nilErrorPattern = `err(or)? *[:=] *(<nil>|nil)`
+
+// filterStages returns the pipeline stages that follow the --since filter
+// (errors, then --grep, then --tail) and their description for headers.
+func filterStages(tail int, grep string, errorsOnly bool) (stages, desc []string) {
+	if errorsOnly {
+		stages = append(stages, "grep -iE -- "+vmss.ShellQuote(errorLinePattern), "grep -viE -- "+vmss.ShellQuote(nilErrorPattern))
+	}
+	if grep != "" {
+		stages = append(stages, "grep -E -- "+vmss.ShellQuote(grep))
+		desc = append(desc, "matching --grep")
+	}
+	if tail > 0 {
+		stages = append(stages, fmt.Sprintf("tail -n %d", tail))
+		desc = append(desc, fmt.Sprintf("last %d lines", tail))
+	}
+	return stages, desc
+}
+
+// buildParsedLogsScript returns the script that filters every log file and
+// the azure-cns journal like buildACNLogsScript, prefixes each line with
+// its source and a tab, keeps the newest maxSize bytes and gzips them,
+// printing the sizes and checksums expected by transfer.ParseStaged and
+// before them "total <bytes>" of the uncut lines. Without lines it prints
+// only the total.
+func buildParsedLogsScript(tail int, cutoff, grep string, errorsOnly bool, maxSize int64) string {
+	stages, _ := filterStages(tail, grep, errorsOnly)
+	filter := ""
+	for _, st := range stages {
+		filter += " | " + st
 	}
+	since := ""
+	journalSince := ""
+	if cutoff != "" {
+		since = " | " + sinceAwk
+		journalSince = ` --since "$CUTOFF UTC"`
+	}
+	tag := `awk -v s="$SRC" '{ print s "\t" $0 }'`
+
+	var b strings.Builder
+	if cutoff != "" {
+		b.WriteString(cutoff + "\n")
+	}
+	fmt.Fprintf(&b, `ALL=$(mktemp /tmp/kubectl-vmss-acnlogs.XXXXXX)
+for f in %s; do
+  [ -f "$f" ] || continue
+  SRC="${f##*/}"
+  cat "$f"%s%s | %s >> "$ALL"
+done
+SRC=azure-cns.service
+journalctl -u azure-cns --no-pager -o short-iso-precise%s 2>/dev/null | grep -v '^-- '%s | %s >> "$ALL"
+echo "total $(stat -c %%s "$ALL")"
+if [ ! -s "$ALL" ]; then
+  rm -f "$ALL"
+  exit 0
+fi
+OUT="$ALL.cut"
+tail -c %d "$ALL" > "$OUT"
+rm -f "$ALL"
+%s`,
+		strings.Join(LogFiles, " "), since, filter, tag, journalSince, filter, tag, maxSize, nodescript.StageGzip("$OUT"))
+	return b.String()
+}
+
+// runParsed downloads the filtered log lines, parses them and prints the
+// events or the error groups.
+func (o *acnLogsOptions) runParsed(ctx context.Context, info *vmss.NodeInfo) error {
+	script := buildParsedLogsScript(o.tail, o.cutoff(), o.grep, o.errorsOnly, o.maxSize)
 
 	fmt.Fprintf(o.streams.ErrOut, "Running on %s/%s...\n", info.VMSSName, info.InstanceID)
 	result, err := o.runner.RunCommand(ctx, info, script)
 	if err != nil {
 		return err
 	}
-
-	if result.Stdout != "" {
-		fmt.Fprintln(o.streams.Out, result.Stdout)
-	}
 	if result.Stderr != "" {
 		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
 	}
+
+	var total int64
+	if _, err := fmt.Sscanf(strings.TrimSpace(result.Stdout), "total %d", &total); err != nil {
+		return fmt.Errorf("unexpected log output: %q", result.Stdout)
+	}
+	var data []byte
+	if total > 0 {
+		d := &transfer.Downloader{Runner: o.runner, Info: info, ChunkSize: o.chunkSize, Progress: o.streams.ErrOut}
+		if data, err = d.FetchStaged(ctx, result.Stdout); err != nil {
+			return err
+		}
+	}
+	if total > int64(len(data)) {
+		// drop the line cut in half by --max-size
+		if i := strings.IndexByte(string(data), '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+		defer fmt.Fprintf(o.streams.ErrOut, "Only the newest %d of %d bytes were parsed; narrow with --since or raise --max-size\n", o.maxSize, total)
+	}
+
+	entries := parseTaggedLines(string(data))
+	if o.errorsOnly {
+		groups := nodelog.GroupErrors(entries)
+		if o.output == "json" {
+			out, err := json.MarshalIndent(groups, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(o.streams.Out, string(out))
+			return nil
+		}
+		if len(groups) == 0 {
+			fmt.Fprintf(o.streams.ErrOut, "No errors in %d parsed lines\n", len(entries))
+			return nil
+		}
+		return writeErrorGroups(o.streams.Out, groups)
+	}
+
+	enc := json.NewEncoder(o.streams.Out)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// parseTaggedLines parses the "<source>\t<line>" output of
+// buildParsedLogsScript, per source so continuation lines keep the time of
+// their own file, and merges the sources in time order.
+func parseTaggedLines(data string) []nodelog.CNIEntry {
+	var sources []string
+	lines := map[string][]string{}
+	for _, l := range strings.Split(data, "\n") {
+		src, line, ok := strings.Cut(l, "\t")
+		if !ok {
+			continue
+		}
+		if _, seen := lines[src]; !seen {
+			sources = append(sources, src)
+		}
+		lines[src] = append(lines[src], line)
+	}
+	var entries []nodelog.CNIEntry
+	for _, src := range sources {
+		entries = append(entries, nodelog.ParseCNILog(src, lines[src])...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries
+}
+
+// maxExamples is how many of the most frequent errors are shown in full
+// below the summary table.
+const maxExamples = 3
+
+// writeErrorGroups prints one row per error signature, most frequent
+// first, then the latest occurrence of the top ones.
+func writeErrorGroups(out io.Writer, groups []nodelog.ErrorGroup) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COUNT\tFIRST SEEN\tLAST SEEN\tSOURCES\tPODS\tERROR")
+	for _, g := range groups {
+		pods := "-"
+		switch len(g.Pods) {
+		case 0:
+		case 1:
+			pods = g.Pods[0]
+		default:
+			pods = fmt.Sprintf("%s +%d", g.Pods[0], len(g.Pods)-1)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", g.Count, formatSeen(g.FirstSeen), formatSeen(g.LastSeen),
+			strings.Join(g.Sources, ","), pods, g.Signature)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out)
+	for i, g := range groups {
+		if i == maxExamples {
+			break
+		}
+		fmt.Fprintf(out, "Latest of #%d (%s): %s\n", i+1, formatSeen(g.LastSeen), g.Example)
+	}
+	return nil
+}
+
+func formatSeen(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
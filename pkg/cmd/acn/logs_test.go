@@ -0,0 +1,42 @@
+package acn
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// stdoutRunner answers every script with the same output.
+type stdoutRunner struct {
+	vmss.Runner
+	stdout string
+}
+
+func (r *stdoutRunner) RunCommand(context.Context, *vmss.NodeInfo, string) (*vmss.CommandResult, error) {
+	return &vmss.CommandResult{Stdout: r.stdout}, nil
+}
+
+func TestRunParsedRejectsMissingTotal(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	o := &acnLogsOptions{
+		errorsOnly: true,
+		maxSize:    1 << 20,
+		runner:     &stdoutRunner{stdout: "mktemp: failed to create file\n"},
+		streams:    genericclioptions.IOStreams{Out: &stdout, ErrOut: &stderr},
+	}
+	err := o.runParsed(context.Background(), &vmss.NodeInfo{VMSSName: "vmss", InstanceID: "0"})
+	if err == nil || !strings.Contains(err.Error(), "unexpected log output") {
+		t.Errorf("err = %v", err)
+	}
+
+	// No lines at all is not an error.
+	stdout.Reset()
+	o.runner = &stdoutRunner{stdout: "total 0\n"}
+	if err := o.runParsed(context.Background(), &vmss.NodeInfo{VMSSName: "vmss", InstanceID: "0"}); err != nil {
+		t.Errorf("empty logs: %v", err)
+	}
+}
@@ -3,12 +3,31 @@ package acn
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/matmerr/kubectl-vmss/pkg/vmss"
 	"github.com/spf13/cobra"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
+// StateFiles are the CNI config files dumped by "acn state".
+var StateFiles = []string{
+	"/etc/cni/net.d/10-azure.conflist",
+	"/etc/cni/net.d/05-cilium.conflist",
+	"/etc/cni/net.d/05-cilium.conf",
+}
+
+// CNIDownloadsDir holds the Azure CNI releases (and their configs) on a node.
+const CNIDownloadsDir = "/opt/cni/downloads/"
+
+// StateDirs are the Azure CNS / azure-vnet state directories.
+var StateDirs = []string{
+	"/var/run/azure-cns",
+	"/var/run/azure-vnet",
+	"/var/lib/azure-cns",
+	"/opt/cns",
+}
+
 type acnStateOptions struct {
 	node string
 
@@ -49,10 +68,7 @@ func (o *acnStateOptions) Run(ctx context.Context) error {
 		return err
 	}
 
-	script := `for f in \
-  /etc/cni/net.d/10-azure.conflist \
-  /etc/cni/net.d/05-cilium.conflist \
-  /etc/cni/net.d/05-cilium.conf; do
+	script := fmt.Sprintf(`for f in %s; do
   if [ -f "$f" ]; then
     echo "=== $f ==="
     cat "$f"
@@ -60,13 +76,13 @@ func (o *acnStateOptions) Run(ctx context.Context) error {
   fi
 done
 # Azure CNI downloads (may contain multiple versions)
-for f in $(find /opt/cni/downloads/ -name '*.conflist' -o -name '*.json' 2>/dev/null); do
+for f in $(find %s -name '*.conflist' -o -name '*.json' 2>/dev/null); do
   echo "=== $f ==="
   cat "$f"
   echo ""
 done
 # Azure CNS / azure-vnet state directories
-for d in /var/run/azure-cns /var/run/azure-vnet /var/lib/azure-cns /opt/cns; do
+for d in %s; do
   if [ -d "$d" ]; then
     echo "=== $d ==="
     find "$d" -type f | while read sf; do
@@ -75,7 +91,7 @@ for d in /var/run/azure-cns /var/run/azure-vnet /var/lib/azure-cns /opt/cns; do
       echo ""
     done
   fi
-done`
+done`, strings.Join(StateFiles, " "), CNIDownloadsDir, strings.Join(StateDirs, " "))
 
 	fmt.Fprintf(o.streams.ErrOut, "Running on %s/%s...\n", info.VMSSName, info.InstanceID)
 	result, err := o.runner.RunCommand(ctx, info, script)
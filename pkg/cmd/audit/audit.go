@@ -0,0 +1,19 @@
+package audit
+
+import (
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// NewCmdAudit returns the parent "audit" command with subcommand pods.
+func NewCmdAudit(streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Cross-check a node's state against the API server via VMSS run-command",
+		Long:  "Compare what actually runs on an AKS node, as seen through VMSS run-command, with what the API server believes runs there.",
+	}
+
+	cmd.AddCommand(NewCmdAuditPods(streams))
+
+	return cmd
+}
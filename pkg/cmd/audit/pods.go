@@ -0,0 +1,469 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// Finding kinds reported by audit pods.
+const (
+	kindOrphan   = "orphan"
+	kindMissing  = "missing"
+	kindMismatch = "ip-mismatch"
+)
+
+type auditPodsOptions struct {
+	node    string
+	cleanup bool
+	yes     bool
+	output  string
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdAuditPods returns a cobra command for "kubectl vmss audit pods".
+func NewCmdAuditPods(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &auditPodsOptions{
+		streams: streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "pods <node>",
+		Short: "Find orphan sandboxes, missing pods and pod IP mismatches on a node",
+		Long: `Compare the pod sandboxes crictl reports on a node with the pods the API
+server has bound to it (spec.nodeName) and report:
+
+  orphan       a sandbox whose pod the API server no longer knows; a ready
+               one still holds its pod IP
+  missing      a running API pod with no ready sandbox on the node
+  ip-mismatch  a ready sandbox whose netns addresses differ from the pod's
+               status.podIPs
+
+The comparison runs on the node, so only the findings come back through
+run-command's output limit. Mirror pods are matched through their static
+pod's UID, and host-network pods are not checked for IPs. Pending pods
+without a sandbox are listed in the notes rather than as missing.
+
+With --cleanup, orphan sandboxes are stopped and removed with crictl stopp
+and rmp after confirmation; stopping a sandbox runs CNI DEL, which releases
+its IP. Orphans whose pod has appeared in the API server since the audit
+are left alone.`,
+		Example: `  # Audit a node
+  kubectl vmss audit pods aks-nodepool1-vmss000000
+
+  # Remove the orphan sandboxes it finds
+  kubectl vmss audit pods aks-nodepool1-vmss000000 --cleanup`,
+		Aliases: []string{"pod", "po"},
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.node = args[0]
+			if o.yes && !o.cleanup {
+				return fmt.Errorf("--yes only applies with --cleanup")
+			}
+			if o.output != "" && o.output != "json" {
+				return fmt.Errorf("unsupported output format %q (supported: json)", o.output)
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().BoolVar(&o.cleanup, "cleanup", false, "Stop and remove orphan sandboxes after confirmation")
+	cmd.Flags().BoolVarP(&o.yes, "yes", "y", false, "Do not ask for confirmation before --cleanup")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format: json")
+
+	return cmd
+}
+
+// podAudit is the result of auditing one node.
+type podAudit struct {
+	Node           string    `json:"node"`
+	Sandboxes      int       `json:"sandboxes"`
+	ReadySandboxes int       `json:"readySandboxes"`
+	APIPods        int       `json:"apiPods"`
+	Findings       []finding `json:"findings"`
+	// Pending lists API pods without a sandbox that are still starting.
+	Pending []string `json:"pending,omitempty"`
+}
+
+// finding is one discrepancy between the node and the API server.
+type finding struct {
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	UID       string   `json:"uid"`
+	SandboxID string   `json:"sandboxID,omitempty"`
+	State     string   `json:"state,omitempty"`
+	APIIPs    []string `json:"apiIPs,omitempty"`
+	NodeIPs   []string `json:"nodeIPs,omitempty"`
+}
+
+// Run executes the audit pods command.
+func (o *auditPodsOptions) Run(ctx context.Context) error {
+	info, err := o.runner.ResolveVMSS(ctx, o.node)
+	if err != nil {
+		return err
+	}
+	pods, err := o.runner.ListPodsOnNode(ctx, o.node)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.streams.ErrOut, "Running on %s/%s...\n", info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, buildAuditScript(pods))
+	if err != nil {
+		return err
+	}
+	audit, err := parseAudit(o.node, result.Stdout, pods)
+	if err != nil {
+		if result.Stderr != "" {
+			fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+		}
+		return err
+	}
+
+	if o.output == "json" {
+		data, err := json.MarshalIndent(audit, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.streams.Out, string(data))
+	} else if err := writeAudit(o.streams.Out, audit); err != nil {
+		return err
+	}
+
+	if o.cleanup {
+		return o.cleanupOrphans(ctx, info, audit)
+	}
+	return nil
+}
+
+// podKey returns the UID the CRI knows p by.
+func podKey(p *vmss.Pod) string {
+	if p.Mirror != "" {
+		return p.Mirror
+	}
+	return p.UID
+}
+
+// buildAuditScript returns the script that compares the node's sandboxes
+// with pods, passed in as "uid ips" lines where ips is a comma-separated
+// list, or "-" when the pod's IPs are not checked. It prints
+//
+//	sandbox <id> <state> <uid> <namespace> <name> <netns ips>
+//
+// for sandboxes of unknown pods and for ready sandboxes whose IPs differ,
+// "missing <uid>" for pods without a ready sandbox, and a final
+// "summary <sandboxes> <ready>" line. The netns IPs are "host" for sandboxes
+// in the host network namespace, "none" when the netns has no global
+// address and "-" when they could not be read, as for sandboxes that are
+// not ready.
+func buildAuditScript(pods []vmss.Pod) string {
+	var api strings.Builder
+	for i := range pods {
+		p := &pods[i]
+		ips := "-"
+		if !p.HostNetwork && len(p.IPs) > 0 {
+			ips = strings.Join(p.IPs, ",")
+		}
+		fmt.Fprintf(&api, "%s %s\n", podKey(p), ips)
+	}
+
+	return fmt.Sprintf(`API=$(cat <<'EOF_API'
+%sEOF_API
+)
+SB=$(mktemp)
+trap 'rm -f "$SB"' EXIT
+crictl pods -o json 2>/dev/null | %s | awk '
+{ k = substr($0, 1, index($0, "=") - 1); v = substr($0, index($0, "=") + 1) }
+k ~ /^items\.[0-9]+\./ {
+  split(k, p, "."); i = p[2]; f = substr(k, length("items." i ".") + 1)
+  if (i + 1 > n) n = i + 1
+  if (f == "id") id[i] = v
+  else if (f == "state") st[i] = v
+  else if (f == "metadata.uid") uid[i] = v
+  else if (f == "metadata.namespace") ns[i] = v
+  else if (f == "metadata.name") nm[i] = v
+}
+END { for (i = 0; i < n; i++) print id[i], st[i], uid[i], ns[i], nm[i] }' > "$SB"
+sorted() { printf '%%s\n' "$1" | tr , '\n' | LC_ALL=C sort; }
+HOSTNS=$(readlink /proc/1/ns/net)
+TOTAL=0; READY=0; READY_UIDS=" "
+while read -r ID STATE PUID NS NAME; do
+  [ -n "$ID" ] || continue
+  TOTAL=$((TOTAL + 1))
+  WANT=$(printf '%%s\n' "$API" | awk -v u="$PUID" '$1 == u { print $2; exit }')
+  IPS=-
+  if [ "$STATE" = SANDBOX_READY ]; then
+    READY=$((READY + 1)); READY_UIDS="$READY_UIDS$PUID "
+    PID=$(crictl inspectp "$ID" 2>/dev/null | %s)
+    if [ -n "$PID" ] && [ "$PID" != 0 ]; then
+      if [ "$(readlink /proc/$PID/ns/net)" = "$HOSTNS" ]; then
+        IPS=host
+      else
+        IPS=$(nsenter -t "$PID" -n ip -o addr show scope global 2>/dev/null | awk '{ sub(/\/.*/, "", $4); print $4 }' | paste -sd, -)
+        [ -n "$IPS" ] || IPS=none
+      fi
+    fi
+  fi
+  if [ -z "$WANT" ]; then
+    echo "sandbox $ID $STATE $PUID $NS $NAME $IPS"
+  elif [ "$STATE" = SANDBOX_READY ] && [ "$WANT" != - ] && [ "$IPS" != - ] && [ "$IPS" != host ] && [ "$(sorted "$WANT")" != "$(sorted "$IPS")" ]; then
+    echo "sandbox $ID $STATE $PUID $NS $NAME $IPS"
+  fi
+done < "$SB"
+printf '%%s\n' "$API" | while read -r PUID WANT; do
+  [ -n "$PUID" ] || continue
+  case "$READY_UIDS" in *" $PUID "*) ;; *) echo "missing $PUID" ;; esac
+done
+echo "summary $TOTAL $READY"`, api.String(), nodescript.JSONFlatten(), nodescript.JSONNumber("pid"))
+}
+
+// parseAudit turns the output of buildAuditScript into findings against
+// pods, the API server's view of the node.
+func parseAudit(node, stdout string, pods []vmss.Pod) (*podAudit, error) {
+	byKey := make(map[string]*vmss.Pod, len(pods))
+	for i := range pods {
+		byKey[podKey(&pods[i])] = &pods[i]
+	}
+
+	audit := &podAudit{Node: node, APIPods: len(pods), Findings: []finding{}}
+	summary := false
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "sandbox" && len(fields) == 7:
+			f := finding{
+				Kind:      kindOrphan,
+				Namespace: fields[4],
+				Name:      fields[5],
+				UID:       fields[3],
+				SandboxID: fields[1],
+				State:     strings.TrimPrefix(fields[2], "SANDBOX_"),
+				NodeIPs:   splitIPs(fields[6]),
+			}
+			if p, ok := byKey[f.UID]; ok {
+				f.Kind = kindMismatch
+				f.APIIPs = p.IPs
+			}
+			audit.Findings = append(audit.Findings, f)
+		case fields[0] == "missing" && len(fields) == 2:
+			p, ok := byKey[fields[1]]
+			if !ok {
+				continue
+			}
+			switch p.Phase {
+			case "Succeeded", "Failed":
+				// Finished pods keep their API object but not their sandbox.
+			case "Pending":
+				audit.Pending = append(audit.Pending, p.Namespace+"/"+p.Name)
+			default:
+				audit.Findings = append(audit.Findings, finding{
+					Kind:      kindMissing,
+					Namespace: p.Namespace,
+					Name:      p.Name,
+					UID:       p.UID,
+					APIIPs:    p.IPs,
+				})
+			}
+		case fields[0] == "summary" && len(fields) == 3:
+			audit.Sandboxes, _ = strconv.Atoi(fields[1])
+			audit.ReadySandboxes, _ = strconv.Atoi(fields[2])
+			summary = true
+		}
+	}
+	if !summary {
+		return nil, fmt.Errorf("could not list sandboxes on %s: unexpected output %q", node, stdout)
+	}
+
+	order := map[string]int{kindOrphan: 0, kindMissing: 1, kindMismatch: 2}
+	sort.SliceStable(audit.Findings, func(i, j int) bool {
+		a, b := audit.Findings[i], audit.Findings[j]
+		if a.Kind != b.Kind {
+			return order[a.Kind] < order[b.Kind]
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return audit, nil
+}
+
+// splitIPs returns the addresses in a netns IP field of the audit script,
+// or nil for its "-", "host" and "none" placeholders.
+func splitIPs(s string) []string {
+	switch s {
+	case "-", "host", "none":
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// orphans returns the orphan findings of a.
+func (a *podAudit) orphans() []finding {
+	var out []finding
+	for _, f := range a.Findings {
+		if f.Kind == kindOrphan {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func writeAudit(out io.Writer, a *podAudit) error {
+	fmt.Fprintf(out, "%d sandboxes (%d ready) on %s; %d pods bound to it in the API server.\n",
+		a.Sandboxes, a.ReadySandboxes, a.Node, a.APIPods)
+	if len(a.Findings) == 0 {
+		fmt.Fprintln(out, "No orphan sandboxes, missing pods or IP mismatches.")
+	} else {
+		fmt.Fprintln(out)
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tNAMESPACE\tNAME\tSANDBOX\tSTATE\tAPI IPS\tNODE IPS")
+		for _, f := range a.Findings {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", f.Kind, f.Namespace, f.Name,
+				orDash(shortID(f.SandboxID)), orDash(f.State), joinIPs(f.APIIPs), joinIPs(f.NodeIPs))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	var notes []string
+	if n := len(a.orphans()); n > 0 {
+		notes = append(notes, fmt.Sprintf("%d orphan sandboxes: their pods are gone from the API server, and ready ones still hold their IPs. Pods deleted moments ago may still be tearing down; rerun with --cleanup to stop and remove the rest.", n))
+	}
+	for _, p := range a.Pending {
+		notes = append(notes, fmt.Sprintf("%s is Pending without a sandbox yet.", p))
+	}
+	if len(notes) > 0 {
+		fmt.Fprintln(out)
+		for _, note := range notes {
+			fmt.Fprintln(out, note)
+		}
+	}
+	return nil
+}
+
+// cleanupOrphans stops and removes the orphan sandboxes of a after asking
+// for confirmation on the input stream. The API server is listed again
+// first, so a pod created while the audit ran is not mistaken for an orphan.
+func (o *auditPodsOptions) cleanupOrphans(ctx context.Context, info *vmss.NodeInfo, a *podAudit) error {
+	orphans := a.orphans()
+	if len(orphans) == 0 {
+		fmt.Fprintln(o.streams.ErrOut, "No orphan sandboxes to clean up.")
+		return nil
+	}
+	pods, err := o.runner.ListPodsOnNode(ctx, o.node)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(pods))
+	for i := range pods {
+		known[podKey(&pods[i])] = true
+	}
+	var ids []string
+	names := map[string]string{}
+	for _, f := range orphans {
+		if known[f.UID] {
+			fmt.Fprintf(o.streams.ErrOut, "Skipping %s/%s: the pod now exists in the API server.\n", f.Namespace, f.Name)
+			continue
+		}
+		ids = append(ids, f.SandboxID)
+		names[f.SandboxID] = f.Namespace + "/" + f.Name
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if !o.yes {
+		fmt.Fprintf(o.streams.ErrOut, "Stop and remove %d orphan sandboxes on %s? [y/N]: ", len(ids), o.node)
+		answer, _ := bufio.NewReader(o.streams.In).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Fprintln(o.streams.ErrOut, "Cleanup cancelled.")
+			return nil
+		}
+	}
+
+	result, err := o.runner.RunCommand(ctx, info, buildCleanupScript(ids))
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		status, id, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		switch status {
+		case "removed":
+			fmt.Fprintf(o.streams.Out, "Removed sandbox %s (%s)\n", shortID(id), names[id])
+		case "failed":
+			fmt.Fprintf(o.streams.Out, "Failed to remove sandbox %s (%s)\n", shortID(id), names[id])
+			failed++
+		}
+	}
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d orphan sandboxes could not be removed", failed, len(ids))
+	}
+	return nil
+}
+
+// buildCleanupScript returns the script that stops and removes the
+// sandboxes ids, printing "removed <id>" or "failed <id>" for each.
+func buildCleanupScript(ids []string) string {
+	quoted := make([]string, len(ids))
+	for i, id := range ids {
+		quoted[i] = vmss.ShellQuote(id)
+	}
+	return fmt.Sprintf(`for ID in %s; do
+  if crictl stopp "$ID" >/dev/null && crictl rmp "$ID" >/dev/null; then
+    echo "removed $ID"
+  else
+    echo "failed $ID"
+  fi
+done`, strings.Join(quoted, " "))
+}
+
+// shortID truncates a sandbox ID the way crictl pods prints it.
+func shortID(id string) string {
+	if len(id) > 13 {
+		return id[:13]
+	}
+	return id
+}
+
+func joinIPs(ips []string) string {
+	if len(ips) == 0 {
+		return "-"
+	}
+	return strings.Join(ips, ",")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+var testPods = []vmss.Pod{
+	{Namespace: "default", Name: "web-0", UID: "u1", Phase: "Running", IPs: []string{"10.0.0.9", "fd00::9"}},
+	{Namespace: "default", Name: "api-0", UID: "u2", Phase: "Running", IPs: []string{"10.0.0.10"}},
+	{Namespace: "default", Name: "gone", UID: "u4", Phase: "Running", IPs: []string{"10.0.0.12"}},
+	{Namespace: "default", Name: "new", UID: "u6", Phase: "Pending"},
+	{Namespace: "default", Name: "job-x", UID: "u7", Phase: "Succeeded"},
+	{Namespace: "kube-system", Name: "kube-proxy-x", UID: "u5", Phase: "Running", HostNetwork: true, IPs: []string{"10.1.0.4"}},
+	{Namespace: "kube-system", Name: "etcd-x", UID: "u8", Phase: "Running", Mirror: "static1"},
+}
+
+const testAuditOutput = `sandbox 0123456789abcdef0 SANDBOX_READY u2 default api-0 10.0.0.99
+sandbox fedcba9876543210 SANDBOX_READY u3 default old-0 10.0.0.50
+sandbox 5555 SANDBOX_NOTREADY u9 default older-0 -
+missing u4
+missing u6
+missing u7
+summary 7 5`
+
+func TestBuildAuditScript(t *testing.T) {
+	script := buildAuditScript(testPods)
+	for _, want := range []string{
+		"u1 10.0.0.9,fd00::9\n",
+		"u5 -\n",
+		"static1 -\n",
+		"u6 -\nu7 -\n",
+		"crictl pods -o json",
+		`nsenter -t "$PID" -n ip -o addr show scope global`,
+		`echo "summary $TOTAL $READY"`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q", want)
+		}
+	}
+	if strings.Contains(script, "u8") {
+		t.Error("mirror pod passed by its API UID")
+	}
+}
+
+func TestParseAudit(t *testing.T) {
+	a, err := parseAudit("n0", testAuditOutput, testPods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Sandboxes != 7 || a.ReadySandboxes != 5 || a.APIPods != 7 {
+		t.Errorf("counts = %+v", a)
+	}
+	var kinds []string
+	for _, f := range a.Findings {
+		kinds = append(kinds, f.Kind+" "+f.Name)
+	}
+	want := "orphan old-0,orphan older-0,missing gone,ip-mismatch api-0"
+	if got := strings.Join(kinds, ","); got != want {
+		t.Errorf("findings = %s, want %s", got, want)
+	}
+	if f := a.Findings[3]; f.APIIPs[0] != "10.0.0.10" || f.NodeIPs[0] != "10.0.0.99" || f.State != "READY" {
+		t.Errorf("mismatch = %+v", f)
+	}
+	if f := a.Findings[1]; f.NodeIPs != nil || f.State != "NOTREADY" {
+		t.Errorf("not ready orphan = %+v", f)
+	}
+	if len(a.Pending) != 1 || a.Pending[0] != "default/new" {
+		t.Errorf("pending = %v", a.Pending)
+	}
+
+	if _, err := parseAudit("n0", "crictl: command not found", testPods); err == nil {
+		t.Error("expected an error without a summary line")
+	}
+}
+
+func TestWriteAudit(t *testing.T) {
+	a, err := parseAudit("n0", testAuditOutput, testPods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := writeAudit(&out, a); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"7 sandboxes (5 ready) on n0; 7 pods bound to it in the API server.",
+		"orphan       default    old-0    fedcba9876543  READY     -          10.0.0.50",
+		"missing      default    gone     -              -         10.0.0.12  -",
+		"ip-mismatch  default    api-0    0123456789abc  READY     10.0.0.10  10.0.0.99",
+		"2 orphan sandboxes",
+		"default/new is Pending without a sandbox yet.",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+}
+
+// cleanupRunner serves the audit and cleanup scripts of one node.
+type cleanupRunner struct {
+	vmss.Runner
+	pods    []vmss.Pod
+	scripts []string
+}
+
+func (r *cleanupRunner) ResolveVMSS(context.Context, string) (*vmss.NodeInfo, error) {
+	return &vmss.NodeInfo{VMSSName: "vmss", InstanceID: "0"}, nil
+}
+
+func (r *cleanupRunner) ListPodsOnNode(context.Context, string) ([]vmss.Pod, error) {
+	return r.pods, nil
+}
+
+func (r *cleanupRunner) RunCommand(_ context.Context, _ *vmss.NodeInfo, script string) (*vmss.CommandResult, error) {
+	r.scripts = append(r.scripts, script)
+	if strings.Contains(script, "crictl stopp") {
+		return &vmss.CommandResult{Stdout: "removed fedcba9876543210\nremoved 5555"}, nil
+	}
+	return &vmss.CommandResult{Stdout: testAuditOutput}, nil
+}
+
+func TestCleanup(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  string
+		yes     bool
+		cleaned bool
+	}{
+		{name: "confirmed", answer: "y\n", cleaned: true},
+		{name: "declined", answer: "\n"},
+		{name: "yes flag", yes: true, cleaned: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &cleanupRunner{pods: testPods}
+			var out, errOut bytes.Buffer
+			o := &auditPodsOptions{
+				node:    "n0",
+				cleanup: true,
+				yes:     tt.yes,
+				runner:  runner,
+				streams: genericclioptions.IOStreams{In: strings.NewReader(tt.answer), Out: &out, ErrOut: &errOut},
+			}
+			if err := o.Run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(runner.scripts) == 2; got != tt.cleaned {
+				t.Fatalf("cleanup ran = %v, want %v", got, tt.cleaned)
+			}
+			if prompted := strings.Contains(errOut.String(), "Stop and remove 2 orphan sandboxes on n0? [y/N]"); prompted == tt.yes {
+				t.Errorf("prompted = %v with --yes %v", prompted, tt.yes)
+			}
+			if !tt.cleaned {
+				return
+			}
+			if !strings.Contains(runner.scripts[1], "for ID in 'fedcba9876543210' '5555'; do") {
+				t.Errorf("cleanup script:\n%s", runner.scripts[1])
+			}
+			if !strings.Contains(out.String(), "Removed sandbox fedcba9876543 (default/old-0)") {
+				t.Errorf("output:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestCleanupSkipsPodsCreatedSinceAudit(t *testing.T) {
+	runner := &cleanupRunner{pods: testPods}
+	o := &auditPodsOptions{node: "n0", cleanup: true, yes: true, runner: runner}
+	var errOut bytes.Buffer
+	o.streams = genericclioptions.IOStreams{Out: &bytes.Buffer{}, ErrOut: &errOut}
+
+	a, err := parseAudit("n0", testAuditOutput, testPods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.pods = append(runner.pods, vmss.Pod{Namespace: "default", Name: "old-0", UID: "u3", Phase: "Pending"})
+	if err := o.cleanupOrphans(context.Background(), &vmss.NodeInfo{}, a); err != nil {
+		t.Fatal(err)
+	}
+	if len(runner.scripts) != 1 || strings.Contains(runner.scripts[0], "fedcba9876543210") {
+		t.Errorf("cleanup scripts = %q", runner.scripts)
+	}
+	if !strings.Contains(errOut.String(), "Skipping default/old-0") {
+		t.Errorf("stderr:\n%s", errOut.String())
+	}
+}
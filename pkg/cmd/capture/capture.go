@@ -0,0 +1,191 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
+	"github.com/matmerr/kubectl-vmss/pkg/transfer"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// maxDuration keeps a capture well inside run-command's 90 minute limit.
+const maxDuration = time.Hour
+
+type captureOptions struct {
+	namespace string
+	pod       string
+	node      string
+	iface     string
+	filter    string
+	duration  time.Duration
+	snaplen   int
+	maxSize   int64
+	chunkSize int
+	output    string
+	tcpdump   string
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdCapture returns a cobra command for "kubectl vmss capture".
+func NewCmdCapture(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &captureOptions{
+		namespace: "kube-system",
+		iface:     "any",
+		duration:  30 * time.Second,
+		maxSize:   1 << 20,
+		chunkSize: transfer.DefaultChunkSize,
+		tcpdump:   "tcpdump",
+		streams:   streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "capture [<pod>] [--node <node>]",
+		Short: "Capture packets in a pod's network namespace or on a node",
+		Long: `Run tcpdump on an AKS node via VMSS run-command and download the result as
+a pcap file, ready for Wireshark.
+
+Given a pod, tcpdump runs in the pod's network namespace, entered through
+its sandbox with nsenter, so it works while the pod's containers
+crash-loop. With only --node, it captures on the node's own interfaces.
+
+The capture stops after --duration or once --max-size bytes have been
+written, whichever comes first; a packet cut off by the size limit is
+dropped from the local file. Downloads move a few KB per run-command call,
+so keep captures small with --filter and --snaplen.`,
+		Example: `  # DNS traffic of a pod for 30 seconds
+  kubectl vmss capture coredns-abc --filter "port 53"
+
+  # SNAT investigation: outbound TCP SYNs on the node's primary interface
+  kubectl vmss capture --node aks-nodepool1-vmss000000 -i eth0 --filter "tcp[tcpflags] & tcp-syn != 0" --duration 2m
+
+  # Headers only, into a chosen file
+  kubectl vmss capture my-app -n default --snaplen 128 -o my-app.pcap`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				o.pod = args[0]
+			}
+			if o.pod == "" && o.node == "" {
+				return fmt.Errorf("requires a pod, or --node to capture on the node itself")
+			}
+			if o.duration < time.Second || o.duration > maxDuration {
+				return fmt.Errorf("--duration must be between 1s and %s", maxDuration)
+			}
+			if o.maxSize < 1024 {
+				return fmt.Errorf("--max-size must be at least 1024 bytes")
+			}
+			if o.output == "" {
+				o.output = o.pod + ".pcap"
+				if o.pod == "" {
+					o.output = o.node + ".pcap"
+				}
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", o.namespace, "Namespace for pod lookup")
+	cmd.Flags().StringVar(&o.node, "node", "", "Capture on this node's interfaces (or target the pod on this node)")
+	cmd.Flags().StringVarP(&o.iface, "interface", "i", o.iface, "Interface to capture on")
+	cmd.Flags().StringVar(&o.filter, "filter", "", "tcpdump filter expression, e.g. \"port 53\"")
+	cmd.Flags().DurationVar(&o.duration, "duration", o.duration, "How long to capture")
+	cmd.Flags().IntVar(&o.snaplen, "snaplen", 0, "Bytes to keep per packet (0 = whole packet)")
+	cmd.Flags().Int64Var(&o.maxSize, "max-size", o.maxSize, "Stop capturing after this many bytes of pcap")
+	cmd.Flags().IntVar(&o.chunkSize, "chunk-size", o.chunkSize, "Raw bytes transferred per run-command call")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Local path for the pcap (default <pod|node>.pcap)")
+	cmd.Flags().StringVar(&o.tcpdump, "tcpdump", o.tcpdump, "tcpdump binary on the node, e.g. one uploaded with \"kubectl vmss cp\"")
+
+	return cmd
+}
+
+// Run executes the capture command.
+func (o *captureOptions) Run(ctx context.Context) error {
+	node := o.node
+	if node == "" {
+		var err error
+		node, err = o.runner.ResolveNodeFromPod(ctx, o.namespace, o.pod)
+		if err != nil {
+			return err
+		}
+	}
+	info, err := o.runner.ResolveVMSS(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	target := "node " + node
+	if o.pod != "" {
+		target = "pod " + o.namespace + "/" + o.pod
+	}
+	fmt.Fprintf(o.streams.ErrOut, "Capturing on %s interface %s for %s on %s/%s...\n",
+		target, o.iface, o.duration, info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, o.buildCaptureScript())
+	if err != nil {
+		return err
+	}
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+	orig, staged, err := transfer.ParseStaged(result.Stdout)
+	if err != nil {
+		return fmt.Errorf("capture produced no pcap: %w", err)
+	}
+	defer transfer.Remove(context.WithoutCancel(ctx), o.runner, info, staged.Path)
+
+	d := &transfer.Downloader{Runner: o.runner, Info: info, ChunkSize: o.chunkSize, Progress: o.streams.ErrOut}
+	if err := d.FetchStagedFile(ctx, orig, staged, o.output, false); err != nil {
+		return err
+	}
+
+	packets, trimmed, err := trimPcap(o.output)
+	if err != nil {
+		return err
+	}
+	note := ""
+	if trimmed || orig.Size >= o.maxSize {
+		note = " (stopped at --max-size)"
+	}
+	fmt.Fprintf(o.streams.Out, "Saved %d packets to %s%s\n", packets, o.output, note)
+	return nil
+}
+
+// buildCaptureScript returns the script that runs tcpdump for the capture
+// duration, cuts its output at maxSize bytes and gzips the pcap, printing
+// the sizes and checksums expected by transfer.ParseStaged.
+func (o *captureOptions) buildCaptureScript() string {
+	enter := `NSENTER=""`
+	if o.pod != "" {
+		enter = nodescript.SandboxPID(o.namespace, o.pod) + "\n" + `NSENTER="nsenter -t $SANDBOX_PID -n --"`
+	}
+	filter := ""
+	if o.filter != "" {
+		filter = " " + vmss.ShellQuote(o.filter)
+	}
+
+	return fmt.Sprintf(`%s
+TCPDUMP=%s
+if ! command -v "$TCPDUMP" >/dev/null 2>&1; then
+  echo "Error: $TCPDUMP not found on the node; upload a static build with 'kubectl vmss cp' and pass --tcpdump" >&2
+  exit 1
+fi
+OUT=$(mktemp /tmp/kubectl-vmss-capture.XXXXXX)
+timeout %d $NSENTER "$TCPDUMP" -i %s -s %d -n -U -w -%s 2>"$OUT.log" | head -c %d > "$OUT"
+grep -v '^tcpdump: listening' "$OUT.log" >&2
+rm -f "$OUT.log"
+if [ ! -s "$OUT" ]; then
+  rm -f "$OUT"
+  exit 1
+fi
+%s`,
+		enter, vmss.ShellQuote(o.tcpdump),
+		int64(o.duration/time.Second), vmss.ShellQuote(o.iface), o.snaplen, filter, o.maxSize, nodescript.StageGzip("$OUT"))
+}
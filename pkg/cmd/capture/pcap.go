@@ -0,0 +1,50 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+const (
+	pcapHeaderLen = 24
+	pcapRecordLen = 16
+)
+
+// trimPcap truncates a classic pcap file after its last complete packet and
+// returns the number of packets kept. Captures are cut at --max-size on the
+// node with head -c, which usually splits the final packet; Wireshark
+// complains about such files, so the partial record is dropped here.
+func trimPcap(path string) (packets int, trimmed bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(data) < pcapHeaderLen {
+		return 0, false, fmt.Errorf("%s: truncated pcap header (%d bytes)", path, len(data))
+	}
+
+	var order binary.ByteOrder
+	switch binary.BigEndian.Uint32(data) {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		order = binary.BigEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		order = binary.LittleEndian
+	default:
+		return 0, false, fmt.Errorf("%s: not a pcap file", path)
+	}
+
+	end := pcapHeaderLen
+	for end+pcapRecordLen <= len(data) {
+		next := end + pcapRecordLen + int(order.Uint32(data[end+8:]))
+		if next > len(data) {
+			break
+		}
+		end = next
+		packets++
+	}
+	if end == len(data) {
+		return packets, false, nil
+	}
+	return packets, true, os.Truncate(path, int64(end))
+}
@@ -0,0 +1,68 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testPcap(order binary.ByteOrder, payloads ...[]byte) []byte {
+	var b bytes.Buffer
+	hdr := make([]byte, pcapHeaderLen)
+	order.PutUint32(hdr, 0xa1b2c3d4)
+	order.PutUint16(hdr[4:], 2)
+	order.PutUint16(hdr[6:], 4)
+	order.PutUint32(hdr[16:], 262144)
+	order.PutUint32(hdr[20:], 113) // LINKTYPE_LINUX_SLL
+	b.Write(hdr)
+	for i, p := range payloads {
+		rec := make([]byte, pcapRecordLen)
+		order.PutUint32(rec, uint32(1700000000+i))
+		order.PutUint32(rec[8:], uint32(len(p)))
+		order.PutUint32(rec[12:], uint32(len(p)))
+		b.Write(rec)
+		b.Write(p)
+	}
+	return b.Bytes()
+}
+
+func TestTrimPcap(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		full := testPcap(order, bytes.Repeat([]byte{1}, 60), bytes.Repeat([]byte{2}, 90), bytes.Repeat([]byte{3}, 120))
+		tests := []struct {
+			size        int
+			wantPackets int
+			wantSize    int
+			wantTrimmed bool
+		}{
+			{size: len(full), wantPackets: 3, wantSize: len(full)},
+			{size: len(full) - 50, wantPackets: 2, wantSize: pcapHeaderLen + 2*pcapRecordLen + 150, wantTrimmed: true},
+			{size: pcapHeaderLen + 10, wantPackets: 0, wantSize: pcapHeaderLen, wantTrimmed: true},
+			{size: pcapHeaderLen, wantPackets: 0, wantSize: pcapHeaderLen},
+		}
+
+		for _, tt := range tests {
+			path := filepath.Join(t.TempDir(), "capture.pcap")
+			if err := os.WriteFile(path, full[:tt.size], 0o644); err != nil {
+				t.Fatal(err)
+			}
+			packets, trimmed, err := trimPcap(path)
+			if err != nil {
+				t.Fatalf("%v size %d: %v", order, tt.size, err)
+			}
+			st, _ := os.Stat(path)
+			if packets != tt.wantPackets || trimmed != tt.wantTrimmed || st.Size() != int64(tt.wantSize) {
+				t.Errorf("%v size %d: got %d packets, trimmed %v, %d bytes; want %d, %v, %d",
+					order, tt.size, packets, trimmed, st.Size(), tt.wantPackets, tt.wantTrimmed, tt.wantSize)
+			}
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "notpcap")
+	os.WriteFile(path, bytes.Repeat([]byte("x"), 100), 0o644)
+	if _, _, err := trimPcap(path); err == nil {
+		t.Error("trimPcap should reject non-pcap data")
+	}
+}
@@ -0,0 +1,19 @@
+package check
+
+import (
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// NewCmdCheck returns the parent "check" command with subcommand azure-endpoints.
+func NewCmdCheck(streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check connectivity from nodes via VMSS run-command",
+		Long:  "Probe the endpoints AKS nodes depend on from the nodes themselves, via VMSS run-command.",
+	}
+
+	cmd.AddCommand(NewCmdCheckAzureEndpoints(streams))
+
+	return cmd
+}
@@ -0,0 +1,409 @@
+package check
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// AzureDNS is the address of Azure's recursive resolver, which is also the
+// WireServer.
+const AzureDNS = "168.63.129.16"
+
+// endpointProbe is one HTTP endpoint probed by azure-endpoints.
+type endpointProbe struct {
+	name   string
+	url    string
+	header string
+}
+
+// azureEndpointProbes are probed in this order. The identity token is
+// requested for ARM, as the kubelet identity does; its body is discarded on
+// the node so that a token never reaches run-command's output.
+var azureEndpointProbes = []endpointProbe{
+	{name: "imds", url: "http://169.254.169.254/metadata/instance?api-version=2021-02-01", header: "Metadata: true"},
+	{name: "imds-token", url: "http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fmanagement.azure.com%2F", header: "Metadata: true"},
+	{name: "wireserver", url: "http://" + AzureDNS + "/?comp=versions"},
+	{name: "wireserver-health", url: "http://" + AzureDNS + ":32526/health"},
+}
+
+// probeDNS is the name of the DNS probe, which follows the HTTP probes.
+const probeDNS = "dns"
+
+// slowProbe is the latency above which a successful probe is a warning.
+// IMDS and the WireServer are link-local and normally answer in a few
+// milliseconds.
+const slowProbe = time.Second
+
+// Verdict is the outcome of one probe.
+type Verdict string
+
+const (
+	Pass Verdict = "PASS"
+	Warn Verdict = "WARN"
+	Fail Verdict = "FAIL"
+)
+
+type azureEndpointsOptions struct {
+	nodes    []string
+	allNodes bool
+	selector string
+	parallel int
+	dnsName  string
+	timeout  time.Duration
+	output   string
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdCheckAzureEndpoints returns a cobra command for "kubectl vmss check azure-endpoints".
+func NewCmdCheckAzureEndpoints(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &azureEndpointsOptions{
+		parallel: 10,
+		dnsName:  "management.azure.com",
+		timeout:  5 * time.Second,
+		streams:  streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "azure-endpoints (<node>... | -l <selector> | --all-nodes)",
+		Short: "Probe IMDS, the WireServer and DNS from nodes",
+		Long: `Probe the Azure platform endpoints that extension provisioning, CNS
+network container programming and token acquisition depend on, from each
+node, and report the result and latency of each:
+
+  imds               IMDS instance metadata (169.254.169.254)
+  imds-token         IMDS managed identity token endpoint; the token is
+                     discarded on the node and never printed
+  wireserver         WireServer versions (` + AzureDNS + `)
+  wireserver-health  WireServer host plugin health (` + AzureDNS + `:32526)
+  dns                resolving --dns-name with the node's resolver
+
+A probe fails when the endpoint cannot be reached or answers with an error,
+and warns when it is slower than ` + slowProbe.String() + `. An identity token request
+answered with 400 still proves IMDS reachable and is a warning: the node has
+no identity that IMDS picks by default. Requests bypass any HTTP proxy.`,
+		Example: `  # One node
+  kubectl vmss check azure-endpoints aks-nodepool1-vmss000000
+
+  # A node pool, as JSON
+  kubectl vmss check azure-endpoints -l agentpool=nodepool1 -o json`,
+		Aliases: []string{"azure"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.nodes = args
+			fanOut := o.allNodes || o.selector != ""
+			if fanOut == (len(args) > 0) {
+				return fmt.Errorf("requires node names, or one of --all-nodes and --selector")
+			}
+			if o.parallel < 1 {
+				return fmt.Errorf("--parallel must be at least 1")
+			}
+			if o.timeout < time.Second {
+				return fmt.Errorf("--timeout must be at least 1s")
+			}
+			if o.dnsName == "" {
+				return fmt.Errorf("--dns-name must not be empty")
+			}
+			if o.output != "" && o.output != "json" {
+				return fmt.Errorf("unsupported output format %q (supported: json)", o.output)
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().BoolVar(&o.allNodes, "all-nodes", false, "Check every node")
+	cmd.Flags().StringVarP(&o.selector, "selector", "l", "", "Check the nodes matching this label selector")
+	cmd.Flags().IntVar(&o.parallel, "parallel", o.parallel, "Nodes to check at once")
+	cmd.Flags().StringVar(&o.dnsName, "dns-name", o.dnsName, "Name to resolve for the DNS probe")
+	cmd.Flags().DurationVar(&o.timeout, "timeout", o.timeout, "Timeout of each probe")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format: json")
+
+	return cmd
+}
+
+// Run executes the azure-endpoints command.
+func (o *azureEndpointsOptions) Run(ctx context.Context) error {
+	nodes := o.nodes
+	if len(nodes) == 0 {
+		var err error
+		if nodes, err = o.runner.ListNodes(ctx, o.selector); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(o.streams.ErrOut, "Probing Azure endpoints from %d nodes...\n", len(nodes))
+	script := buildEndpointsScript(o.dnsName, o.timeout)
+	results := vmss.RunOnNodes(ctx, o.runner, nodes, o.parallel, func(string) string { return script }, o.streams.ErrOut)
+	checks := make([]endpointNode, len(results))
+	for i, r := range results {
+		checks[i] = parseEndpointNode(r, o.dnsName)
+	}
+
+	if o.output == "json" {
+		data, err := json.MarshalIndent(checks, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.streams.Out, string(data))
+	} else if err := writeEndpointsTable(o.streams.Out, checks); err != nil {
+		return err
+	}
+
+	counts := map[Verdict]int{}
+	for _, n := range checks {
+		counts[n.Status]++
+	}
+	fmt.Fprintf(o.streams.ErrOut, "%d nodes passed, %d with warnings, %d failed, %d unknown\n",
+		counts[Pass], counts[Warn], counts[Fail], len(checks)-counts[Pass]-counts[Warn]-counts[Fail])
+	return nil
+}
+
+// buildEndpointsScript returns the script that prints, per probe,
+//
+//	probe <name> <exit code> <http code> <seconds> [detail]
+//
+// followed by "resolver <servers>". The detail of an HTTP probe is the
+// error IMDS returned, and only read when the request failed; the detail
+// of the DNS probe is the first address.
+func buildEndpointsScript(dnsName string, timeout time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `probe() {
+  NAME=$1; URL=$2; shift 2
+  BODY=$(mktemp)
+  OUT=$(curl -s -m %d --noproxy '*' -o "$BODY" -w '%%{http_code} %%{time_total}' "$@" "$URL" 2>/dev/null)
+  RC=$?
+  DETAIL=""
+  case "$OUT" in
+  200\ *) ;;
+  *)
+    DETAIL=$(sed -n 's/.*"error_description": *"\([^"]*\)".*/\1/p' "$BODY" | head -1)
+    [ -n "$DETAIL" ] || DETAIL=$(sed -n 's/.*"error": *"\([^"]*\)".*/\1/p' "$BODY" | head -1)
+    ;;
+  esac
+  rm -f "$BODY"
+  echo "probe $NAME $RC ${OUT:-000 0} $DETAIL" | cut -c1-300
+}
+`, int(timeout.Seconds()))
+	for _, p := range azureEndpointProbes {
+		fmt.Fprintf(&b, "probe %s %s", p.name, vmss.ShellQuote(p.url))
+		if p.header != "" {
+			fmt.Fprintf(&b, " -H %s", vmss.ShellQuote(p.header))
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, `S=$(date +%%s%%N)
+ADDR=$(timeout %d getent ahosts %s 2>/dev/null | awk 'NR == 1 { print $1 }')
+E=$(date +%%s%%N)
+T=$(awk -v s="$S" -v e="$E" 'BEGIN { printf "%%.3f", (e - s) / 1e9 }')
+if [ -n "$ADDR" ]; then echo "probe %s 0 - $T $ADDR"; else echo "probe %s 1 - $T"; fi
+RESOLV=/run/systemd/resolve/resolv.conf
+[ -f "$RESOLV" ] || RESOLV=/etc/resolv.conf
+echo "resolver $(awk '/^nameserver/ { printf "%%s%%s", sep, $2; sep = "," }' "$RESOLV")"`,
+		int(timeout.Seconds()), vmss.ShellQuote(dnsName), probeDNS, probeDNS)
+	return b.String()
+}
+
+// endpointNode is the result of probing from one node.
+type endpointNode struct {
+	Node     string          `json:"node"`
+	Status   Verdict         `json:"status"`
+	Probes   []endpointCheck `json:"probes,omitempty"`
+	Resolver string          `json:"resolver,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// endpointCheck is the result of one probe.
+type endpointCheck struct {
+	Name      string  `json:"name"`
+	Target    string  `json:"target"`
+	Status    Verdict `json:"status"`
+	HTTPCode  int     `json:"httpCode,omitempty"`
+	LatencyMs float64 `json:"latencyMs"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+// parseEndpointNode reads the output of buildEndpointsScript and judges
+// each probe.
+func parseEndpointNode(r vmss.NodeResult, dnsName string) endpointNode {
+	n := endpointNode{Node: r.Node, Status: "UNKNOWN"}
+	if r.Err != nil {
+		n.Error = r.Err.Error()
+		return n
+	}
+	targets := map[string]string{probeDNS: dnsName}
+	for _, p := range azureEndpointProbes {
+		targets[p.name] = p.url
+	}
+	for _, line := range strings.Split(r.Result.Stdout, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 6)
+		switch {
+		case fields[0] == "resolver" && len(fields) == 2:
+			n.Resolver = fields[1]
+		case fields[0] == "probe" && len(fields) >= 5:
+			rc, _ := strconv.Atoi(fields[2])
+			code, _ := strconv.Atoi(fields[3])
+			seconds, _ := strconv.ParseFloat(fields[4], 64)
+			c := endpointCheck{
+				Name:      fields[1],
+				Target:    targets[fields[1]],
+				HTTPCode:  code,
+				LatencyMs: seconds * 1000,
+			}
+			if len(fields) == 6 {
+				c.Detail = strings.TrimSpace(fields[5])
+			}
+			c.judge(rc)
+			n.Probes = append(n.Probes, c)
+		}
+	}
+	if len(n.Probes) == 0 {
+		n.Error = "no probe results from node"
+		if r.Result.Stderr != "" {
+			n.Error = strings.SplitN(r.Result.Stderr, "\n", 2)[0]
+		}
+		return n
+	}
+	n.Status = Pass
+	for _, c := range n.Probes {
+		if c.Status == Fail || (c.Status == Warn && n.Status == Pass) {
+			n.Status = c.Status
+		}
+	}
+	return n
+}
+
+// judge sets c's status from the probe's exit code and HTTP status, and
+// explains anything but a pass in c.Detail.
+func (c *endpointCheck) judge(rc int) {
+	slow := time.Duration(c.LatencyMs*float64(time.Millisecond)) > slowProbe
+	switch {
+	case c.Name == probeDNS && rc != 0:
+		c.Status, c.Detail = Fail, "could not resolve"
+	case c.Name == probeDNS:
+		c.Status = Pass
+	case rc != 0:
+		c.Status, c.Detail = Fail, curlError(rc)
+		c.HTTPCode = 0
+	case c.HTTPCode == 200:
+		c.Status = Pass
+	case c.HTTPCode == 429, c.HTTPCode == 400 && c.Name == "imds-token":
+		c.Status, c.Detail = Warn, httpDetail(c.HTTPCode, c.Detail)
+	default:
+		c.Status, c.Detail = Fail, httpDetail(c.HTTPCode, c.Detail)
+	}
+	if c.Status == Pass && slow {
+		c.Status, c.Detail = Warn, "slow"
+	}
+}
+
+// curlError describes the curl exit codes a probe is likely to see.
+func curlError(rc int) string {
+	switch rc {
+	case 7:
+		return "connection refused"
+	case 28:
+		return "timed out"
+	case 52:
+		return "empty reply"
+	case 56:
+		return "connection reset"
+	}
+	return fmt.Sprintf("curl exit code %d", rc)
+}
+
+func httpDetail(code int, detail string) string {
+	s := fmt.Sprintf("HTTP %d", code)
+	if detail != "" {
+		s += ": " + detail
+	}
+	return s
+}
+
+// cell is c as shown in the table: the latency when it passed, or why not.
+func (c *endpointCheck) cell() string {
+	switch {
+	case c.Status == Pass:
+		return formatLatency(c.LatencyMs)
+	case c.HTTPCode != 0 || c.Detail == "slow":
+		reason, _, _ := strings.Cut(c.Detail, ":")
+		return fmt.Sprintf("%s %s (%s)", c.Status, reason, formatLatency(c.LatencyMs))
+	case c.Name == probeDNS:
+		return string(c.Status) + " unresolved"
+	}
+	return fmt.Sprintf("%s %s", c.Status, c.Detail)
+}
+
+func formatLatency(ms float64) string {
+	if ms < 10 {
+		return fmt.Sprintf("%.1fms", ms)
+	}
+	return fmt.Sprintf("%.0fms", ms)
+}
+
+func writeEndpointsTable(out io.Writer, nodes []endpointNode) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	header := []string{"NODE"}
+	for _, p := range azureEndpointProbes {
+		header = append(header, strings.ToUpper(p.name))
+	}
+	header = append(header, strings.ToUpper(probeDNS), "STATUS")
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	var notes []string
+	for _, n := range nodes {
+		cells := []string{n.Node}
+		byName := map[string]*endpointCheck{}
+		for i := range n.Probes {
+			byName[n.Probes[i].Name] = &n.Probes[i]
+		}
+		for _, name := range header[1 : len(header)-1] {
+			if c, ok := byName[strings.ToLower(name)]; ok {
+				cells = append(cells, c.cell())
+			} else {
+				cells = append(cells, "-")
+			}
+		}
+		cells = append(cells, string(n.Status))
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+
+		if n.Error != "" {
+			notes = append(notes, fmt.Sprintf("%s: %s", n.Node, n.Error))
+		}
+		for _, c := range n.Probes {
+			if c.Status != Pass {
+				notes = append(notes, fmt.Sprintf("%s: %s %s: %s", n.Node, c.Name, c.Target, c.Detail))
+			}
+		}
+		if dns := byName[probeDNS]; dns != nil && dns.Status == Fail && n.Resolver != "" {
+			note := fmt.Sprintf("%s: resolves through %s", n.Node, n.Resolver)
+			if !strings.Contains(n.Resolver, AzureDNS) {
+				note += fmt.Sprintf(", not Azure DNS (%s); custom DNS servers must forward to it", AzureDNS)
+			}
+			notes = append(notes, note)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(notes) > 0 {
+		fmt.Fprintln(out)
+		for _, note := range notes {
+			fmt.Fprintln(out, note)
+		}
+	}
+	return nil
+}
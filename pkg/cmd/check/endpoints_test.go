@@ -0,0 +1,129 @@
+package check
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+)
+
+const testEndpointsOutput = `probe imds 0 200 0.002
+probe imds-token 0 400 0.004 Identity not found
+probe wireserver 0 200 0.003
+probe wireserver-health 28 000 5.001
+probe dns 0 - 0.012 20.37.158.0
+resolver 168.63.129.16`
+
+func endpointsResult(node, stdout string) vmss.NodeResult {
+	return vmss.NodeResult{Node: node, Result: &vmss.CommandResult{Stdout: stdout}}
+}
+
+func TestBuildEndpointsScript(t *testing.T) {
+	script := buildEndpointsScript("my-cluster.hcp.eastus.azmk8s.io", 3*time.Second)
+	for _, want := range []string{
+		"curl -s -m 3 --noproxy '*' -o \"$BODY\"",
+		`probe imds-token 'http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fmanagement.azure.com%2F' -H 'Metadata: true'`,
+		"probe wireserver 'http://168.63.129.16/?comp=versions'\n",
+		"timeout 3 getent ahosts 'my-cluster.hcp.eastus.azmk8s.io'",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q", want)
+		}
+	}
+	// The token body must only ever be read on failure.
+	if strings.Contains(script, `cat "$BODY"`) {
+		t.Error("script prints response bodies")
+	}
+}
+
+func TestParseEndpointNode(t *testing.T) {
+	n := parseEndpointNode(endpointsResult("n0", testEndpointsOutput), "management.azure.com")
+	if n.Status != Fail || n.Resolver != "168.63.129.16" || len(n.Probes) != 5 {
+		t.Fatalf("got %+v", n)
+	}
+	want := []struct {
+		status Verdict
+		detail string
+	}{
+		{Pass, ""},
+		{Warn, "HTTP 400: Identity not found"},
+		{Pass, ""},
+		{Fail, "timed out"},
+		{Pass, "20.37.158.0"},
+	}
+	for i, w := range want {
+		if c := n.Probes[i]; c.Status != w.status || c.Detail != w.detail {
+			t.Errorf("%s: %s %q, want %s %q", c.Name, c.Status, c.Detail, w.status, w.detail)
+		}
+	}
+	if c := n.Probes[0]; c.LatencyMs != 2 || c.HTTPCode != 200 || !strings.HasPrefix(c.Target, "http://169.254.169.254/metadata/instance") {
+		t.Errorf("imds = %+v", c)
+	}
+	if c := n.Probes[4]; c.Target != "management.azure.com" {
+		t.Errorf("dns target = %q", c.Target)
+	}
+
+	n = parseEndpointNode(vmss.NodeResult{Node: "n1", Err: errors.New("timeout")}, "x")
+	if n.Status != "UNKNOWN" || n.Error != "timeout" {
+		t.Errorf("failed node: %+v", n)
+	}
+}
+
+func TestJudgeProbe(t *testing.T) {
+	tests := []struct {
+		name   string
+		probe  string
+		rc     int
+		code   int
+		ms     float64
+		status Verdict
+		cell   string
+	}{
+		{name: "pass", probe: "imds", code: 200, ms: 1.25, status: Pass, cell: "1.2ms"},
+		{name: "slow", probe: "wireserver", code: 200, ms: 1500, status: Warn, cell: "WARN slow (1500ms)"},
+		{name: "refused", probe: "wireserver", rc: 7, ms: 1, status: Fail, cell: "FAIL connection refused"},
+		{name: "server error", probe: "imds", code: 503, ms: 20, status: Fail, cell: "FAIL HTTP 503 (20ms)"},
+		{name: "throttled", probe: "imds", code: 429, ms: 3, status: Warn, cell: "WARN HTTP 429 (3.0ms)"},
+		{name: "unresolved", probe: probeDNS, rc: 1, ms: 5000, status: Fail, cell: "FAIL unresolved"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := endpointCheck{Name: tt.probe, HTTPCode: tt.code, LatencyMs: tt.ms}
+			c.judge(tt.rc)
+			if c.Status != tt.status || c.cell() != tt.cell {
+				t.Errorf("got %s %q, want %s %q", c.Status, c.cell(), tt.status, tt.cell)
+			}
+		})
+	}
+}
+
+func TestWriteEndpointsTable(t *testing.T) {
+	nodes := []endpointNode{
+		parseEndpointNode(endpointsResult("n0", testEndpointsOutput), "management.azure.com"),
+		parseEndpointNode(endpointsResult("n1", strings.NewReplacer(
+			"28 000 5.001", "0 200 0.002",
+			"0 - 0.012 20.37.158.0", "1 - 5.000",
+			"resolver 168.63.129.16", "resolver 10.0.0.4").Replace(testEndpointsOutput)), "management.azure.com"),
+	}
+	var out bytes.Buffer
+	if err := writeEndpointsTable(&out, nodes); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"NODE  IMDS   IMDS-TOKEN             WIRESERVER  WIRESERVER-HEALTH  DNS              STATUS",
+		"n0    2.0ms  WARN HTTP 400 (4.0ms)  3.0ms       FAIL timed out     12ms             FAIL",
+		"n1    2.0ms  WARN HTTP 400 (4.0ms)  3.0ms       2.0ms              FAIL unresolved  FAIL",
+		"n0: imds-token http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fmanagement.azure.com%2F: HTTP 400: Identity not found",
+		"n0: wireserver-health http://168.63.129.16:32526/health: timed out",
+		"n1: dns management.azure.com: could not resolve",
+		"n1: resolves through 10.0.0.4, not Azure DNS (168.63.129.16); custom DNS servers must forward to it",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("table missing %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package cilium
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/matmerr/kubectl-vmss/pkg/transfer"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// bugtoolCommands are the dumps taken when the image has no cilium-bugtool,
+// a subset of what cilium-bugtool itself runs. "cilium-dbg" and "bpftool"
+// resolve to the binaries in the mounted image.
+var bugtoolCommands = []string{
+	"cilium-dbg version",
+	"cilium-dbg status --verbose",
+	"cilium-dbg config --all",
+	"cilium-dbg endpoint list",
+	"cilium-dbg identity list",
+	"cilium-dbg service list",
+	"cilium-dbg node list",
+	"cilium-dbg ip list",
+	"cilium-dbg policy get",
+	"cilium-dbg map list --verbose",
+	"cilium-dbg bpf lb list",
+	"cilium-dbg bpf ipcache list",
+	"cilium-dbg bpf endpoint list",
+	"cilium-dbg bpf tunnel list",
+	"cilium-dbg bpf policy get --all",
+	"cilium-dbg bpf metrics list",
+	"cilium-dbg metrics list",
+	"cilium-dbg fqdn cache list",
+	"cilium-dbg encrypt status",
+	"cilium-health status --verbose",
+	"bpftool net show",
+	"bpftool map show",
+	"bpftool prog show",
+	"ip -d addr",
+	"ip -d link",
+	"ip route show table all",
+	"ip rule",
+	"iptables-save -c",
+	"ss -tanpe",
+}
+
+type bugtoolOptions struct {
+	namespace string
+	pod       string
+	node      string
+	outputDir string
+	extract   bool
+	maxSize   int64
+	chunkSize int
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdCiliumBugtool returns a cobra command for "kubectl vmss cilium bugtool".
+func NewCmdCiliumBugtool(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &bugtoolOptions{
+		namespace: "kube-system",
+		outputDir: ".",
+		maxSize:   1 << 20,
+		chunkSize: transfer.DefaultChunkSize,
+		streams:   streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "bugtool (<pod> | --node <node>)",
+		Short: "Collect a cilium-bugtool archive from a cilium agent's node",
+		Long: `Run cilium-bugtool from the cilium agent's image in the agent's network
+namespace, the way "cilium sysdump" does through kubectl exec, and download
+the archive. Like "kubectl vmss cilium", it works while the agent is in
+CrashLoopBackOff, or with --node when there is no agent pod at all.
+
+The archive is saved as cilium-bugtool-<pod|node>-<time>.tar.gz, the name
+sysdump gives it, ready to attach to a cilium issue. Images without
+cilium-bugtool get an archive of the main cilium-dbg, bpftool and ip dumps
+in the same cmd/ layout instead.
+
+BPF object files are left out. Downloads move a few KB per run-command
+call, so a full archive can take a while; --max-size guards against
+accidentally huge ones.`,
+		Example: `  # Archive for a crashlooping agent, in the current directory
+  kubectl vmss cilium bugtool cilium-6jnvz
+
+  # No agent pod: find it on the node, and unpack the archive too
+  kubectl vmss cilium bugtool --node aks-nodepool1-vmss000000 -o ./bugtool --extract`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				o.pod = args[0]
+			}
+			if (o.pod == "") == (o.node == "") {
+				return fmt.Errorf("requires either a cilium pod name or --node <node>")
+			}
+			if o.chunkSize < 0 {
+				return fmt.Errorf("--chunk-size must be positive")
+			}
+			if o.chunkSize == 0 {
+				o.chunkSize = transfer.DefaultChunkSize
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", o.namespace, "Namespace for pod lookup")
+	cmd.Flags().StringVar(&o.node, "node", "", "Find the cilium agent on this node instead of naming its pod")
+	cmd.Flags().StringVarP(&o.outputDir, "output-dir", "o", o.outputDir, "Directory to save the archive in")
+	cmd.Flags().BoolVar(&o.extract, "extract", false, "Also unpack the archive into the output directory")
+	cmd.Flags().Int64Var(&o.maxSize, "max-size", o.maxSize, fmt.Sprintf("Refuse to download archives larger than this many bytes (the default takes about %d run-command calls)",
+		(o.maxSize+int64(o.chunkSize)-1)/int64(o.chunkSize)))
+	cmd.Flags().IntVar(&o.chunkSize, "chunk-size", o.chunkSize, "Raw bytes transferred per run-command call")
+
+	return cmd
+}
+
+// Run executes the bugtool command.
+func (o *bugtoolOptions) Run(ctx context.Context) error {
+	node := o.node
+	var fallbackImage string
+	if node == "" {
+		var err error
+		node, err = o.runner.ResolveNodeFromPod(ctx, o.namespace, o.pod)
+		if err != nil {
+			return err
+		}
+	} else {
+		fallbackImage = FallbackImage(ctx, o.runner, o.namespace, o.streams.ErrOut)
+	}
+	info, err := o.runner.ResolveVMSS(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.streams.ErrOut, "Running cilium-bugtool on %s/%s...\n", info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, buildBugtoolScript(o.namespace, o.pod, fallbackImage))
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(result.Stdout), "\n")
+	remote := strings.TrimSpace(lines[len(lines)-1])
+	if !strings.HasPrefix(remote, "/tmp/kubectl-vmss-bugtool.") {
+		return fmt.Errorf("bugtool archive was not created: %s %s", result.Stdout, result.Stderr)
+	}
+	defer transfer.Remove(context.WithoutCancel(ctx), o.runner, info, remote)
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+
+	f, err := transfer.Stat(ctx, o.runner, info, remote)
+	if err != nil {
+		return err
+	}
+	if f.Size > o.maxSize {
+		return fmt.Errorf("archive is %d bytes, larger than --max-size %d; raise --max-size to download it anyway", f.Size, o.maxSize)
+	}
+	name := o.pod
+	if name == "" {
+		name = node
+	}
+	local := filepath.Join(o.outputDir, fmt.Sprintf("cilium-bugtool-%s-%s.tar.gz", name, time.Now().UTC().Format("20060102-150405")))
+	calls := (f.Size + int64(o.chunkSize) - 1) / int64(o.chunkSize)
+	fmt.Fprintf(o.streams.ErrOut, "Downloading %d bytes in %d chunks to %s...\n", f.Size, calls, local)
+
+	d := &transfer.Downloader{Runner: o.runner, Info: info, ChunkSize: o.chunkSize, Progress: o.streams.ErrOut}
+	if err := d.DownloadFile(ctx, f, local, false); err != nil {
+		return err
+	}
+	fmt.Fprintf(o.streams.Out, "Archive saved to %s (sha256 %s)\n", local, f.SHA256)
+
+	if o.extract {
+		if err := transfer.ExtractTarGz(local, o.outputDir); err != nil {
+			return err
+		}
+		fmt.Fprintf(o.streams.Out, "Unpacked into %s\n", o.outputDir)
+	}
+	return nil
+}
+
+// buildBugtoolScript returns the script that mounts the agent's image as
+// buildCiliumScript does, runs its cilium-bugtool (or the bugtoolCommands
+// when it has none) in the agent's network namespace, and prints the path
+// of the resulting .tar.gz as its last line. The image's directories come
+// last in PATH so bugtool finds cilium-dbg and bpftool there while ip,
+// tc and iptables stay the node's own.
+func buildBugtoolScript(namespace, podName, fallbackImage string) string {
+	var dumps strings.Builder
+	for _, c := range bugtoolCommands {
+		fmt.Fprintf(&dumps, "  dump %s\n", c)
+	}
+	return fmt.Sprintf(`set -e
+%s
+
+# --- Step 4: collect the archive ---
+export PATH="$PATH:$MNT/usr/bin:$MNT/usr/local/bin:$MNT/bin"
+OUT=$(mktemp -d /tmp/kubectl-vmss-bugtool.XXXXXX)
+if [ -x "$MNT/usr/bin/cilium-bugtool" ]; then
+  if ! $ENTER "$MNT/usr/bin/cilium-bugtool" --tmp "$OUT" --archiveType gz --exclude-object-files >"$OUT.log" 2>&1; then
+    tail -20 "$OUT.log" >&2
+    rm -rf "$OUT" "$OUT.log"
+    exit 1
+  fi
+  ARCHIVE=$(ls "$OUT"/cilium-bugtool-*.tar.gz 2>/dev/null | head -1)
+  if [ -z "$ARCHIVE" ]; then
+    echo "Error: cilium-bugtool did not produce an archive" >&2
+    tail -20 "$OUT.log" >&2
+    rm -rf "$OUT" "$OUT.log"
+    exit 1
+  fi
+  mv "$ARCHIVE" "$OUT.tar.gz"
+else
+  echo "No cilium-bugtool in image $IMAGE; collecting cilium-dbg and bpftool dumps" >&2
+  [ -x "$MNT/usr/bin/cilium-dbg" ] || ln -s "$CILIUM_BIN" "$OUT/cilium-dbg"
+  export PATH="$PATH:$OUT"
+  DIR="cilium-bugtool-$(date -u +%%Y%%m%%d-%%H%%M%%S)"
+  mkdir -p "$OUT/$DIR/cmd"
+  dump() { $ENTER "$@" > "$OUT/$DIR/cmd/$(echo "$*" | tr ' /' '--').md" 2>&1 || true; }
+%s  tar -czf "$OUT.tar.gz" -C "$OUT" "$DIR"
+fi
+rm -rf "$OUT" "$OUT.log"
+echo "$OUT.tar.gz"
+`, SetupScript(namespace, podName, fallbackImage, false), dumps.String())
+}
@@ -3,17 +3,24 @@ package cilium
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
 	"github.com/matmerr/kubectl-vmss/pkg/vmss"
 	"github.com/spf13/cobra"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
 type ciliumOptions struct {
-	namespace string
-	pod       string
-	args      []string
+	namespace  string
+	pod        string
+	node       string
+	allNodes   bool
+	selector   string
+	parallel   int
+	refuseSkew bool
+	args       []string
 
 	runner  vmss.Runner
 	streams genericclioptions.IOStreams
@@ -30,22 +37,42 @@ type ciliumOptions struct {
 func NewCmdCilium(streams genericclioptions.IOStreams) *cobra.Command {
 	o := &ciliumOptions{
 		namespace: "kube-system",
+		parallel:  10,
 		streams:   streams,
 	}
 
 	cmd := &cobra.Command{
-		Use:   "cilium <pod> [flags] [-- cilium-args...]",
+		Use:   "cilium (<pod> | --node <node> | --all-nodes | -l <selector>) [flags] [-- cilium-args...]",
 		Short: "Run cilium CLI commands on a pod's node",
 		Long: `Run the cilium CLI directly on the AKS node where a cilium pod is scheduled.
 
 This works even when the pod is in CrashLoopBackOff.  The plugin:
   1. Finds the pod sandbox (always alive) and its PID.
-  2. Identifies the cilium container image from crictl inspect.
+  2. Identifies the image of that sandbox's cilium-agent container.
   3. Mounts the image via 'ctr -n k8s.io images mount' to get the binary.
   4. Runs the cilium CLI in the pod's network namespace via nsenter.
   5. Unmounts the image after the command finishes.
 
-All arguments after the pod name (or after --) are passed to the cilium binary.`,
+Flags of this command go before the pod name (or --node, --all-nodes, -l);
+everything after it, flags included, is passed to the cilium binary. A "--"
+in front of the cilium arguments is optional.
+
+The image digest, the CLI's version and the running agent's version (asked
+over its API socket) are printed first. A CLI that differs from the agent,
+as after an upgrade, is a warning, or an error with --refuse-version-skew.
+
+With --node instead of a pod name, the agent's sandbox is looked up on the
+node by its k8s-app=cilium label, for when the pod was deleted or is stuck
+Pending. If the node has no agent sandbox the CLI runs in the host network
+namespace, and if it has no cilium-agent container either, the image comes
+from the cilium DaemonSet spec. Every argument is then a cilium argument.
+
+--all-nodes, or -l with a node label selector, does the same on every
+matching node in parallel. A bare "status" is then summarized into one table
+with a row per agent: version, component states, failing controllers, endpoint
+count and the fullest BPF map. Values that differ from the majority of
+agents are marked with "*" and listed below the table. Other commands print
+their output per node.`,
 		Example: `  # Check cilium status on the node
   kubectl vmss cilium cilium-6jnvz status
 
@@ -56,65 +83,144 @@ All arguments after the pod name (or after --) are passed to the cilium binary.`
   kubectl vmss cilium cilium-6jnvz bpf policy get 1234
 
   # Run with verbose output
-  kubectl vmss cilium cilium-6jnvz status --verbose`,
+  kubectl vmss cilium cilium-6jnvz status --verbose
+
+  # No cilium pod to name: find the agent on the node
+  kubectl vmss cilium --node aks-nodepool1-vmss000000 status
+
+  # Status of every agent in one table
+  kubectl vmss cilium --all-nodes status
+
+  # Brief status of every agent, per node
+  kubectl vmss cilium --all-nodes status --brief
+
+  # A command on every node of one pool
+  kubectl vmss cilium -l agentpool=nodepool1 -- bpf lb list --revnat`,
 		DisableFlagParsing: false,
-		Args:               cobra.MinimumNArgs(1),
+		Args:               cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			o.pod = args[0]
-			o.args = args[1:]
+			fanOut := o.allNodes || o.selector != ""
+			if fanOut && o.node != "" {
+				return fmt.Errorf("--node cannot be combined with --all-nodes or --selector")
+			}
+			if o.parallel < 1 {
+				return fmt.Errorf("--parallel must be at least 1")
+			}
+			if fanOut || o.node != "" {
+				o.args = args
+			} else {
+				if len(args) == 0 {
+					return fmt.Errorf("requires a cilium pod name, or --node <node>")
+				}
+				o.pod = args[0]
+				o.args = args[1:]
+			}
+			if len(o.args) > 0 && o.args[0] == "--" {
+				o.args = o.args[1:]
+			}
 			if o.runner == nil {
 				o.runner = vmss.NewDefaultRunner()
 			}
+			if fanOut {
+				return o.RunAllNodes(cmd.Context())
+			}
 			return o.Run(cmd.Context())
 		},
 	}
 
+	// Everything after the pod belongs to cilium, including its flags.
+	cmd.Flags().SetInterspersed(false)
+
 	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", o.namespace, "Namespace for pod lookup")
+	cmd.Flags().StringVar(&o.node, "node", "", "Find the cilium agent on this node instead of naming its pod")
+	cmd.Flags().BoolVar(&o.allNodes, "all-nodes", false, "Run on every node")
+	cmd.Flags().StringVarP(&o.selector, "selector", "l", "", "Run on every node matching this label selector")
+	cmd.AddCommand(NewCmdCiliumBugtool(streams))
+	cmd.AddCommand(NewCmdCiliumMaps(streams))
+
+	cmd.Flags().BoolVar(&o.refuseSkew, "refuse-version-skew", false, "Fail instead of warning when the image's CLI version differs from the running agent's")
+	cmd.Flags().IntVar(&o.parallel, "parallel", o.parallel, "Nodes to run on at once with --all-nodes or --selector")
 
 	return cmd
 }
 
 // buildCiliumScript generates the shell script that:
 //  1. Finds the cilium pod sandbox PID (always alive, even in CrashLoopBackOff).
-//  2. Discovers the cilium container image via crictl inspect.
-//  3. Mounts the image with ctr to get the cilium binary.
+//  2. Discovers the image of the sandbox's cilium-agent container via
+//     crictl inspect.
+//  3. Mounts the image with ctr to get the cilium binary, and compares its
+//     version with the running agent's.
 //  4. Runs the binary via nsenter in the pod's network namespace.
 //  5. Cleans up the mount.
-func buildCiliumScript(podName, ciliumArgs string) string {
+//
+// With podName empty the agent's sandbox is found by its k8s-app=cilium
+// label instead. If there is none, the binary runs in the host network
+// namespace, which the agent shares anyway, and fallbackImage (the
+// DaemonSet's image) is used when no cilium-agent container exists.
+// A version mismatch is a warning unless refuseSkew is set.
+func buildCiliumScript(namespace, podName, fallbackImage, ciliumArgs string, refuseSkew bool) string {
 	return fmt.Sprintf(`set -e
 
-POD_NAME=%q
 CILIUM_ARGS=%q
-MNT="/tmp/cilium-rootfs-$$"
+%s
+
+# --- Step 4: nsenter into the pod network namespace and run ---
+$ENTER "$CILIUM_BIN" $CILIUM_ARGS
+`, ciliumArgs, SetupScript(namespace, podName, fallbackImage, refuseSkew))
+}
+
+// SetupScript returns steps 1-3 of buildCiliumScript, leaving $ENTER as
+// the nsenter prefix for the agent's network namespace, $MNT as the
+// mounted agent image and $CILIUM_BIN as the cilium binary in it. Other
+// commands use it to run the image's other tools, such as hubble.
+//
+// It reports the image used, the CLI's version and the running agent's
+// version from its API socket as $CLI_VERSION and $AGENT_VERSION (empty
+// when the agent does not answer). After an upgrade the mounted image can
+// differ from the one the agent runs, so a mismatch is reported as a
+// warning, or fails the script when refuseSkew is set.
+func SetupScript(namespace, podName, fallbackImage string, refuseSkew bool) string {
+	sandbox := nodescript.SandboxPID(namespace, podName) + `
+ENTER="nsenter -t $SANDBOX_PID -n --"`
+	if podName == "" {
+		sandbox = nodescript.LabeledSandboxPID(namespace, "k8s-app=cilium") + `
+ENTER=""
+if [ -n "$SANDBOX_PID" ]; then
+  ENTER="nsenter -t $SANDBOX_PID -n --"
+else
+  echo "No cilium-agent sandbox on this node; using the host network namespace" >&2
+fi`
+	}
+
+	return fmt.Sprintf(`FALLBACK_IMAGE=%s
 
 # --- Step 1: Find the cilium pod sandbox PID ---
-SANDBOX_ID=$(crictl pods --name "$POD_NAME" -q | head -1)
-if [ -z "$SANDBOX_ID" ]; then
-  echo "Error: no sandbox found for pod $POD_NAME" >&2
-  exit 1
-fi
-SANDBOX_PID=$(crictl inspectp "$SANDBOX_ID" 2>/dev/null \
-  | python3 -c "import sys,json; print(json.load(sys.stdin)['info']['pid'])" 2>/dev/null || true)
-if [ -z "$SANDBOX_PID" ] || [ "$SANDBOX_PID" = "0" ]; then
-  echo "Error: could not determine sandbox PID for pod $POD_NAME" >&2
-  exit 1
-fi
+%s
 
 # --- Step 2: Find the cilium container image ---
-CID=$(crictl ps --name cilium-agent -q | head -1)
-if [ -z "$CID" ]; then
-  CID=$(crictl ps -a --name cilium-agent -q | head -1)
+# Scoped to the sandbox: during an upgrade the node can also hold the old
+# agent pod's containers.
+POD_FILTER=""
+if [ -n "$SANDBOX_ID" ]; then
+  POD_FILTER="--pod $SANDBOX_ID"
 fi
+CID=$(crictl ps $POD_FILTER --name cilium-agent -q | head -1)
 if [ -z "$CID" ]; then
-  echo "Error: no cilium-agent container found" >&2
-  exit 1
+  CID=$(crictl ps -a $POD_FILTER --name cilium-agent -q | head -1)
 fi
-IMAGE=$(crictl inspect "$CID" 2>/dev/null \
-  | python3 -c "import sys,json; d=json.load(sys.stdin); print(d['info']['config']['image']['image'])" 2>/dev/null || true)
-if [ -z "$IMAGE" ]; then
-  # fallback: use the imageRef from the status
-  IMAGE=$(crictl inspect "$CID" 2>/dev/null \
-    | python3 -c "import sys,json; d=json.load(sys.stdin); print(d['status']['imageRef'])" 2>/dev/null || true)
+IMAGE=""
+if [ -n "$CID" ]; then
+  # the repo digest is an image name containerd can mount; fall back to
+  # the image as specified
+  IMAGE=$(crictl inspect "$CID" 2>/dev/null | %s)
+  if [ -z "$IMAGE" ]; then
+    IMAGE=$(crictl inspect "$CID" 2>/dev/null | %s)
+  fi
+fi
+if [ -z "$IMAGE" ] && [ -n "$FALLBACK_IMAGE" ]; then
+  echo "No cilium-agent container on this node; using DaemonSet image $FALLBACK_IMAGE" >&2
+  IMAGE="$FALLBACK_IMAGE"
+  %s
 fi
 if [ -z "$IMAGE" ]; then
   echo "Error: could not determine cilium container image" >&2
@@ -122,10 +228,7 @@ if [ -z "$IMAGE" ]; then
 fi
 
 # --- Step 3: Mount the container image ---
-cleanup() { ctr -n k8s.io images unmount "$MNT" >/dev/null 2>&1 || true; rmdir "$MNT" 2>/dev/null || true; }
-trap cleanup EXIT
-mkdir -p "$MNT"
-ctr -n k8s.io images mount "$IMAGE" "$MNT" >/dev/null 2>&1
+%s
 if [ ! -x "$MNT/usr/bin/cilium-dbg" ] && [ ! -x "$MNT/usr/bin/cilium" ]; then
   echo "Error: cilium binary not found in image $IMAGE" >&2
   exit 1
@@ -137,19 +240,50 @@ if [ ! -x "$CILIUM_BIN" ]; then
   CILIUM_BIN="$MNT/usr/bin/cilium"
 fi
 
-# --- Step 4: nsenter into the pod network namespace and run ---
-nsenter -t "$SANDBOX_PID" -n -- "$CILIUM_BIN" $CILIUM_ARGS
-`, podName, ciliumArgs)
+# Compare the image's CLI with the running agent, asked over its socket
+DIGEST=$(crictl inspecti "$IMAGE" 2>/dev/null | grep -m1 -o '[^" ]*@sha256:[0-9a-f]*' || true)
+VERSIONS=$(timeout 10 "$CILIUM_BIN" version 2>/dev/null || true)
+CLI_VERSION=$(echo "$VERSIONS" | awk '$1 == "Client:" { print $2 }')
+AGENT_VERSION=$(echo "$VERSIONS" | awk '$1 == "Daemon:" && $2 ~ /^v?[0-9]/ { print $2 }')
+echo "Image ${DIGEST:-$IMAGE}: cilium CLI ${CLI_VERSION:-unknown}, running agent ${AGENT_VERSION:-not answering}" >&2
+if [ -n "$AGENT_VERSION" ] && [ "$AGENT_VERSION" != "$CLI_VERSION" ]; then
+  if [ %t = true ]; then
+    echo "Error: cilium CLI $CLI_VERSION does not match the running agent $AGENT_VERSION" >&2
+    exit 1
+  fi
+  echo "Warning: cilium CLI $CLI_VERSION does not match the running agent $AGENT_VERSION; output may be incomplete" >&2
+fi`, vmss.ShellQuote(fallbackImage), sandbox,
+		nodescript.JSONString("imageRef"), nodescript.JSONString("image"),
+		strings.ReplaceAll(nodescript.PullImage(), "\n", "\n  "), nodescript.MountImage(), refuseSkew)
+}
+
+// FallbackImage returns the cilium-agent image of the cilium DaemonSet, for
+// SetupScript on nodes without a cilium-agent container. It is only needed
+// then, so a failure (API server down) is just a warning on errOut.
+func FallbackImage(ctx context.Context, runner vmss.Runner, namespace string, errOut io.Writer) string {
+	image, err := runner.GetDaemonSetImage(ctx, namespace, "cilium", "cilium-agent")
+	if err != nil {
+		fmt.Fprintf(errOut, "Warning: no DaemonSet image fallback: %v\n", err)
+		return ""
+	}
+	return nodescript.NormalizeImageRef(image)
 }
 
 // Run executes the cilium command on the node.
 func (o *ciliumOptions) Run(ctx context.Context) error {
-	fmt.Fprintf(o.streams.ErrOut, "Resolving node from pod %s/%s...\n", o.namespace, o.pod)
-	node, err := o.runner.ResolveNodeFromPod(ctx, o.namespace, o.pod)
-	if err != nil {
-		return err
+	node := o.node
+	var fallbackImage string
+	if node == "" {
+		fmt.Fprintf(o.streams.ErrOut, "Resolving node from pod %s/%s...\n", o.namespace, o.pod)
+		var err error
+		node, err = o.runner.ResolveNodeFromPod(ctx, o.namespace, o.pod)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(o.streams.ErrOut, "Pod %s/%s is on node: %s\n", o.namespace, o.pod, node)
+	} else {
+		fallbackImage = FallbackImage(ctx, o.runner, o.namespace, o.streams.ErrOut)
 	}
-	fmt.Fprintf(o.streams.ErrOut, "Pod %s/%s is on node: %s\n", o.namespace, o.pod, node)
 
 	info, err := o.runner.ResolveVMSS(ctx, node)
 	if err != nil {
@@ -157,7 +291,7 @@ func (o *ciliumOptions) Run(ctx context.Context) error {
 	}
 
 	ciliumArgs := strings.Join(o.args, " ")
-	script := buildCiliumScript(o.pod, ciliumArgs)
+	script := buildCiliumScript(o.namespace, o.pod, fallbackImage, ciliumArgs, o.refuseSkew)
 
 	fmt.Fprintf(o.streams.ErrOut, "Running cilium %s on %s/%s...\n", ciliumArgs, info.VMSSName, info.InstanceID)
 	result, err := o.runner.RunCommand(ctx, info, script)
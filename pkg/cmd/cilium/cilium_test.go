@@ -0,0 +1,82 @@
+package cilium
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestBuildCiliumScript(t *testing.T) {
+	script := buildCiliumScript("kube-system", "cilium-6jnvz", "", "status", false)
+	for _, want := range []string{
+		"--name '^cilium-6jnvz$'",
+		`ENTER="nsenter -t $SANDBOX_PID -n --"`,
+		"FALLBACK_IMAGE=''",
+		`POD_FILTER="--pod $SANDBOX_ID"`,
+		"crictl ps $POD_FILTER --name cilium-agent",
+		"if [ false = true ]; then",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("pod script missing %q", want)
+		}
+	}
+	if strings.Contains(script, "k8s-app=cilium") {
+		t.Error("pod script should not look the agent up by label")
+	}
+	if strings.Contains(script, "python") {
+		t.Error("script must not depend on python")
+	}
+
+	script = buildCiliumScript("kube-system", "", "mcr.microsoft.com/oss/cilium/cilium:v1.16.6", "status", true)
+	for _, want := range []string{
+		"--label 'k8s-app=cilium'",
+		"using the host network namespace",
+		"FALLBACK_IMAGE='mcr.microsoft.com/oss/cilium/cilium:v1.16.6'",
+		`crictl pull "$IMAGE"`,
+		`$ENTER "$CILIUM_BIN" $CILIUM_ARGS`,
+		"if [ true = true ]; then",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("node script missing %q", want)
+		}
+	}
+}
+
+func TestBuildBugtoolScript(t *testing.T) {
+	script := buildBugtoolScript("kube-system", "cilium-6jnvz", "")
+	for _, want := range []string{
+		"--name '^cilium-6jnvz$'",
+		`$ENTER "$MNT/usr/bin/cilium-bugtool" --tmp "$OUT" --archiveType gz --exclude-object-files`,
+		`export PATH="$PATH:$MNT/usr/bin:$MNT/usr/local/bin:$MNT/bin"`,
+		"dump cilium-dbg status --verbose\n",
+		"dump bpftool map show\n",
+		`echo "$OUT.tar.gz"`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q", want)
+		}
+	}
+	if strings.Contains(script, "%!") {
+		t.Error("script has a formatting error")
+	}
+}
+
+func TestBugtoolRejectsNegativeChunkSize(t *testing.T) {
+	cmd := NewCmdCiliumBugtool(genericclioptions.NewTestIOStreamsDiscard())
+	cmd.SetArgs([]string{"cilium-6jnvz", "--chunk-size", "-1"})
+	cmd.SilenceUsage, cmd.SilenceErrors = true, true
+	if err := cmd.Execute(); err == nil || err.Error() != "--chunk-size must be positive" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestCiliumPassesFlagsAfterTheCommand(t *testing.T) {
+	cmd := NewCmdCilium(genericclioptions.NewTestIOStreamsDiscard())
+	if err := cmd.ParseFlags([]string{"--all-nodes", "status", "--brief"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cmd.Flags().Args(), " "); got != "status --brief" {
+		t.Errorf("cilium args = %q", got)
+	}
+}
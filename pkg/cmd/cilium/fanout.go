@@ -0,0 +1,302 @@
+package cilium
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+)
+
+// runAll runs script on every node with vmss.RunOnNodes.
+func (o *ciliumOptions) runAll(ctx context.Context, nodes []string, script string) []vmss.NodeResult {
+	return vmss.RunOnNodes(ctx, o.runner, nodes, o.parallel, func(string) string { return script }, o.streams.ErrOut)
+}
+
+// RunAllNodes runs the cilium CLI on every selected node. A bare "status"
+// is summarized into one cluster table; any other command's output,
+// including "status" with flags, is printed per node.
+func (o *ciliumOptions) RunAllNodes(ctx context.Context) error {
+	nodes, err := o.runner.ListNodes(ctx, o.selector)
+	if err != nil {
+		return err
+	}
+	fallbackImage := FallbackImage(ctx, o.runner, o.namespace, o.streams.ErrOut)
+
+	if len(o.args) == 1 && o.args[0] == "status" {
+		fmt.Fprintf(o.streams.ErrOut, "Collecting cilium status from %d nodes...\n", len(nodes))
+		results := o.runAll(ctx, nodes, buildStatusSummaryScript(o.namespace, fallbackImage, o.refuseSkew))
+		agents := make([]agentStatus, len(results))
+		for i, r := range results {
+			agents[i] = parseAgentStatus(r)
+		}
+		return writeStatusTable(o.streams.Out, agents)
+	}
+
+	ciliumArgs := strings.Join(o.args, " ")
+	fmt.Fprintf(o.streams.ErrOut, "Running cilium %s on %d nodes...\n", ciliumArgs, len(nodes))
+	results := o.runAll(ctx, nodes, buildCiliumScript(o.namespace, "", fallbackImage, ciliumArgs, o.refuseSkew))
+	for _, r := range results {
+		fmt.Fprintf(o.streams.Out, "=== %s ===\n", r.Node)
+		if r.Err != nil {
+			fmt.Fprintf(o.streams.Out, "Error: %v\n", r.Err)
+			continue
+		}
+		if r.Result.Stdout != "" {
+			fmt.Fprintln(o.streams.Out, r.Result.Stdout)
+		}
+		if r.Result.Stderr != "" {
+			fmt.Fprintf(o.streams.ErrOut, "%s: %s\n", r.Node, r.Result.Stderr)
+		}
+	}
+	return nil
+}
+
+// buildStatusSummaryScript mounts the agent's image like buildCiliumScript
+// and reduces "status -o json", "endpoint list -o json" and "metrics list
+// -o json" to a few key=value lines: the full documents are far larger
+// than run-command's 4 KB output limit. With refuseSkew, a node whose image
+// CLI differs from the running agent fails before the summary.
+func buildStatusSummaryScript(namespace, fallbackImage string, refuseSkew bool) string {
+	flatten := nodescript.JSONFlatten()
+	return fmt.Sprintf(`set -e
+%s
+
+# --- Step 4: summarize the agent's status ---
+echo "version=${AGENT_VERSION:-$CLI_VERSION}"
+ST=$(mktemp /tmp/kubectl-vmss-cilium.XXXXXX)
+$ENTER "$CILIUM_BIN" status -o json 2>"$ST.err" | %s > "$ST"
+if [ -s "$ST" ]; then
+  awk -F= '
+    $1 == "cilium.state" { print "cilium=" $2 }
+    $1 == "kubernetes.state" { print "kubernetes=" $2 }
+    $1 == "kvstore.state" { print "kvstore=" $2 }
+    $1 == "cluster.ciliumHealth.state" { print "health=" $2 }
+    $1 ~ /^cluster-mesh\.clusters\.[0-9]+\.ready$/ { total++; if ($2 == "true") ready++ }
+    { split($1, k, ".") }
+    k[1] == "controllers" && $1 == "controllers." k[2] ".name" { name[k[2]] = $2 }
+    k[1] == "controllers" && $1 ~ /\.consecutive-failure-count$/ && $2 > 0 { failing[k[2]] = 1 }
+    END {
+      print "clustermesh=" ready + 0 "/" total + 0
+      for (i in failing) { n++; if (n <= 3) names = names (names == "" ? "" : ",") name[i] }
+      print "controller-failures=" n + 0
+      print "failing=" names
+    }' "$ST"
+  $ENTER "$CILIUM_BIN" endpoint list -o json 2>/dev/null | %s | awk -F= '
+    $1 ~ /^[0-9]+\.id$/ { n++ }
+    $1 ~ /^[0-9]+\.status\.state$/ && $2 != "ready" { bad++ }
+    END { print "endpoints=" n + 0 " " bad + 0 }'
+  $ENTER "$CILIUM_BIN" metrics list -o json 2>/dev/null | %s | awk -F= '
+    { split($1, k, "."); i = k[1] }
+    $1 == i ".name" { name[i] = $2 }
+    $1 == i ".labels.map_name" { m[i] = $2 }
+    $1 == i ".value" { v[i] = $2 + 0 }
+    END {
+      for (i in name) if (name[i] == "cilium_bpf_map_pressure" && (best == "" || v[i] > max)) { max = v[i]; best = m[i] }
+      if (best != "") printf "map-pressure=%%.1f %%s\n", max * 100, best
+    }'
+else
+  echo "error=$(grep -v '^$' "$ST.err" | head -1)"
+fi
+rm -f "$ST" "$ST.err"
+`, SetupScript(namespace, "", fallbackImage, refuseSkew), flatten, flatten, flatten)
+}
+
+// agentStatus is one node's row of the cluster status table.
+type agentStatus struct {
+	node               string
+	version            string
+	cilium             string
+	kubernetes         string
+	kvstore            string
+	health             string
+	clustermesh        string
+	controllerFailures int
+	failing            string
+	endpoints          string
+	mapPressure        string
+	skew               bool
+	err                string
+}
+
+// parseAgentStatus reads the key=value lines of buildStatusSummaryScript.
+func parseAgentStatus(r vmss.NodeResult) agentStatus {
+	a := agentStatus{node: r.Node}
+	if r.Err != nil {
+		a.err = r.Err.Error()
+		return a
+	}
+	for _, line := range strings.Split(r.Result.Stdout, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "version":
+			a.version = value
+		case "cilium":
+			a.cilium = value
+		case "kubernetes":
+			a.kubernetes = value
+		case "kvstore":
+			a.kvstore = value
+		case "health":
+			a.health = value
+		case "clustermesh":
+			if value != "0/0" {
+				a.clustermesh = value
+			}
+		case "controller-failures":
+			a.controllerFailures, _ = strconv.Atoi(value)
+		case "failing":
+			a.failing = value
+		case "endpoints":
+			var n, notReady int
+			fmt.Sscan(value, &n, &notReady)
+			a.endpoints = strconv.Itoa(n)
+			if notReady > 0 {
+				a.endpoints += fmt.Sprintf(" (%d not ready)", notReady)
+			}
+		case "map-pressure":
+			pct, m, _ := strings.Cut(value, " ")
+			a.mapPressure = pct + "% " + strings.TrimPrefix(m, "cilium_")
+		case "error":
+			a.err = value
+		}
+	}
+	if a.err == "" && a.cilium == "" {
+		a.err = "no status from agent"
+		if r.Result.Stderr != "" {
+			a.err = stderrError(r.Result.Stderr)
+		}
+		// SetupScript refusing the version skew
+		var cli, agent string
+		if _, err := fmt.Sscanf(a.err, "Error: cilium CLI %s does not match the running agent %s", &cli, &agent); err == nil {
+			a.version, a.skew = agent, true
+			a.err = fmt.Sprintf("cilium CLI %s of the image does not match the running agent %s (--refuse-version-skew)", cli, agent)
+		}
+	}
+	return a
+}
+
+// stderrError returns the last "Error: " line of a node script's stderr,
+// or its first line when there is none: the setup steps log progress
+// before failing.
+func stderrError(stderr string) string {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(lines[i], "Error: ") {
+			return strings.TrimSpace(lines[i])
+		}
+	}
+	return lines[0]
+}
+
+// compared lists the columns on which every agent should agree.
+var compared = []struct {
+	name  string
+	value func(*agentStatus) *string
+}{
+	{"VERSION", func(a *agentStatus) *string { return &a.version }},
+	{"CILIUM", func(a *agentStatus) *string { return &a.cilium }},
+	{"K8S", func(a *agentStatus) *string { return &a.kubernetes }},
+	{"KVSTORE", func(a *agentStatus) *string { return &a.kvstore }},
+	{"HEALTH", func(a *agentStatus) *string { return &a.health }},
+	{"CLUSTERMESH", func(a *agentStatus) *string { return &a.clustermesh }},
+}
+
+// markDisagreements suffixes with "*" every compared value that differs
+// from the most common one among the agents that answered, and returns a
+// note per column that has more than one value.
+func markDisagreements(agents []agentStatus) []string {
+	var notes []string
+	for _, c := range compared {
+		counts := map[string]int{}
+		for i := range agents {
+			if agents[i].err == "" {
+				counts[*c.value(&agents[i])]++
+			}
+		}
+		if len(counts) < 2 {
+			continue
+		}
+		values := make([]string, 0, len(counts))
+		for v := range counts {
+			values = append(values, v)
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if counts[values[i]] != counts[values[j]] {
+				return counts[values[i]] > counts[values[j]]
+			}
+			return values[i] < values[j]
+		})
+		parts := make([]string, len(values))
+		for i, v := range values {
+			parts[i] = fmt.Sprintf("%s on %d", display(v), counts[v])
+		}
+		notes = append(notes, fmt.Sprintf("%s differs: %s", c.name, strings.Join(parts, ", ")))
+		for i := range agents {
+			if v := c.value(&agents[i]); agents[i].err == "" && *v != values[0] {
+				*v = display(*v) + "*"
+			}
+		}
+	}
+	return notes
+}
+
+func display(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// writeStatusTable prints one row per agent, then the disagreements,
+// failing controllers and unreachable agents.
+func writeStatusTable(out io.Writer, agents []agentStatus) error {
+	notes := markDisagreements(agents)
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tVERSION\tCILIUM\tK8S\tKVSTORE\tHEALTH\tCLUSTERMESH\tFAILING CONTROLLERS\tENDPOINTS\tMAP PRESSURE")
+	for _, a := range agents {
+		if a.skew {
+			fmt.Fprintf(w, "%s\t%s\tversion skew\t-\t-\t-\t-\t-\t-\t-\n", a.node, a.version)
+			continue
+		}
+		if a.err != "" {
+			fmt.Fprintf(w, "%s\t-\tunreachable\t-\t-\t-\t-\t-\t-\t-\n", a.node)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", a.node,
+			display(a.version), display(a.cilium), display(a.kubernetes), display(a.kvstore),
+			display(a.health), display(a.clustermesh), a.controllerFailures, display(a.endpoints), display(a.mapPressure))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, a := range agents {
+		switch {
+		case a.err != "":
+			notes = append(notes, fmt.Sprintf("%s: %s", a.node, a.err))
+		case a.controllerFailures > 0:
+			more := ""
+			if shown := len(strings.Split(a.failing, ",")); a.controllerFailures > shown {
+				more = fmt.Sprintf(" and %d more", a.controllerFailures-shown)
+			}
+			notes = append(notes, fmt.Sprintf("%s: failing controllers %s%s", a.node, a.failing, more))
+		}
+	}
+	if len(notes) > 0 {
+		fmt.Fprintln(out)
+		for _, n := range notes {
+			fmt.Fprintln(out, n)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package cilium
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+)
+
+func summary(stdout string) *vmss.CommandResult {
+	return &vmss.CommandResult{Stdout: stdout}
+}
+
+func TestParseAgentStatus(t *testing.T) {
+	a := parseAgentStatus(vmss.NodeResult{Node: "n0", Result: summary(`version=1.16.6
+cilium=Ok
+health=Ok
+kubernetes=Ok
+kvstore=Disabled
+clustermesh=0/0
+controller-failures=5
+failing=ipcache-inject,sync-lb,bgp
+endpoints=14 2
+map-pressure=12.3 cilium_ct4_global`)})
+	want := agentStatus{
+		node: "n0", version: "1.16.6", cilium: "Ok", kubernetes: "Ok", kvstore: "Disabled", health: "Ok",
+		controllerFailures: 5, failing: "ipcache-inject,sync-lb,bgp",
+		endpoints: "14 (2 not ready)", mapPressure: "12.3% ct4_global",
+	}
+	if a != want {
+		t.Errorf("got %+v\nwant %+v", a, want)
+	}
+
+	a = parseAgentStatus(vmss.NodeResult{Node: "n1", Result: summary("version=1.16.6\nerror=Error: Unable to connect to cilium-agent")})
+	if a.err != "Error: Unable to connect to cilium-agent" {
+		t.Errorf("err = %q", a.err)
+	}
+	a = parseAgentStatus(vmss.NodeResult{Node: "n2", Result: &vmss.CommandResult{Stderr: "Error: could not mount image x"}})
+	if a.err != "Error: could not mount image x" {
+		t.Errorf("err = %q", a.err)
+	}
+	a = parseAgentStatus(vmss.NodeResult{Node: "n4", Result: &vmss.CommandResult{Stderr: "Image x: cilium CLI v1.15.9, running agent v1.16.6\nError: cilium CLI v1.15.9 does not match the running agent v1.16.6\n"}})
+	if !a.skew || a.version != "v1.16.6" || a.err != "cilium CLI v1.15.9 of the image does not match the running agent v1.16.6 (--refuse-version-skew)" {
+		t.Errorf("skew: %+v", a)
+	}
+	a = parseAgentStatus(vmss.NodeResult{Node: "n3", Err: errors.New("az vmss run-command failed")})
+	if a.err != "az vmss run-command failed" {
+		t.Errorf("err = %q", a.err)
+	}
+}
+
+func TestStatusSummaryRefusesSkew(t *testing.T) {
+	for _, refuse := range []bool{false, true} {
+		script := buildStatusSummaryScript("kube-system", "", refuse)
+		if got := strings.Contains(script, "if [ true = true ]"); got != refuse {
+			t.Errorf("refuseSkew %v: skew check refuses = %v", refuse, got)
+		}
+	}
+}
+
+func TestWriteStatusTable(t *testing.T) {
+	ok := "version=1.16.6\ncilium=Ok\nkubernetes=Ok\nkvstore=Disabled\nhealth=Ok\nclustermesh=0/0\ncontroller-failures=0\nendpoints=10 0\n"
+	agents := []agentStatus{
+		parseAgentStatus(vmss.NodeResult{Node: "n0", Result: summary(ok)}),
+		parseAgentStatus(vmss.NodeResult{Node: "n1", Result: summary(strings.Replace(ok, "1.16.6", "1.15.9", 1))}),
+		parseAgentStatus(vmss.NodeResult{Node: "n2", Result: summary(strings.Replace(ok, "controller-failures=0", "controller-failures=4\nfailing=a,b,c", 1))}),
+		parseAgentStatus(vmss.NodeResult{Node: "n3", Err: errors.New("timeout")}),
+		parseAgentStatus(vmss.NodeResult{Node: "n4", Result: &vmss.CommandResult{Stderr: "Error: cilium CLI v1.15.9 does not match the running agent v1.16.6"}}),
+	}
+	var out bytes.Buffer
+	if err := writeStatusTable(&out, agents); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"n1    1.15.9*",
+		"n3    -        unreachable",
+		"VERSION differs: 1.16.6 on 2, 1.15.9 on 1",
+		"n2: failing controllers a,b,c and 1 more",
+		"n3: timeout",
+		"n4    v1.16.6  version skew  -",
+		"n4: cilium CLI v1.15.9 of the image does not match the running agent v1.16.6 (--refuse-version-skew)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("table missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "CILIUM differs") || strings.Contains(got, "1.16.6*") {
+		t.Errorf("only the minority version should be marked:\n%s", got)
+	}
+}
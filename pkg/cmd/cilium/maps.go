@@ -0,0 +1,257 @@
+package cilium
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// maxMapLines keeps the map list inside run-command's 4 KB output: with a
+// policy map per endpoint, busy nodes pin hundreds of maps. The fullest
+// ones are sent, and maxMapBytes stops early when their names are long.
+const (
+	maxMapLines = 60
+	maxMapBytes = 3500
+)
+
+// mapHints tells how to make room in a family of maps, by name prefix:
+// mostly which agent option sizes them.
+var mapHints = []struct {
+	prefix string
+	hint   string
+}{
+	{"cilium_ct", "raise --bpf-ct-global-tcp-max / --bpf-ct-global-any-max, or --bpf-map-dynamic-size-ratio"},
+	{"cilium_snat", "raise --bpf-nat-global-max, or --bpf-map-dynamic-size-ratio"},
+	{"cilium_nodeport_neigh", "raise --bpf-neigh-global-max, or --bpf-map-dynamic-size-ratio"},
+	{"cilium_policy", "raise --bpf-policy-map-max, or simplify the policies selecting this endpoint"},
+	{"cilium_lb", "raise --bpf-lb-map-max"},
+	{"cilium_ipcache", "look for identity or pod IP churn in the cluster"},
+}
+
+type mapsOptions struct {
+	namespace string
+	pod       string
+	node      string
+	warn      int
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdCiliumMaps returns a cobra command for "kubectl vmss cilium maps".
+func NewCmdCiliumMaps(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &mapsOptions{
+		namespace: "kube-system",
+		warn:      80,
+		streams:   streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "maps (<pod> | --node <node>)",
+		Short: "Show how full the cilium agent's BPF maps are",
+		Long: `List the BPF maps cilium pins under /sys/fs/bpf/tc/globals on the agent's
+node with their entry count, max entries and fill percentage, using bpftool
+from the cilium image. Entries are counted on the node, so only one line per
+map comes back instead of the map contents.
+
+Maps at or above --warn percent are flagged together with the agent option
+that sizes them. CT and NAT maps are LRU maps that evict old entries when
+full, which under load drops live connections; policy and load-balancer
+maps reject new entries outright.
+
+Array maps, whose size is fixed, are skipped. On nodes with many endpoints
+only the ` + strconv.Itoa(maxMapLines) + ` fullest maps are shown.`,
+		Example: `  # Map fill on the node of a cilium agent
+  kubectl vmss cilium maps cilium-6jnvz
+
+  # Flag maps from 60% on, on a node without an agent pod
+  kubectl vmss cilium maps --node aks-nodepool1-vmss000000 --warn 60`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				o.pod = args[0]
+			}
+			if (o.pod == "") == (o.node == "") {
+				return fmt.Errorf("requires either a cilium pod name or --node <node>")
+			}
+			if o.warn < 1 || o.warn > 100 {
+				return fmt.Errorf("--warn must be between 1 and 100")
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", o.namespace, "Namespace for pod lookup")
+	cmd.Flags().StringVar(&o.node, "node", "", "Find the cilium agent on this node instead of naming its pod")
+	cmd.Flags().IntVar(&o.warn, "warn", o.warn, "Flag maps at least this full (percent)")
+
+	return cmd
+}
+
+// Run executes the maps command.
+func (o *mapsOptions) Run(ctx context.Context) error {
+	node := o.node
+	var fallbackImage string
+	if node == "" {
+		var err error
+		node, err = o.runner.ResolveNodeFromPod(ctx, o.namespace, o.pod)
+		if err != nil {
+			return err
+		}
+	} else {
+		fallbackImage = FallbackImage(ctx, o.runner, o.namespace, o.streams.ErrOut)
+	}
+	info, err := o.runner.ResolveVMSS(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.streams.ErrOut, "Counting BPF map entries on %s/%s...\n", info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, buildMapsScript(o.namespace, o.pod, fallbackImage))
+	if err != nil {
+		return err
+	}
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+	maps, total := parseMaps(result.Stdout)
+	if total == 0 {
+		return fmt.Errorf("no BPF maps found on %s", node)
+	}
+	return writeMapsTable(o.streams.Out, maps, total, o.warn)
+}
+
+// buildMapsScript returns the script that mounts the agent's image with
+// SetupScript and prints up to maxMapLines "<name> <type> <entries>
+// <max-entries>" lines, fullest first, then "total <n>". The total comes
+// last so that run-command's tail keeps it. Entries are "?" when a map
+// cannot be dumped.
+func buildMapsScript(namespace, podName, fallbackImage string) string {
+	return fmt.Sprintf(`set -e
+%s
+
+# --- Step 4: count the entries of every pinned map ---
+BPFTOOL="$MNT/usr/local/bin/bpftool"
+[ -x "$BPFTOOL" ] || BPFTOOL="$MNT/usr/bin/bpftool"
+[ -x "$BPFTOOL" ] || BPFTOOL=$(command -v bpftool || true)
+if [ -z "$BPFTOOL" ]; then
+  echo "Error: bpftool not found in image $IMAGE or on the node" >&2
+  exit 1
+fi
+LIST=$(mktemp /tmp/kubectl-vmss-maps.XXXXXX)
+for f in /sys/fs/bpf/tc/globals/*; do
+  [ -f "$f" ] || continue
+  SHOW=$("$BPFTOOL" map show pinned "$f" 2>/dev/null) || continue
+  TYPE=$(echo "$SHOW" | awk 'NR == 1 { print $2 }')
+  case "$TYPE" in
+    *hash|lpm_trie) ;;
+    *) continue ;;
+  esac
+  MAX=$(echo "$SHOW" | sed -n 's/.*max_entries \([0-9]*\).*/\1/p')
+  N=$("$BPFTOOL" map dump pinned "$f" 2>/dev/null | sed -n 's/^Found \([0-9]*\) elements\{0,1\}$/\1/p')
+  echo "${f##*/} $TYPE ${N:-?} ${MAX:-0}" >> "$LIST"
+done
+awk '{ printf "%%.6f %%s\n", ($4 > 0 ? $3 / $4 : 0), $0 }' "$LIST" | sort -rn | head -n %d | cut -d' ' -f2- |
+  awk -v max=%d '{ n += length($0) + 1; if (n > max) exit; print }'
+echo "total $(wc -l < "$LIST")"
+rm -f "$LIST"`, SetupScript(namespace, podName, fallbackImage, false), maxMapLines, maxMapBytes)
+}
+
+// bpfMap is one line of buildMapsScript's output. entries is -1 when the
+// map could not be dumped.
+type bpfMap struct {
+	name       string
+	mapType    string
+	entries    int
+	maxEntries int
+}
+
+func (m bpfMap) fill() float64 {
+	if m.entries < 0 || m.maxEntries == 0 {
+		return 0
+	}
+	return float64(m.entries) * 100 / float64(m.maxEntries)
+}
+
+// parseMaps reads buildMapsScript's output, returning the maps sent and
+// the total number of maps on the node.
+func parseMaps(out string) ([]bpfMap, int) {
+	var maps []bpfMap
+	total := 0
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Fields(line)
+		if len(f) == 2 && f[0] == "total" {
+			total, _ = strconv.Atoi(f[1])
+			continue
+		}
+		if len(f) != 4 {
+			continue
+		}
+		m := bpfMap{name: f[0], mapType: f[1], entries: -1}
+		if n, err := strconv.Atoi(f[2]); err == nil {
+			m.entries = n
+		}
+		m.maxEntries, _ = strconv.Atoi(f[3])
+		maps = append(maps, m)
+	}
+	return maps, total
+}
+
+// mapHint returns the advice for a map that is filling up.
+func mapHint(name string) string {
+	for _, h := range mapHints {
+		if strings.HasPrefix(name, h.prefix) {
+			return h.hint
+		}
+	}
+	return ""
+}
+
+// writeMapsTable prints the maps fullest first, flagging those at or above
+// warn percent, followed by the sizing option for each flagged map family.
+func writeMapsTable(out io.Writer, maps []bpfMap, total, warn int) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MAP\tTYPE\tENTRIES\tMAX\tFILL\tSTATUS")
+	var hints []string
+	seen := map[string]bool{}
+	for _, m := range maps {
+		entries, fill, status := "?", "?", "unreadable"
+		if m.entries >= 0 {
+			entries = strconv.Itoa(m.entries)
+			fill = fmt.Sprintf("%.1f%%", m.fill())
+			status = "ok"
+			if m.fill() >= float64(warn) {
+				status = "WARN"
+				if h := mapHint(m.name); h != "" && !seen[h] {
+					seen[h] = true
+					hints = append(hints, fmt.Sprintf("%s is %.0f%% full: %s", m.name, m.fill(), h))
+				}
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", m.name, m.mapType, entries, m.maxEntries, fill, status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if total > len(maps) {
+		fmt.Fprintf(out, "\n%d emptier maps not shown\n", total-len(maps))
+	}
+	if len(hints) > 0 {
+		fmt.Fprintln(out)
+		for _, h := range hints {
+			fmt.Fprintln(out, h)
+		}
+	}
+	return nil
+}
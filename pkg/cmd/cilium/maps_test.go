@@ -0,0 +1,71 @@
+package cilium
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseMaps(t *testing.T) {
+	maps, total := parseMaps(`cilium_ct4_global lru_hash 950 1000
+cilium_policy_00012 hash 1 16384
+cilium_broken hash ? 10
+total 42`)
+	if total != 42 || len(maps) != 3 {
+		t.Fatalf("got %d maps of %d, want 3 of 42", len(maps), total)
+	}
+	if m := maps[0]; m.name != "cilium_ct4_global" || m.mapType != "lru_hash" || m.entries != 950 || m.maxEntries != 1000 || m.fill() != 95 {
+		t.Errorf("maps[0] = %+v", m)
+	}
+	if m := maps[2]; m.entries != -1 || m.fill() != 0 {
+		t.Errorf("undumpable map = %+v", m)
+	}
+}
+
+func TestWriteMapsTable(t *testing.T) {
+	maps, total := parseMaps(`cilium_ct4_global lru_hash 950 1000
+cilium_ct_any4_global lru_hash 900 1000
+cilium_snat_v4_external lru_hash 400 1000
+cilium_broken hash ? 10
+total 5`)
+	var out bytes.Buffer
+	if err := writeMapsTable(&out, maps, total, 80); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"cilium_ct4_global        lru_hash  950      1000  95.0%  WARN",
+		"cilium_snat_v4_external  lru_hash  400      1000  40.0%  ok",
+		"cilium_broken            hash      ?        10    ?      unreadable",
+		"1 emptier maps not shown",
+		"cilium_ct4_global is 95% full: raise --bpf-ct-global-tcp-max",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("table missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "--bpf-ct-global-tcp-max") != 1 {
+		t.Errorf("the CT hint should be given once:\n%s", got)
+	}
+}
+
+func TestBuildMapsScript(t *testing.T) {
+	script := buildMapsScript("kube-system", "cilium-6jnvz", "")
+	for _, want := range []string{
+		"--name '^cilium-6jnvz$'",
+		`BPFTOOL="$MNT/usr/local/bin/bpftool"`,
+		"for f in /sys/fs/bpf/tc/globals/*; do",
+		"sort -rn | head -n 60",
+		"awk -v max=3500 ",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q", want)
+		}
+	}
+	if strings.Contains(script, "%!") {
+		t.Error("script has a formatting error")
+	}
+	if !strings.HasSuffix(script, "echo \"total $(wc -l < \"$LIST\")\"\nrm -f \"$LIST\"") {
+		t.Errorf("the total should be printed last:\n%s", script)
+	}
+}
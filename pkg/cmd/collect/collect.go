@@ -0,0 +1,204 @@
+package collect
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/acn"
+	"github.com/matmerr/kubectl-vmss/pkg/transfer"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+type collectOptions struct {
+	node       string
+	output     string
+	extractDir string
+	since      time.Duration
+	maxSize    int64
+	chunkSize  int
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdCollect returns a cobra command for "kubectl vmss collect".
+func NewCmdCollect(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &collectOptions{
+		extractDir: "kubectl-vmss-bundle",
+		since:      24 * time.Hour,
+		maxSize:    1 << 20,
+		chunkSize:  transfer.DefaultChunkSize,
+		streams:    streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "collect <node>",
+		Short: "Download a support bundle of node diagnostics",
+		Long: `Gather node diagnostics into a tarball on the node, download it in chunks
+over VMSS run-command, and unpack it locally under <extract-dir>/<node>/.
+
+The bundle contains:
+  journal/   kubelet, containerd and azure-cns journals
+  cni/       the files behind "acn logs" and "acn state"
+  crictl/    crictl pods/ps and inspect output for every pod and container
+  network/   iptables/ip6tables/nft rules, ip addr/route/rule/neigh
+  system/    sysctl, dmesg, os-release, disk, memory and process lists
+  kubelet/   kubelet and containerd configuration
+
+Credentials (/etc/kubernetes/azure.json, kubeconfigs, private keys) are not
+collected. Run-command returns only a few KB per call, so large bundles take
+many round trips; --max-size guards against accidental huge downloads.
+Every run builds a new bundle, so an interrupted download starts over.`,
+		Example: `  # Collect a bundle and unpack it under ./kubectl-vmss-bundle/<node>/
+  kubectl vmss collect aks-nodepool1-vmss000000 -o bundle.tar.gz
+
+  # Only the last hour of journals, keep the tarball only
+  kubectl vmss collect aks-nodepool1-vmss000000 --since 1h --extract-dir ""`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.node = args[0]
+			if o.output == "" {
+				o.output = o.node + ".tar.gz"
+			}
+			if o.since <= 0 {
+				return fmt.Errorf("--since must be greater than 0")
+			}
+			if o.chunkSize < 0 {
+				return fmt.Errorf("--chunk-size must be positive")
+			}
+			if o.chunkSize == 0 {
+				o.chunkSize = transfer.DefaultChunkSize
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Local path for the tarball (default <node>.tar.gz)")
+	cmd.Flags().StringVar(&o.extractDir, "extract-dir", o.extractDir, "Directory to unpack the bundle into (empty = don't unpack)")
+	cmd.Flags().DurationVar(&o.since, "since", o.since, "How much journal history to include")
+	cmd.Flags().Int64Var(&o.maxSize, "max-size", o.maxSize, fmt.Sprintf("Refuse to download bundles larger than this many bytes (the default takes about %d run-command calls)",
+		(o.maxSize+int64(o.chunkSize)-1)/int64(o.chunkSize)))
+	cmd.Flags().IntVar(&o.chunkSize, "chunk-size", o.chunkSize, "Raw bytes transferred per run-command call")
+
+	return cmd
+}
+
+// Run executes the collect command.
+func (o *collectOptions) Run(ctx context.Context) error {
+	info, err := o.runner.ResolveVMSS(ctx, o.node)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.streams.ErrOut, "Building bundle on %s/%s...\n", info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, buildCollectScript(o.node, o.since))
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(result.Stdout), "\n")
+	remote := strings.TrimSpace(lines[len(lines)-1])
+	if !strings.HasPrefix(remote, "/tmp/kubectl-vmss-collect.") {
+		return fmt.Errorf("bundle was not created: %s %s", result.Stdout, result.Stderr)
+	}
+	defer transfer.Remove(context.WithoutCancel(ctx), o.runner, info, remote)
+
+	f, err := transfer.Stat(ctx, o.runner, info, remote)
+	if err != nil {
+		return err
+	}
+	if f.Size > o.maxSize {
+		return fmt.Errorf("bundle is %d bytes, larger than --max-size %d; narrow --since or raise --max-size", f.Size, o.maxSize)
+	}
+	calls := (f.Size + int64(o.chunkSize) - 1) / int64(o.chunkSize)
+	fmt.Fprintf(o.streams.ErrOut, "Downloading %d bytes in %d chunks to %s...\n", f.Size, calls, o.output)
+
+	// No resume: the bundle is new on every run, so an old .part of the
+	// same name never holds a prefix of it.
+	d := &transfer.Downloader{Runner: o.runner, Info: info, ChunkSize: o.chunkSize, Progress: o.streams.ErrOut}
+	if err := d.DownloadFile(ctx, f, o.output, false); err != nil {
+		return err
+	}
+	fmt.Fprintf(o.streams.Out, "Bundle saved to %s (sha256 %s)\n", o.output, f.SHA256)
+
+	if o.extractDir != "" {
+		if err := transfer.ExtractTarGz(o.output, o.extractDir); err != nil {
+			return err
+		}
+		fmt.Fprintf(o.streams.Out, "Unpacked to %s/%s/\n", o.extractDir, o.node)
+	}
+	return nil
+}
+
+// buildCollectScript returns the script that gathers the bundle under
+// /tmp/kubectl-vmss-collect.XXXXXX/<node>/, tars it and prints the
+// tarball path as its last line. Individual collectors never fail the
+// script; their errors end up in the collected file instead.
+func buildCollectScript(node string, since time.Duration) string {
+	return fmt.Sprintf(`NODE=%s
+WORK=$(mktemp -d /tmp/kubectl-vmss-collect.XXXXXX)
+B="$WORK/$NODE"
+mkdir -p "$B/journal" "$B/cni" "$B/crictl/inspect" "$B/network" "$B/system" "$B/kubelet"
+run() { out="$1"; shift; "$@" > "$B/$out" 2>&1 || true; }
+
+# journal
+for u in kubelet containerd azure-cns; do
+  run "journal/$u.log" journalctl -u "$u" --no-pager -o short-iso-precise --since "-%ds"
+done
+
+# cni: files behind "acn logs" and "acn state"
+for f in %s %s; do
+  [ -f "$f" ] && cp --parents "$f" "$B/cni/"
+done
+for d in %s; do
+  [ -d "$d" ] && cp -r --parents "$d" "$B/cni/"
+done
+find %s \( -name '*.conflist' -o -name '*.json' \) 2>/dev/null | while read f; do cp --parents "$f" "$B/cni/"; done
+
+# crictl
+run crictl/pods.txt crictl pods
+run crictl/ps.txt crictl ps -a
+for id in $(crictl pods -q 2>/dev/null); do run "crictl/inspect/pod-$id.json" crictl inspectp "$id"; done
+for id in $(crictl ps -a -q 2>/dev/null); do run "crictl/inspect/container-$id.json" crictl inspect "$id"; done
+
+# network
+run network/iptables.txt iptables-save
+run network/ip6tables.txt ip6tables-save
+run network/nft.txt nft list ruleset
+run network/ip-addr.txt ip addr
+run network/ip-route.txt ip route show table all
+run network/ip6-route.txt ip -6 route show table all
+run network/ip-rule.txt ip rule
+run network/ip-neigh.txt ip neigh
+run network/ss.txt ss -tanpe
+
+# system
+run system/sysctl.txt sysctl -a
+run system/dmesg.txt dmesg -T
+run system/uname.txt uname -a
+run system/os-release.txt cat /etc/os-release
+run system/df.txt df -h
+run system/df-inodes.txt df -hi
+run system/free.txt free -m
+run system/ps.txt ps auxww
+run system/systemctl-failed.txt systemctl list-units --failed --no-pager
+
+# kubelet / containerd configuration (no credentials)
+run kubelet/config.yaml cat /var/lib/kubelet/config.yaml
+run kubelet/default-kubelet cat /etc/default/kubelet
+run kubelet/kubelet.service.txt systemctl cat kubelet
+run kubelet/containerd-config.toml cat /etc/containerd/config.toml
+
+tar -czf "$WORK.tar.gz" -C "$WORK" "$NODE" && rm -rf "$WORK"
+echo "$WORK.tar.gz"`,
+		vmss.ShellQuote(node), int64(since/time.Second),
+		strings.Join(acn.LogFiles, " "), strings.Join(acn.StateFiles, " "),
+		strings.Join(acn.StateDirs, " "), acn.CNIDownloadsDir,
+	)
+}
@@ -0,0 +1,97 @@
+package collect
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+var chunkRe = regexp.MustCompile(`skip=(\d+) count=(\d+)`)
+
+// bundleRunner serves a freshly built bundle, as the collect script does.
+type bundleRunner struct {
+	vmss.Runner
+	bundle []byte
+	calls  int
+}
+
+func (r *bundleRunner) ResolveVMSS(context.Context, string) (*vmss.NodeInfo, error) {
+	return &vmss.NodeInfo{VMSSName: "vmss", InstanceID: "0"}, nil
+}
+
+func (r *bundleRunner) RunCommand(_ context.Context, _ *vmss.NodeInfo, script string) (*vmss.CommandResult, error) {
+	r.calls++
+	switch {
+	case strings.Contains(script, "mktemp -d"):
+		return &vmss.CommandResult{Stdout: "/tmp/kubectl-vmss-collect.b2b2b2.tar.gz\n"}, nil
+	case strings.Contains(script, "sha256sum"):
+		sum := sha256.Sum256(r.bundle)
+		return &vmss.CommandResult{Stdout: fmt.Sprintf("%d\n%s\n", len(r.bundle), hex.EncodeToString(sum[:]))}, nil
+	case chunkRe.MatchString(script):
+		m := chunkRe.FindStringSubmatch(script)
+		off, _ := strconv.Atoi(m[1])
+		n, _ := strconv.Atoi(m[2])
+		return &vmss.CommandResult{Stdout: base64.StdEncoding.EncodeToString(r.bundle[off : off+n])}, nil
+	}
+	return &vmss.CommandResult{}, nil
+}
+
+func TestCollectIgnoresStalePart(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "n0.tar.gz")
+	// An interrupted run left part of another bundle behind.
+	if err := os.WriteFile(out+".part", bytes.Repeat([]byte("a"), 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := &bundleRunner{bundle: bytes.Repeat([]byte("b"), 2500)}
+	var stdout, stderr bytes.Buffer
+	o := &collectOptions{
+		node:      "n0",
+		output:    out,
+		since:     time.Hour,
+		maxSize:   1 << 20,
+		chunkSize: 1000,
+		runner:    runner,
+		streams:   genericclioptions.IOStreams{Out: &stdout, ErrOut: &stderr},
+	}
+	if err := o.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v\n%s", err, stderr.String())
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, runner.bundle) {
+		t.Errorf("downloaded %d bytes, not the new bundle", len(got))
+	}
+	if _, err := os.Stat(out + ".part"); !os.IsNotExist(err) {
+		t.Errorf(".part still exists: %v", err)
+	}
+	// build, stat, 3 chunks, remove
+	if runner.calls != 6 {
+		t.Errorf("run-command calls: got %d, want 6", runner.calls)
+	}
+}
+
+func TestCollectRejectsNegativeChunkSize(t *testing.T) {
+	cmd := NewCmdCollect(genericclioptions.NewTestIOStreamsDiscard())
+	cmd.SetArgs([]string{"n0", "--chunk-size", "-1"})
+	cmd.SilenceUsage, cmd.SilenceErrors = true, true
+	if err := cmd.Execute(); err == nil || err.Error() != "--chunk-size must be positive" {
+		t.Errorf("err = %v", err)
+	}
+}
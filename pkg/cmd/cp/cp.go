@@ -0,0 +1,269 @@
+package cp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
+	"github.com/matmerr/kubectl-vmss/pkg/transfer"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+type cpOptions struct {
+	namespace string
+	container string
+	maxSize   int64
+	chunkSize int
+	resume    bool
+	mode      string
+	owner     string
+	exec      bool
+
+	// upload is true when the local file is the source.
+	upload   bool
+	remote   remoteSpec
+	local    string
+	execArgs []string
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// remoteSpec is the "<node>:<path>" or "<pod>:<path>" side of a copy.
+// kind is "node", "pod" or "" when it still has to be detected.
+type remoteSpec struct {
+	kind string
+	name string
+	path string
+}
+
+// parseRemoteSpec splits "[node/|pod/]<name>:<path>". ok is false for local
+// paths.
+func parseRemoteSpec(arg string) (remoteSpec, bool) {
+	name, p, found := strings.Cut(arg, ":")
+	if !found || name == "" || !strings.HasPrefix(p, "/") {
+		return remoteSpec{}, false
+	}
+	spec := remoteSpec{name: name, path: p}
+	if k, n, ok := strings.Cut(name, "/"); ok && (k == "node" || k == "pod") {
+		spec.kind, spec.name = k, n
+	}
+	return spec, true
+}
+
+// NewCmdCp returns a cobra command for "kubectl vmss cp".
+func NewCmdCp(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &cpOptions{
+		namespace: "kube-system",
+		maxSize:   10 << 20,
+		resume:    true,
+		streams:   streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "cp <node|pod>:<path> <local-path> | <local-path> <node|pod>:<path> [-- exec-args...]",
+		Short: "Copy files to and from a node or a pod's container",
+		Long: `Copy a file between the local machine and an AKS node, or a container's
+filesystem as seen from its node, via VMSS run-command. The direction
+follows the argument that contains ":".
+
+Files are gzipped and sent as base64 chunks, a few KB per run-command call
+for downloads and tens of KB for uploads, and verified with SHA-256. An
+interrupted copy resumes where it stopped when run again.
+
+The remote side is "<name>:<absolute path>". The name is looked up as a pod
+in --namespace first and used as a node name otherwise; prefix it with
+"node/" or "pod/" to skip detection. Pod paths go through the running
+container's /proc/<pid>/root, so mounted volumes are visible too.
+
+Uploads are written atomically with --mode and optional --owner. With
+--exec the uploaded file is run (inside the container's namespaces for a
+pod) with any arguments after "--", then deleted.`,
+		Example: `  # Download a log file from a node
+  kubectl vmss cp aks-nodepool1-vmss000000:/var/log/azure-vnet.log ./
+
+  # Download a file from a pod's container (even if the API server can't exec)
+  kubectl vmss cp coredns-abc:/etc/coredns/Corefile ./Corefile -c coredns
+
+  # Force node interpretation of the name
+  kubectl vmss cp node/aks-nodepool1-vmss000000:/var/crash/core.1234 ./core --max-size 100000000
+
+  # Upload a patched config
+  kubectl vmss cp ./10-azure.conflist aks-nodepool1-vmss000000:/etc/cni/net.d/10-azure.conflist
+
+  # Upload a static debug binary, run it once and remove it
+  kubectl vmss cp ./tcpdump-static node/aks-nodepool1-vmss000000:/tmp/tcpdump --exec -- -D`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src, srcRemote := parseRemoteSpec(args[0])
+			dst, dstRemote := parseRemoteSpec(args[1])
+			switch {
+			case srcRemote && !dstRemote:
+				o.remote, o.local = src, args[1]
+			case dstRemote && !srcRemote:
+				o.upload = true
+				o.remote, o.local = dst, args[0]
+			case srcRemote && dstRemote:
+				return fmt.Errorf("copying between two remotes is not supported")
+			default:
+				return fmt.Errorf("one side must be <node|pod>:<absolute path>")
+			}
+			o.execArgs = args[2:]
+			if len(o.execArgs) > 0 && !o.exec {
+				return fmt.Errorf("extra arguments are only accepted with --exec")
+			}
+			if !o.upload && (o.exec || o.mode != "" || o.owner != "") {
+				return fmt.Errorf("--mode, --owner and --exec only apply to uploads")
+			}
+			if err := o.validateUploadFlags(); err != nil {
+				return err
+			}
+			if o.chunkSize < 0 {
+				return fmt.Errorf("--chunk-size must be positive")
+			}
+			if o.chunkSize == 0 {
+				o.chunkSize = transfer.DefaultChunkSize
+				if o.upload {
+					o.chunkSize = transfer.DefaultUploadChunkSize
+				}
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			if o.upload {
+				return o.RunUpload(cmd.Context())
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", o.namespace, "Namespace for pod lookup")
+	cmd.Flags().StringVarP(&o.container, "container", "c", "", "Container to copy from or to (default: first container)")
+	cmd.Flags().Int64Var(&o.maxSize, "max-size", o.maxSize, "Refuse to transfer more than this many (compressed) bytes")
+	cmd.Flags().IntVar(&o.chunkSize, "chunk-size", 0, "Raw bytes transferred per run-command call (default 2700 down, 49152 up)")
+	cmd.Flags().BoolVar(&o.resume, "resume", o.resume, "Resume a previous partial transfer")
+	cmd.Flags().StringVar(&o.mode, "mode", "", "Octal file mode for uploads (default 0644, 0755 with --exec)")
+	cmd.Flags().StringVar(&o.owner, "owner", "", "Owner for uploads, as user[:group]")
+	cmd.Flags().BoolVar(&o.exec, "exec", false, "Run the uploaded file, then delete it")
+
+	return cmd
+}
+
+// Run executes the cp command.
+func (o *cpOptions) Run(ctx context.Context) error {
+	node, err := o.resolveNode(ctx)
+	if err != nil {
+		return err
+	}
+	info, err := o.runner.ResolveVMSS(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	script, err := o.stageScript(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(o.streams.ErrOut, "Staging %s on %s/%s...\n", o.remote.path, info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, script)
+	if err != nil {
+		return err
+	}
+	orig, staged, err := transfer.ParseStaged(result.Stdout)
+	if err != nil {
+		if result.Stderr != "" {
+			return fmt.Errorf("%s", result.Stderr)
+		}
+		return err
+	}
+	if staged.Size > o.maxSize {
+		return fmt.Errorf("%s is %d bytes compressed, larger than --max-size %d", o.remote.path, staged.Size, o.maxSize)
+	}
+
+	dst := o.local
+	if st, err := os.Stat(dst); (err == nil && st.IsDir()) || strings.HasSuffix(dst, string(os.PathSeparator)) {
+		dst = filepath.Join(dst, path.Base(o.remote.path))
+	}
+
+	// The staged copy stays on the node until the download succeeds, so a
+	// rerun finds it and resumes.
+	d := &transfer.Downloader{Runner: o.runner, Info: info, ChunkSize: o.chunkSize, Progress: o.streams.ErrOut}
+	if err := d.FetchStagedFile(ctx, orig, staged, dst, o.resume); err != nil {
+		return err
+	}
+	transfer.Remove(ctx, o.runner, info, staged.Path)
+
+	fmt.Fprintf(o.streams.Out, "%s -> %s (%d bytes, sha256 %s)\n", o.remote.path, dst, orig.Size, orig.SHA256)
+	return nil
+}
+
+// resolveNode returns the node the file lives on, detecting whether the
+// name refers to a pod or a node when no prefix was given. Only a pod that
+// does not exist makes the name a node; other lookup errors are returned.
+func (o *cpOptions) resolveNode(ctx context.Context) (string, error) {
+	switch o.remote.kind {
+	case "node":
+		return o.remote.name, nil
+	case "pod":
+		return o.runner.ResolveNodeFromPod(ctx, o.namespace, o.remote.name)
+	}
+	node, err := o.runner.ResolveNodeFromPod(ctx, o.namespace, o.remote.name)
+	if errors.Is(err, vmss.ErrPodNotFound) {
+		o.remote.kind = "node"
+		return o.remote.name, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	o.remote.kind = "pod"
+	return node, nil
+}
+
+// rootScript returns a snippet that sets $ROOT to the prefix under which
+// remote paths live: empty for a node, the container's /proc/$PID/root for
+// a pod.
+func (o *cpOptions) rootScript(ctx context.Context) (string, error) {
+	if o.remote.kind != "pod" {
+		return `ROOT=""`, nil
+	}
+	container := o.container
+	if container == "" {
+		var err error
+		container, err = o.runner.GetContainerName(ctx, o.namespace, o.remote.name)
+		if err != nil {
+			return "", err
+		}
+	}
+	return nodescript.ContainerPID(o.namespace, o.remote.name, container) + "\nROOT=\"/proc/$PID/root\"", nil
+}
+
+// stageScript returns the script that gzips the source file into
+// /tmp/kubectl-vmss-cp.<sha256>.gz (reusing it if a previous attempt
+// already staged the same content) and prints "<size> <sha256>" of the
+// original and "<path> <size> <sha256>" of the staged copy.
+func (o *cpOptions) stageScript(ctx context.Context) (string, error) {
+	root, err := o.rootScript(ctx)
+	if err != nil {
+		return "", err
+	}
+	locate := root + "\nF=\"$ROOT\"" + vmss.ShellQuote(o.remote.path)
+
+	return locate + `
+if [ ! -f "$F" ]; then
+  echo "Error: not a regular file: $F" >&2
+  exit 1
+fi
+SUM=$(sha256sum "$F" | cut -d' ' -f1)
+STAGE="/tmp/kubectl-vmss-cp.$SUM.gz"
+if [ ! -s "$STAGE" ]; then
+  gzip -nc "$F" > "$STAGE.tmp" && mv "$STAGE.tmp" "$STAGE"
+fi
+` + nodescript.PrintStaged("$F", "$SUM", "$STAGE"), nil
+}
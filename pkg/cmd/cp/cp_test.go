@@ -0,0 +1,97 @@
+package cp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+)
+
+func TestParseRemoteSpec(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want remoteSpec
+		ok   bool
+	}{
+		{arg: "aks-nodepool1-vmss000000:/var/log/azure-vnet.log", want: remoteSpec{name: "aks-nodepool1-vmss000000", path: "/var/log/azure-vnet.log"}, ok: true},
+		{arg: "node/aks-nodepool1-vmss000000:/var/crash/core", want: remoteSpec{kind: "node", name: "aks-nodepool1-vmss000000", path: "/var/crash/core"}, ok: true},
+		{arg: "pod/coredns-abc:/etc/coredns/Corefile", want: remoteSpec{kind: "pod", name: "coredns-abc", path: "/etc/coredns/Corefile"}, ok: true},
+		{arg: "./azure-vnet.log"},
+		{arg: "node:relative/path"},
+		{arg: ":/no/name"},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRemoteSpec(tt.arg)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseRemoteSpec(%q): got %+v, %v; want %+v, %v", tt.arg, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestValidateUploadFlags(t *testing.T) {
+	tests := []struct {
+		o        cpOptions
+		wantMode string
+		wantErr  bool
+	}{
+		{o: cpOptions{upload: true}, wantMode: "0644"},
+		{o: cpOptions{upload: true, exec: true}, wantMode: "0755"},
+		{o: cpOptions{upload: true, mode: "4750", owner: "root:root"}, wantMode: "4750"},
+		{o: cpOptions{upload: true, mode: "755"}, wantMode: "755"},
+		{o: cpOptions{upload: true, mode: "0999"}, wantErr: true},
+		{o: cpOptions{upload: true, mode: "17777"}, wantErr: true},
+		{o: cpOptions{upload: true, owner: "root; rm -rf /"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		o := tt.o
+		err := o.validateUploadFlags()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateUploadFlags(%+v): err = %v, wantErr %v", tt.o, err, tt.wantErr)
+			continue
+		}
+		if err == nil && o.mode != tt.wantMode {
+			t.Errorf("validateUploadFlags(%+v): mode = %q, want %q", tt.o, o.mode, tt.wantMode)
+		}
+	}
+}
+
+// podRunner resolves pods from a map, or fails with err.
+type podRunner struct {
+	vmss.Runner
+	pods map[string]string
+	err  error
+}
+
+func (r *podRunner) ResolveNodeFromPod(_ context.Context, namespace, pod string) (string, error) {
+	if r.err != nil {
+		return "", r.err
+	}
+	if node, ok := r.pods[pod]; ok {
+		return node, nil
+	}
+	return "", fmt.Errorf("pod %s/%s: %w", namespace, pod, vmss.ErrPodNotFound)
+}
+
+func TestResolveNode(t *testing.T) {
+	runner := &podRunner{pods: map[string]string{"coredns-abc": "aks-nodepool1-vmss000001"}}
+	o := &cpOptions{namespace: "kube-system", runner: runner, remote: remoteSpec{name: "coredns-abc"}}
+	if node, err := o.resolveNode(context.Background()); err != nil || node != "aks-nodepool1-vmss000001" || o.remote.kind != "pod" {
+		t.Errorf("pod: got %q, %v, kind %q", node, err, o.remote.kind)
+	}
+
+	o.remote = remoteSpec{name: "aks-nodepool1-vmss000000"}
+	if node, err := o.resolveNode(context.Background()); err != nil || node != "aks-nodepool1-vmss000000" || o.remote.kind != "node" {
+		t.Errorf("node: got %q, %v, kind %q", node, err, o.remote.kind)
+	}
+
+	// Any other error, such as an unreachable API server, is not a node name.
+	runner.err = errors.New("Unable to connect to the server")
+	o.remote = remoteSpec{name: "coredns-abc"}
+	if _, err := o.resolveNode(context.Background()); err == nil || o.remote.kind != "" {
+		t.Errorf("lookup error: got %v, kind %q", err, o.remote.kind)
+	}
+}
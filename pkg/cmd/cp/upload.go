@@ -0,0 +1,143 @@
+package cp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/matmerr/kubectl-vmss/pkg/transfer"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+)
+
+var ownerRe = regexp.MustCompile(`^[A-Za-z0-9._-]+(:[A-Za-z0-9._-]+)?$`)
+
+// validateUploadFlags checks --mode and --owner and fills in the default
+// mode.
+func (o *cpOptions) validateUploadFlags() error {
+	if !o.upload {
+		return nil
+	}
+	if o.mode == "" {
+		o.mode = "0644"
+		if o.exec {
+			o.mode = "0755"
+		}
+	}
+	if m, err := strconv.ParseUint(o.mode, 8, 32); err != nil || m > 0o7777 {
+		return fmt.Errorf("--mode must be an octal file mode such as 0755, got %q", o.mode)
+	}
+	if o.owner != "" && !ownerRe.MatchString(o.owner) {
+		return fmt.Errorf("--owner must be user or user:group, got %q", o.owner)
+	}
+	return nil
+}
+
+// RunUpload executes the cp command for a local source.
+func (o *cpOptions) RunUpload(ctx context.Context) error {
+	data, err := os.ReadFile(o.local)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	orig := &transfer.FileInfo{Path: o.local, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if int64(buf.Len()) > o.maxSize {
+		return fmt.Errorf("%s is %d bytes compressed, larger than --max-size %d", o.local, buf.Len(), o.maxSize)
+	}
+
+	node, err := o.resolveNode(ctx)
+	if err != nil {
+		return err
+	}
+	info, err := o.runner.ResolveVMSS(ctx, node)
+	if err != nil {
+		return err
+	}
+	root, err := o.rootScript(ctx)
+	if err != nil {
+		return err
+	}
+
+	stage := "/tmp/kubectl-vmss-cp." + orig.SHA256 + ".upload.gz"
+	calls := (buf.Len() + o.chunkSize - 1) / o.chunkSize
+	fmt.Fprintf(o.streams.ErrOut, "Uploading %d bytes (%d uncompressed) to %s/%s in %d chunks...\n",
+		buf.Len(), orig.Size, info.VMSSName, info.InstanceID, calls)
+	u := &transfer.Uploader{Runner: o.runner, Info: info, ChunkSize: o.chunkSize, Progress: o.streams.ErrOut}
+	if _, err := u.Upload(ctx, buf.Bytes(), stage, o.resume); err != nil {
+		return fmt.Errorf("%w (partial data kept in %s on the node)", err, stage)
+	}
+
+	result, err := o.runner.RunCommand(ctx, info, o.installScript(root, stage, orig))
+	if err != nil {
+		return err
+	}
+	if result.Stdout != "" {
+		fmt.Fprintln(o.streams.Out, result.Stdout)
+	}
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+	return nil
+}
+
+// installScript returns the script that decompresses the staged upload next
+// to its destination, checks it against the local SHA-256, applies mode and
+// owner and renames it into place. With --exec it then runs the file and
+// deletes it. A destination that is an existing directory receives the
+// file under its local name.
+func (o *cpOptions) installScript(root, stage string, orig *transfer.FileInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\nSTAGE=%s\nP=%s\n", root, vmss.ShellQuote(stage), vmss.ShellQuote(o.remote.path))
+	fmt.Fprintf(&b, `if [ -d "$ROOT$P" ]; then P="${P%%/}/"%s; fi
+F="$ROOT$P"
+TMP="$F.kubectl-vmss.tmp"
+if ! gunzip -c "$STAGE" > "$TMP"; then
+  rm -f "$TMP"
+  exit 1
+fi
+SUM=$(sha256sum "$TMP" | cut -d' ' -f1)
+if [ "$SUM" != %s ]; then
+  rm -f "$TMP"
+  echo "Error: checksum mismatch after decompressing ($SUM)" >&2
+  exit 1
+fi
+chmod %s "$TMP" || exit 1
+`, vmss.ShellQuote(filepath.Base(o.local)), vmss.ShellQuote(orig.SHA256), o.mode)
+	if o.owner != "" {
+		fmt.Fprintf(&b, "chown %s \"$TMP\" || exit 1\n", vmss.ShellQuote(o.owner))
+	}
+	fmt.Fprintf(&b, `mv -f "$TMP" "$F" || exit 1
+rm -f "$STAGE"
+echo %s" -> $P (%d bytes, sha256 %s)"
+`, vmss.ShellQuote(o.local), orig.Size, orig.SHA256)
+	if !o.exec {
+		return b.String()
+	}
+
+	run := `"$F"`
+	if o.remote.kind == "pod" {
+		run = `nsenter -t "$PID" -m -u -i -n -p -- "$P"`
+	}
+	for _, a := range o.execArgs {
+		run += " " + vmss.ShellQuote(a)
+	}
+	fmt.Fprintf(&b, `%s
+RC=$?
+rm -f "$F"
+[ "$RC" -eq 0 ] || echo "exit status $RC" >&2`, run)
+	return b.String()
+}
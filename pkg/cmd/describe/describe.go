@@ -0,0 +1,19 @@
+package describe
+
+import (
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// NewCmdDescribe returns the parent "describe" command with subcommand node.
+func NewCmdDescribe(streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "describe",
+		Short: "Show a detailed report for a node via VMSS run-command",
+		Long:  "Collect and evaluate node-level state (services, disk, memory, clock, certificates, kernel errors) by running one script on an AKS node via VMSS run-command.",
+	}
+
+	cmd.AddCommand(NewCmdDescribeNode(streams))
+
+	return cmd
+}
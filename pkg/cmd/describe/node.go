@@ -0,0 +1,339 @@
+package describe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+type describeNodeOptions struct {
+	node   string
+	output string
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdDescribeNode returns a cobra command for "kubectl vmss describe node".
+func NewCmdDescribeNode(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &describeNodeOptions{
+		streams: streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "node <node>",
+		Short: "One-shot health report for a node",
+		Long: `Run one composite script on an AKS node and report a PASS/WARN/FAIL verdict for:
+
+  - kubelet, containerd and azure-cns service state and restart counts
+  - disk and inode usage of /var/lib/containerd and /var/lib/kubelet
+  - memory and PID pressure, load average
+  - clock synchronization
+  - kubelet and node certificate expiry
+  - OOM kills and kernel errors from dmesg
+
+Everything is collected in a single run-command round trip, which makes this
+the first thing to run on a NotReady node.`,
+		Example: `  # Health report for a node
+  kubectl vmss describe node aks-nodepool1-vmss000000
+
+  # Machine-readable report
+  kubectl vmss describe node aks-nodepool1-vmss000000 -o json`,
+		Aliases: []string{"nodes", "no"},
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.node = args[0]
+			if o.output != "" && o.output != "json" {
+				return fmt.Errorf("unsupported output format %q (supported: json)", o.output)
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format: json")
+
+	return cmd
+}
+
+// Run executes the describe node command.
+func (o *describeNodeOptions) Run(ctx context.Context) error {
+	info, err := o.runner.ResolveVMSS(ctx, o.node)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.streams.ErrOut, "Running on %s/%s...\n", info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, nodeHealthScript)
+	if err != nil {
+		return err
+	}
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+
+	checks := evaluate(parseFacts(result.Stdout))
+
+	if o.output == "json" {
+		data, err := json.MarshalIndent(checks, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.streams.Out, string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(o.streams.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tDETAIL")
+	counts := map[Verdict]int{}
+	for _, c := range checks {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, c.Verdict, c.Detail)
+		counts[c.Verdict]++
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(o.streams.ErrOut, "%d passed, %d warnings, %d failed\n", counts[Pass], counts[Warn], counts[Fail])
+	return nil
+}
+
+// nodeHealthScript prints one "<check> <subject> <field>=<value>" line per
+// fact. It only uses tools present on every AKS image and never fails as a
+// whole: a missing tool just leaves its facts out.
+const nodeHealthScript = `for u in kubelet containerd azure-cns; do
+  if systemctl cat "$u" >/dev/null 2>&1; then
+    systemctl show "$u" -p ActiveState -p SubState -p NRestarts | sed "s/^/unit $u /"
+  else
+    echo "unit $u ActiveState=not-found"
+  fi
+done
+for d in /var/lib/containerd /var/lib/kubelet; do
+  [ -d "$d" ] || continue
+  df -P "$d" | awk -v d="$d" 'NR == 2 { sub(/%/, "", $5); print "disk " d " used=" $5 }'
+  df -Pi "$d" | awk -v d="$d" 'NR == 2 { sub(/%/, "", $5); print "inode " d " used=" $5 }'
+done
+awk '/^MemTotal:/ { print "memory node total_kb=" $2 } /^MemAvailable:/ { print "memory node available_kb=" $2 }' /proc/meminfo
+echo "pid node max=$(cat /proc/sys/kernel/pid_max)"
+echo "pid node count=$(ls -d /proc/[0-9]* 2>/dev/null | wc -l)"
+read L1 L5 L15 REST < /proc/loadavg
+echo "load node 1m=$L1"
+echo "load node 5m=$L5"
+echo "load node cpus=$(nproc)"
+echo "clock node synchronized=$(timedatectl show -p NTPSynchronized --value 2>/dev/null || echo unknown)"
+echo "clock node now=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+for c in /var/lib/kubelet/pki/kubelet-client-current.pem /var/lib/kubelet/pki/kubelet.crt /etc/kubernetes/certs/client.crt /etc/kubernetes/certs/apiserver.crt; do
+  [ -f "$c" ] || continue
+  END=$(openssl x509 -enddate -noout -in "$c" 2>/dev/null | cut -d= -f2)
+  [ -n "$END" ] && echo "cert $c not_after=$(date -u -d "$END" +%Y-%m-%dT%H:%M:%SZ)"
+done
+echo "dmesg node oom_kills=$(dmesg 2>/dev/null | grep -c -i -E 'out of memory|oom-kill|killed process')"
+echo "dmesg node errors=$(dmesg -l emerg,alert,crit,err 2>/dev/null | wc -l)"
+dmesg -T -l emerg,alert,crit,err 2>/dev/null | tail -n 3 | sed 's/^/dmesg node last_error=/'`
+
+// facts holds the script output as check -> subject -> field -> values.
+// Subjects are kept in the order they were first seen.
+type facts struct {
+	values   map[string]map[string]map[string][]string
+	subjects map[string][]string
+}
+
+func parseFacts(out string) *facts {
+	f := &facts{
+		values:   map[string]map[string]map[string][]string{},
+		subjects: map[string][]string{},
+	}
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		check, subject := parts[0], parts[1]
+		field, value, ok := strings.Cut(parts[2], "=")
+		if !ok {
+			continue
+		}
+		if f.values[check] == nil {
+			f.values[check] = map[string]map[string][]string{}
+		}
+		if f.values[check][subject] == nil {
+			f.values[check][subject] = map[string][]string{}
+			f.subjects[check] = append(f.subjects[check], subject)
+		}
+		f.values[check][subject][field] = append(f.values[check][subject][field], value)
+	}
+	return f
+}
+
+func (f *facts) get(check, subject, field string) string {
+	if v := f.values[check][subject][field]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (f *facts) num(check, subject, field string) (float64, bool) {
+	v, err := strconv.ParseFloat(f.get(check, subject, field), 64)
+	return v, err == nil
+}
+
+// Verdict is the outcome of a single health check.
+type Verdict string
+
+const (
+	Pass Verdict = "PASS"
+	Warn Verdict = "WARN"
+	Fail Verdict = "FAIL"
+)
+
+// Check is one line of the health report.
+type Check struct {
+	Name    string  `json:"name"`
+	Verdict Verdict `json:"verdict"`
+	Detail  string  `json:"detail"`
+}
+
+// threshold returns Fail at or above fail, Warn at or above warn.
+func threshold(v, warn, fail float64) Verdict {
+	switch {
+	case v >= fail:
+		return Fail
+	case v >= warn:
+		return Warn
+	}
+	return Pass
+}
+
+// evaluate turns collected facts into checks. Facts that were not collected
+// (missing tool, file or directory) are reported as warnings rather than
+// silently skipped, except optional units and certificates.
+func evaluate(f *facts) []Check {
+	var checks []Check
+
+	for _, u := range f.subjects["unit"] {
+		name := "unit/" + u
+		state := f.get("unit", u, "ActiveState")
+		restarts, _ := f.num("unit", u, "NRestarts")
+		switch {
+		case state == "not-found" && u == "azure-cns":
+			// CNS runs as a pod on most clusters; only check it when installed.
+			continue
+		case state == "not-found":
+			checks = append(checks, Check{name, Fail, "unit not installed"})
+		case state != "active":
+			checks = append(checks, Check{name, Fail, fmt.Sprintf("%s (%s), %.0f restarts", state, f.get("unit", u, "SubState"), restarts)})
+		case restarts > 0:
+			checks = append(checks, Check{name, Warn, fmt.Sprintf("active (%s), %.0f restarts", f.get("unit", u, "SubState"), restarts)})
+		default:
+			checks = append(checks, Check{name, Pass, fmt.Sprintf("active (%s)", f.get("unit", u, "SubState"))})
+		}
+	}
+
+	for _, kind := range []string{"disk", "inode"} {
+		for _, d := range f.subjects[kind] {
+			used, ok := f.num(kind, d, "used")
+			if !ok {
+				checks = append(checks, Check{kind + "/" + d, Warn, "usage unknown"})
+				continue
+			}
+			checks = append(checks, Check{kind + "/" + d, threshold(used, 80, 90), fmt.Sprintf("%.0f%% used", used)})
+		}
+	}
+
+	total, okT := f.num("memory", "node", "total_kb")
+	avail, okA := f.num("memory", "node", "available_kb")
+	if okT && okA && total > 0 {
+		used := 100 - avail/total*100
+		checks = append(checks, Check{"memory", threshold(used, 90, 95),
+			fmt.Sprintf("%.0f%% used, %.0f MiB available", used, avail/1024)})
+	} else {
+		checks = append(checks, Check{"memory", Warn, "usage unknown"})
+	}
+
+	pidMax, okM := f.num("pid", "node", "max")
+	pids, okC := f.num("pid", "node", "count")
+	if okM && okC && pidMax > 0 {
+		checks = append(checks, Check{"pids", threshold(pids/pidMax*100, 80, 90),
+			fmt.Sprintf("%.0f of %.0f", pids, pidMax)})
+	} else {
+		checks = append(checks, Check{"pids", Warn, "usage unknown"})
+	}
+
+	load5, okL := f.num("load", "node", "5m")
+	cpus, okP := f.num("load", "node", "cpus")
+	if okL && okP && cpus > 0 {
+		checks = append(checks, Check{"load", threshold(load5/cpus, 1, 2),
+			fmt.Sprintf("5m load %.2f on %.0f CPUs", load5, cpus)})
+	} else {
+		checks = append(checks, Check{"load", Warn, "load unknown"})
+	}
+
+	switch sync := f.get("clock", "node", "synchronized"); sync {
+	case "yes":
+		checks = append(checks, Check{"clock", Pass, "NTP synchronized"})
+	case "no":
+		checks = append(checks, Check{"clock", Fail, "NTP not synchronized"})
+	default:
+		checks = append(checks, Check{"clock", Warn, "synchronization state unknown"})
+	}
+
+	now, err := time.Parse(time.RFC3339, f.get("clock", "node", "now"))
+	if err != nil {
+		now = time.Now()
+	}
+	for _, c := range f.subjects["cert"] {
+		notAfter, err := time.Parse(time.RFC3339, f.get("cert", c, "not_after"))
+		if err != nil {
+			checks = append(checks, Check{"cert/" + c, Warn, "expiry unknown"})
+			continue
+		}
+		left := notAfter.Sub(now)
+		detail := fmt.Sprintf("expires %s (%s)", notAfter.Format("2006-01-02"), humanDays(left))
+		switch {
+		case left < 7*24*time.Hour:
+			checks = append(checks, Check{"cert/" + c, Fail, detail})
+		case left < 30*24*time.Hour:
+			checks = append(checks, Check{"cert/" + c, Warn, detail})
+		default:
+			checks = append(checks, Check{"cert/" + c, Pass, detail})
+		}
+	}
+
+	if ooms, ok := f.num("dmesg", "node", "oom_kills"); ok {
+		v := Pass
+		if ooms > 0 {
+			v = Warn
+		}
+		checks = append(checks, Check{"oom-kills", v, fmt.Sprintf("%.0f in dmesg", ooms)})
+	}
+	if errs, ok := f.num("dmesg", "node", "errors"); ok {
+		v, detail := Pass, "none in dmesg"
+		if errs > 0 {
+			v = Warn
+			detail = fmt.Sprintf("%.0f in dmesg", errs)
+			if last := f.values["dmesg"]["node"]["last_error"]; len(last) > 0 {
+				detail += "; last: " + last[len(last)-1]
+			}
+		}
+		checks = append(checks, Check{"kernel-errors", v, detail})
+	}
+
+	return checks
+}
+
+func humanDays(d time.Duration) string {
+	days := int(d.Hours() / 24)
+	if d < 0 {
+		return fmt.Sprintf("expired %d days ago", -days)
+	}
+	return fmt.Sprintf("in %d days", days)
+}
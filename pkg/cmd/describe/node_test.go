@@ -0,0 +1,63 @@
+package describe
+
+import (
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	out := `unit kubelet ActiveState=active
+unit kubelet SubState=running
+unit kubelet NRestarts=0
+unit containerd ActiveState=active
+unit containerd SubState=running
+unit containerd NRestarts=4
+unit azure-cns ActiveState=not-found
+disk /var/lib/containerd used=93
+inode /var/lib/containerd used=12
+memory node total_kb=8000000
+memory node available_kb=400000
+pid node max=4194304
+pid node count=512
+load node 1m=0.5
+load node 5m=0.4
+load node cpus=4
+clock node synchronized=no
+clock node now=2026-01-01T00:00:00Z
+cert /var/lib/kubelet/pki/kubelet-client-current.pem not_after=2026-01-20T00:00:00Z
+cert /etc/kubernetes/certs/apiserver.crt not_after=2027-01-01T00:00:00Z
+dmesg node oom_kills=2
+dmesg node errors=1
+dmesg node last_error=[Thu Jan  1 00:00:00 2026] EXT4-fs error`
+
+	want := map[string]Verdict{
+		"unit/kubelet":              Pass,
+		"unit/containerd":           Warn,
+		"disk//var/lib/containerd":  Fail,
+		"inode//var/lib/containerd": Pass,
+		"memory":                    Fail,
+		"pids":                      Pass,
+		"load":                      Pass,
+		"clock":                     Fail,
+		"cert//var/lib/kubelet/pki/kubelet-client-current.pem": Warn,
+		"cert//etc/kubernetes/certs/apiserver.crt":             Pass,
+		"oom-kills":     Warn,
+		"kernel-errors": Warn,
+	}
+
+	checks := evaluate(parseFacts(out))
+	got := map[string]Verdict{}
+	for _, c := range checks {
+		got[c.Name] = c.Verdict
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("%s: got %q, want %q", name, got[name], v)
+		}
+	}
+	if _, ok := got["unit/azure-cns"]; ok {
+		t.Errorf("azure-cns should be skipped when not installed")
+	}
+	if len(checks) != len(want) {
+		t.Errorf("got %d checks, want %d: %+v", len(checks), len(want), checks)
+	}
+}
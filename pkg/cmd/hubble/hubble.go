@@ -0,0 +1,243 @@
+package hubble
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/cilium"
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
+	"github.com/matmerr/kubectl-vmss/pkg/transfer"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// maxWindow bounds "observe --since": the agent's flow ring buffer rarely
+// holds more anyway, and every KB of output is a run-command round trip.
+const maxWindow = 10 * time.Minute
+
+// socket is the agent's local Hubble API, reachable on the node without
+// Hubble Relay.
+const socket = "unix:///var/run/cilium/hubble.sock"
+
+type hubbleOptions struct {
+	namespace string
+	node      string
+	maxSize   int64
+	chunkSize int
+
+	pod  string
+	args []string
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdHubble returns a cobra command for "kubectl vmss hubble".
+func NewCmdHubble(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &hubbleOptions{
+		namespace: "kube-system",
+		maxSize:   1 << 20,
+		chunkSize: transfer.DefaultChunkSize,
+		streams:   streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "hubble [flags] (<pod> | --node <node>) [hubble-args...]",
+		Short: "Run the hubble CLI against a cilium agent's local socket",
+		Long: `Run the hubble CLI from the cilium agent's image on the agent's node, talking
+to the agent's local Hubble socket (` + socket + `)
+instead of Hubble Relay. The image is mounted as for "kubectl vmss cilium",
+so this works when Relay, or the agent pod itself, is broken.
+
+Flags of this command go before the pod (or --node); everything after it
+is passed to hubble, "observe" by default. Flows only come from the agent on
+that node, so pick the node of the pod you are interested in.
+
+Output is staged on the node and downloaded in chunks of a few KB, so
+"observe" is bounded: --follow is refused, --since may reach back at most
+` + maxWindow.String() + `, and output beyond --max-size bytes is cut off.`,
+		Example: `  # The last minute of flows of one pod, as JSON
+  kubectl vmss hubble cilium-6jnvz observe --since 1m --pod default/my-app -o json
+
+  # Dropped flows on a node whose agent pod is gone
+  kubectl vmss hubble --node aks-nodepool1-vmss000000 observe --since 5m --verdict DROPPED
+
+  # Hubble status of the local agent
+  kubectl vmss hubble cilium-6jnvz status`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if o.node == "" {
+				if len(args) == 0 {
+					return fmt.Errorf("requires a cilium pod name, or --node <node>")
+				}
+				o.pod = args[0]
+				args = args[1:]
+			}
+			if len(args) == 0 {
+				args = []string{"observe"}
+			}
+			if err := checkObserveArgs(args, time.Now()); err != nil {
+				return err
+			}
+			o.args = args
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+	// Everything after the pod belongs to hubble, including its flags.
+	cmd.Flags().SetInterspersed(false)
+
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", o.namespace, "Namespace for pod lookup")
+	cmd.Flags().StringVar(&o.node, "node", "", "Find the cilium agent on this node instead of naming its pod")
+	cmd.Flags().Int64Var(&o.maxSize, "max-size", o.maxSize, "Cut hubble's output off after this many bytes")
+	cmd.Flags().IntVar(&o.chunkSize, "chunk-size", o.chunkSize, "Raw bytes transferred per run-command call")
+
+	return cmd
+}
+
+// checkObserveArgs refuses "observe" invocations whose output is unbounded:
+// following, or a --since further back than maxWindow (measured from
+// --until when that is given).
+func checkObserveArgs(args []string, now time.Time) error {
+	if args[0] != "observe" {
+		return nil
+	}
+	var since, until string
+	for i := 1; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		switch name {
+		case "-f", "--follow":
+			return fmt.Errorf("--follow is not supported: run-command returns output only once hubble exits")
+		case "--since", "--until":
+			if !hasValue {
+				if i+1 == len(args) {
+					return fmt.Errorf("%s needs a value", name)
+				}
+				i++
+				value = args[i]
+			}
+			if name == "--since" {
+				since = value
+			} else {
+				until = value
+			}
+		}
+	}
+	if since == "" {
+		return nil
+	}
+
+	end := now
+	if until != "" {
+		t, err := parseTime(until, now)
+		if err != nil {
+			return err
+		}
+		end = t
+	}
+	start, err := parseTime(since, now)
+	if err != nil {
+		return err
+	}
+	if end.Sub(start) > maxWindow {
+		return fmt.Errorf("--since reaches back %s, more than the %s limit; use --until to move the window",
+			end.Sub(start).Round(time.Second), maxWindow)
+	}
+	return nil
+}
+
+// parseTime reads hubble's --since/--until values: a duration before now
+// or an RFC3339 timestamp.
+func parseTime(v string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want a duration such as 1m or an RFC3339 timestamp", v)
+	}
+	return t, nil
+}
+
+// Run executes the hubble command.
+func (o *hubbleOptions) Run(ctx context.Context) error {
+	node := o.node
+	var fallbackImage string
+	if node == "" {
+		var err error
+		node, err = o.runner.ResolveNodeFromPod(ctx, o.namespace, o.pod)
+		if err != nil {
+			return err
+		}
+	} else {
+		fallbackImage = cilium.FallbackImage(ctx, o.runner, o.namespace, o.streams.ErrOut)
+	}
+	info, err := o.runner.ResolveVMSS(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.streams.ErrOut, "Running hubble %s on %s/%s...\n", strings.Join(o.args, " "), info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, o.buildHubbleScript(fallbackImage))
+	if err != nil {
+		return err
+	}
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+	if strings.TrimSpace(result.Stdout) == "" {
+		return nil
+	}
+	d := &transfer.Downloader{Runner: o.runner, Info: info, ChunkSize: o.chunkSize, Progress: o.streams.ErrOut}
+	data, err := d.FetchStaged(ctx, result.Stdout)
+	if err != nil {
+		return err
+	}
+
+	if int64(len(data)) >= o.maxSize {
+		// drop the line cut in half by --max-size
+		if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+			data = data[:i+1]
+		}
+		defer fmt.Fprintf(o.streams.ErrOut, "Output cut off at --max-size %d bytes; narrow --since or add filters\n", o.maxSize)
+	}
+	_, err = o.streams.Out.Write(data)
+	return err
+}
+
+// buildHubbleScript returns the script that mounts the agent's image with
+// cilium.SetupScript, runs its hubble against the local socket with output
+// cut at maxSize, and gzips it, printing the sizes and checksums expected
+// by transfer.ParseStaged. Without output it prints nothing.
+func (o *hubbleOptions) buildHubbleScript(fallbackImage string) string {
+	args := make([]string, len(o.args))
+	for i, a := range o.args {
+		args[i] = vmss.ShellQuote(a)
+	}
+	return fmt.Sprintf(`set -e
+%s
+
+# --- Step 4: run hubble against the agent's socket ---
+HUBBLE="$MNT/usr/bin/hubble"
+if [ ! -x "$HUBBLE" ]; then
+  echo "Error: hubble not found in image $IMAGE" >&2
+  exit 1
+fi
+export HUBBLE_SERVER=%s
+OUT=$(mktemp /tmp/kubectl-vmss-hubble.XXXXXX)
+"$HUBBLE" %s 2>"$OUT.err" | head -c %d > "$OUT"
+grep -v '^$' "$OUT.err" >&2 || true
+rm -f "$OUT.err"
+if [ ! -s "$OUT" ]; then
+  rm -f "$OUT"
+  exit 0
+fi
+%s`,
+		cilium.SetupScript(o.namespace, o.pod, fallbackImage, false), socket, strings.Join(args, " "), o.maxSize, nodescript.StageGzip("$OUT"))
+}
@@ -0,0 +1,55 @@
+package hubble
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckObserveArgs(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		args    []string
+		wantErr bool
+	}{
+		{[]string{"observe"}, false},
+		{[]string{"observe", "--since", "1m", "--pod", "default/my-app", "-o", "json"}, false},
+		{[]string{"observe", "--since=10m"}, false},
+		{[]string{"observe", "--since", "11m"}, true},
+		{[]string{"observe", "--since", "2026-10-18T11:55:00Z"}, false},
+		{[]string{"observe", "--since", "2026-10-18T10:00:00Z"}, true},
+		{[]string{"observe", "--since", "2026-10-18T10:00:00Z", "--until", "2026-10-18T10:05:00Z"}, false},
+		{[]string{"observe", "--since", "yesterday"}, true},
+		{[]string{"observe", "--since"}, true},
+		{[]string{"observe", "-f"}, true},
+		{[]string{"observe", "--follow", "--since", "1m"}, true},
+		{[]string{"status", "--since", "1h"}, false},
+	}
+
+	for _, tt := range tests {
+		err := checkObserveArgs(tt.args, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkObserveArgs(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBuildHubbleScript(t *testing.T) {
+	o := &hubbleOptions{
+		namespace: "kube-system",
+		pod:       "cilium-6jnvz",
+		maxSize:   1 << 20,
+		args:      []string{"observe", "--since", "1m", "--pod", "default/my app"},
+	}
+	script := o.buildHubbleScript("")
+	for _, want := range []string{
+		"--name '^cilium-6jnvz$'",
+		"export HUBBLE_SERVER=unix:///var/run/cilium/hubble.sock",
+		`"$HUBBLE" 'observe' '--since' '1m' '--pod' 'default/my app' 2>"$OUT.err" | head -c 1048576 > "$OUT"`,
+		`echo "$SIZE $SUM"`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package imageexec
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+type imageExecOptions struct {
+	namespace string
+	node      string
+	image     string
+	container string
+	chroot    bool
+
+	pod     string
+	command []string
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdImageExec returns a cobra command for "kubectl vmss image-exec".
+func NewCmdImageExec(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &imageExecOptions{
+		namespace: "kube-system",
+		streams:   streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "image-exec <pod> [--image <ref> | --container <name>] -- <path-in-image> [args...]",
+		Short: "Run a binary from a container image in a pod's network namespace",
+		Long: `Run a binary taken from a container image inside a pod's network namespace,
+via VMSS run-command. The image is pulled onto the node if needed, mounted
+with 'ctr -n k8s.io images mount', and unmounted when the command exits.
+
+The image is either given with --image or taken from one of the pod's
+containers with --container (running or crashed); without either, the
+pod's first container is used.
+
+By default the binary runs directly from the mounted image, so it keeps the
+node's filesystem view: static binaries such as cilium-dbg, hubble or
+bpftool can reach sockets under /var/run. Dynamically linked tools need
+--chroot, which runs them inside the image root with /proc, /sys and /dev
+bound in. Images are pulled anonymously through the CRI, so private
+registries only work for images already on the node.`,
+		Example: `  # hubble from the cilium agent's own image
+  kubectl vmss image-exec cilium-6jnvz -c cilium-agent -- /usr/bin/hubble observe --last 20
+
+  # bpftool from the cilium image
+  kubectl vmss image-exec cilium-6jnvz -- /usr/local/bin/bpftool net show
+
+  # netshoot tools against a distroless pod
+  kubectl vmss image-exec my-app -n default --image nicolaka/netshoot --chroot -- /usr/bin/dig +short kubernetes.default.svc.cluster.local`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.ArgsLenAtDash() != 1 {
+				return fmt.Errorf("usage: kubectl vmss image-exec <pod> [flags] -- <path-in-image> [args...]")
+			}
+			if o.image != "" && o.container != "" {
+				return fmt.Errorf("--image and --container are mutually exclusive")
+			}
+			o.pod = args[0]
+			o.command = args[1:]
+			if !strings.HasPrefix(o.command[0], "/") {
+				return fmt.Errorf("the binary must be an absolute path inside the image, got %q", o.command[0])
+			}
+			if o.image != "" {
+				o.image = nodescript.NormalizeImageRef(o.image)
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", o.namespace, "Namespace for pod lookup")
+	cmd.Flags().StringVar(&o.node, "node", "", "Target a node directly instead of resolving it from the pod")
+	cmd.Flags().StringVar(&o.image, "image", "", "Image to run the binary from, pulled if missing")
+	cmd.Flags().StringVarP(&o.container, "container", "c", "", "Use the image of this container in the pod (default: first container)")
+	cmd.Flags().BoolVar(&o.chroot, "chroot", false, "Run inside the image root, for dynamically linked binaries")
+
+	return cmd
+}
+
+// Run executes the image-exec command.
+func (o *imageExecOptions) Run(ctx context.Context) error {
+	node := o.node
+	if node == "" {
+		var err error
+		node, err = o.runner.ResolveNodeFromPod(ctx, o.namespace, o.pod)
+		if err != nil {
+			return err
+		}
+	}
+	info, err := o.runner.ResolveVMSS(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	if o.image == "" && o.container == "" {
+		o.container, err = o.runner.GetContainerName(ctx, o.namespace, o.pod)
+		if err != nil {
+			return err
+		}
+	}
+
+	source := o.image
+	if source == "" {
+		source = "container " + o.container
+	}
+	fmt.Fprintf(o.streams.ErrOut, "Running %s from %s in pod %s/%s on %s/%s...\n",
+		o.command[0], source, o.namespace, o.pod, info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, o.buildImageExecScript())
+	if err != nil {
+		return err
+	}
+
+	if result.Stdout != "" {
+		fmt.Fprintln(o.streams.Out, result.Stdout)
+	}
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+	return nil
+}
+
+// chrootScript runs "$@" chrooted into $0 from a private mount namespace,
+// so the /proc, /sys and /dev bind mounts disappear with the process.
+const chrootScript = `for d in proc sys dev; do mkdir -p "$0/$d" && mount --rbind "/$d" "$0/$d"; done; exec chroot "$0" "$@"`
+
+// buildImageExecScript returns the script that finds the pod sandbox,
+// resolves and if necessary pulls the image, mounts it and runs the binary
+// in the sandbox's network namespace.
+func (o *imageExecOptions) buildImageExecScript() string {
+	var b strings.Builder
+	b.WriteString(nodescript.SandboxPID(o.namespace, o.pod) + "\n")
+	if o.image != "" {
+		b.WriteString("IMAGE=" + vmss.ShellQuote(o.image) + "\n" + nodescript.PullImage() + "\n")
+	} else {
+		b.WriteString(nodescript.ContainerImage(o.namespace, o.pod, o.container) + "\n")
+	}
+	b.WriteString(nodescript.MountImage() + "\n")
+
+	args := make([]string, len(o.command)-1)
+	for i, a := range o.command[1:] {
+		args[i] = " " + vmss.ShellQuote(a)
+	}
+	fmt.Fprintf(&b, `BIN=%s
+if [ ! -e "$MNT$BIN" ]; then
+  echo "Error: $BIN not found in image $IMAGE" >&2
+  exit 1
+fi
+`, vmss.ShellQuote(o.command[0]))
+	if o.chroot {
+		fmt.Fprintf(&b, `nsenter --net="/proc/$SANDBOX_PID/ns/net" -- unshare --mount --propagation private -- sh -c %s "$MNT" "$BIN"%s`,
+			vmss.ShellQuote(chrootScript), strings.Join(args, ""))
+	} else {
+		fmt.Fprintf(&b, `nsenter --net="/proc/$SANDBOX_PID/ns/net" -- "$MNT$BIN"%s`, strings.Join(args, ""))
+	}
+	b.WriteString(`
+RC=$?
+[ "$RC" -eq 0 ] || echo "exit status $RC" >&2`)
+	return b.String()
+}
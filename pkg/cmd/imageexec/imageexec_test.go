@@ -0,0 +1,33 @@
+package imageexec
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildImageExecScript(t *testing.T) {
+	o := &imageExecOptions{namespace: "default", pod: "my-app", image: "docker.io/nicolaka/netshoot:latest", chroot: true,
+		command: []string{"/usr/bin/dig", "+short", "it's"}}
+	script := o.buildImageExecScript()
+	for _, want := range []string{
+		"crictl pull \"$IMAGE\"",
+		"ctr -n k8s.io images mount \"$IMAGE\" \"$MNT\"",
+		"images unmount --rm",
+		`unshare --mount --propagation private -- sh -c`,
+		`"$MNT" "$BIN" '+short' 'it'\''s'`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q:\n%s", want, script)
+		}
+	}
+
+	o = &imageExecOptions{namespace: "kube-system", pod: "cilium-6jnvz", container: "cilium-agent",
+		command: []string{"/usr/bin/hubble", "observe"}}
+	script = o.buildImageExecScript()
+	if strings.Contains(script, "crictl pull") || !strings.Contains(script, "--name '^cilium-agent$'") {
+		t.Errorf("container image script should look up the container and not pull:\n%s", script)
+	}
+	if !strings.Contains(script, `nsenter --net="/proc/$SANDBOX_PID/ns/net" -- "$MNT$BIN" 'observe'`) {
+		t.Errorf("script should run the binary from the mount:\n%s", script)
+	}
+}
@@ -0,0 +1,237 @@
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matmerr/kubectl-vmss/pkg/nodelog"
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
+	"github.com/matmerr/kubectl-vmss/pkg/transfer"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+type journalOptions struct {
+	namespace string
+	node      string
+	pod       string
+	units     []string
+	since     time.Duration
+	sinceTime string
+	priority  string
+	grep      string
+	tail      int
+	output    string
+	maxSize   int64
+	chunkSize int
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdJournal returns a cobra command for "kubectl vmss journal".
+func NewCmdJournal(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &journalOptions{
+		namespace: "kube-system",
+		maxSize:   1 << 20,
+		chunkSize: transfer.DefaultChunkSize,
+		streams:   streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "journal <node>",
+		Short: "Read systemd journal entries from a node",
+		Long: `Read systemd journal entries from an AKS node via VMSS run-command.
+
+Entries are fetched as journalctl -o json and merged across units in
+timestamp order. All filtering (units, time window, priority, grep, tail)
+happens on the node. The entries are staged there and downloaded in chunks
+of a few KB; only the newest --max-size bytes are kept, so narrow with
+--since or --tail.`,
+		Example: `  # Last 50 kubelet entries
+  kubectl vmss journal aks-nodepool1-vmss000000 -u kubelet --tail 50
+
+  # kubelet and containerd errors from the last 10 minutes, merged
+  kubectl vmss journal aks-nodepool1-vmss000000 -u kubelet -u containerd --since 10m --priority err
+
+  # Resolve the node from a pod and emit JSON
+  kubectl vmss journal --pod cilium-6jnvz -u kubelet --grep 'cilium' -o json`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				o.node = args[0]
+			}
+			if (o.node == "") == (o.pod == "") {
+				return fmt.Errorf("specify exactly one of <node> or --pod")
+			}
+			if o.since != 0 && o.sinceTime != "" {
+				return fmt.Errorf("at most one of --since or --since-time may be specified")
+			}
+			if o.since < 0 {
+				return fmt.Errorf("--since must be greater than 0")
+			}
+			if o.sinceTime != "" {
+				if _, err := time.Parse(time.RFC3339, o.sinceTime); err != nil {
+					return fmt.Errorf("--since-time must be an RFC3339 timestamp: %w", err)
+				}
+			}
+			if o.output != "" && o.output != "json" {
+				return fmt.Errorf("unsupported output format %q (supported: json)", o.output)
+			}
+			if o.chunkSize < 0 {
+				return fmt.Errorf("--chunk-size must be positive")
+			}
+			if o.chunkSize == 0 {
+				o.chunkSize = transfer.DefaultChunkSize
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", o.namespace, "Namespace for pod lookup")
+	cmd.Flags().StringVar(&o.pod, "pod", "", "Resolve node from this pod")
+	cmd.Flags().StringArrayVarP(&o.units, "unit", "u", nil, "Systemd unit to show (repeatable; default all)")
+	cmd.Flags().DurationVar(&o.since, "since", 0, "Only return entries newer than a relative duration like 5s, 2m, or 3h")
+	cmd.Flags().StringVar(&o.sinceTime, "since-time", "", "Only return entries after a specific date (RFC3339)")
+	cmd.Flags().StringVarP(&o.priority, "priority", "p", "", "Filter by priority name, number or range (e.g. err, 0..4)")
+	cmd.Flags().StringVar(&o.grep, "grep", "", "Only return entries whose message matches this regex (journalctl --grep)")
+	cmd.Flags().IntVar(&o.tail, "tail", 0, "Number of entries to show (0 = all)")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format: json")
+	cmd.Flags().Int64Var(&o.maxSize, "max-size", o.maxSize, "Keep only the newest this many bytes of entries")
+	cmd.Flags().IntVar(&o.chunkSize, "chunk-size", o.chunkSize, "Raw bytes transferred per run-command call")
+
+	return cmd
+}
+
+// Run executes the journal command.
+func (o *journalOptions) Run(ctx context.Context) error {
+	node := o.node
+
+	if o.pod != "" {
+		var err error
+		node, err = o.runner.ResolveNodeFromPod(ctx, o.namespace, o.pod)
+		if err != nil {
+			return err
+		}
+	}
+
+	info, err := o.runner.ResolveVMSS(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	script := buildJournalScript(o.units, o.sinceArg(), o.priority, o.grep, o.tail, o.maxSize)
+
+	fmt.Fprintf(o.streams.ErrOut, "Running on %s/%s...\n", info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, script)
+	if err != nil {
+		return err
+	}
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+
+	total, err := parseTotal(result.Stdout)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if total > 0 {
+		d := &transfer.Downloader{Runner: o.runner, Info: info, ChunkSize: o.chunkSize, Progress: o.streams.ErrOut}
+		if data, err = d.FetchStaged(ctx, result.Stdout); err != nil {
+			return err
+		}
+	}
+	if total > int64(len(data)) {
+		// drop the entry cut in half by --max-size
+		if i := strings.IndexByte(string(data), '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+		defer fmt.Fprintf(o.streams.ErrOut, "Only the newest %d of %d bytes were read; narrow with --since or --tail, or raise --max-size\n", o.maxSize, total)
+	}
+
+	entries, skipped := nodelog.ParseJournal(string(data))
+	if skipped > 0 {
+		fmt.Fprintf(o.streams.ErrOut, "Warning: skipped %d unparsable journal line(s); output may have been truncated, narrow with --since or --tail\n", skipped)
+	}
+
+	if o.output == "json" {
+		if entries == nil {
+			entries = []nodelog.JournalEntry{}
+		}
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.streams.Out, string(data))
+		return nil
+	}
+	for i := range entries {
+		fmt.Fprintln(o.streams.Out, entries[i].String())
+	}
+	return nil
+}
+
+// sinceArg returns the journalctl --since value, or "" for no time window.
+func (o *journalOptions) sinceArg() string {
+	switch {
+	case o.since > 0:
+		return fmt.Sprintf("-%ds", int64(o.since/time.Second))
+	case o.sinceTime != "":
+		t, _ := time.Parse(time.RFC3339, o.sinceTime)
+		return t.UTC().Format("2006-01-02 15:04:05") + " UTC"
+	}
+	return ""
+}
+
+// buildJournalScript returns the script that writes the matching entries to
+// a file on the node, prints "total <bytes>" of them, keeps the newest
+// maxSize bytes and gzips them, printing the sizes and checksums expected
+// by transfer.ParseStaged. Without entries it prints only the total.
+func buildJournalScript(units []string, since, priority, grep string, tail int, maxSize int64) string {
+	args := []string{"journalctl", "--no-pager", "-o", "json",
+		"--output-fields=" + strings.Join(nodelog.JournalFields, ",")}
+	for _, u := range units {
+		args = append(args, "-u", vmss.ShellQuote(u))
+	}
+	if since != "" {
+		args = append(args, "--since", vmss.ShellQuote(since))
+	}
+	if priority != "" {
+		args = append(args, "-p", vmss.ShellQuote(priority))
+	}
+	if grep != "" {
+		args = append(args, "--grep", vmss.ShellQuote(grep))
+	}
+	if tail > 0 {
+		args = append(args, "-n", fmt.Sprintf("%d", tail))
+	}
+	return fmt.Sprintf(`ALL=$(mktemp /tmp/kubectl-vmss-journal.XXXXXX)
+%s > "$ALL"
+echo "total $(stat -c %%s "$ALL")"
+if [ ! -s "$ALL" ]; then
+  rm -f "$ALL"
+  exit 0
+fi
+OUT="$ALL.cut"
+tail -c %d "$ALL" > "$OUT"
+rm -f "$ALL"
+%s`, strings.Join(args, " "), maxSize, nodescript.StageGzip("$OUT"))
+}
+
+// parseTotal reads the "total <bytes>" line buildJournalScript prints
+// before the staging lines.
+func parseTotal(out string) (int64, error) {
+	var total int64
+	if _, err := fmt.Sscanf(strings.TrimSpace(out), "total %d", &total); err != nil {
+		return 0, fmt.Errorf("unexpected journal output: %q", out)
+	}
+	return total, nil
+}
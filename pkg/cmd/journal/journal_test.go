@@ -0,0 +1,107 @@
+package journal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestBuildJournalScript(t *testing.T) {
+	script := buildJournalScript([]string{"kubelet"}, "-600s", "err", "cilium", 50, 1<<20)
+	for _, want := range []string{
+		"journalctl --no-pager -o json --output-fields=MESSAGE,PRIORITY,_SYSTEMD_UNIT,SYSLOG_IDENTIFIER,_PID -u 'kubelet' --since '-600s' -p 'err' --grep 'cilium' -n 50 > \"$ALL\"\n",
+		`echo "total $(stat -c %s "$ALL")"`,
+		"tail -c 1048576 \"$ALL\" > \"$OUT\"\n",
+		`gzip -n "$OUT"`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, "%!") {
+		t.Error("script has a formatting error")
+	}
+}
+
+func TestParseTotal(t *testing.T) {
+	if total, err := parseTotal("total 1234\n99 abc\n/tmp/x.gz 50 def\n"); err != nil || total != 1234 {
+		t.Errorf("got %d, %v", total, err)
+	}
+	if _, err := parseTotal("journalctl: command not found\n"); err == nil {
+		t.Error("parseTotal should fail without a total line")
+	}
+}
+
+var chunkRe = regexp.MustCompile(`skip=(\d+) count=(\d+)`)
+
+// stagedRunner serves entries the way buildJournalScript stages them.
+type stagedRunner struct {
+	vmss.Runner
+	total int
+	gz    []byte
+	stage string
+}
+
+func (r *stagedRunner) ResolveVMSS(context.Context, string) (*vmss.NodeInfo, error) {
+	return &vmss.NodeInfo{VMSSName: "vmss", InstanceID: "0"}, nil
+}
+
+func (r *stagedRunner) RunCommand(_ context.Context, _ *vmss.NodeInfo, script string) (*vmss.CommandResult, error) {
+	if strings.HasPrefix(script, "ALL=") {
+		return &vmss.CommandResult{Stdout: r.stage}, nil
+	}
+	if m := chunkRe.FindStringSubmatch(script); m != nil {
+		off, _ := strconv.Atoi(m[1])
+		n, _ := strconv.Atoi(m[2])
+		end := min(off+n, len(r.gz))
+		return &vmss.CommandResult{Stdout: base64.StdEncoding.EncodeToString(r.gz[off:end])}, nil
+	}
+	return &vmss.CommandResult{}, nil
+}
+
+func TestJournalDropsCutEntry(t *testing.T) {
+	// The newest bytes of two entries, cut in the middle of the first.
+	data := []byte(`"kubelet.service","MESSAGE":"cut"}
+{"__REALTIME_TIMESTAMP":"1700000001000000","SYSLOG_IDENTIFIER":"kubelet","_PID":"1234","PRIORITY":"6","MESSAGE":"starting"}
+`)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+	sum := sha256.Sum256(data)
+	gzSum := sha256.Sum256(gz.Bytes())
+	runner := &stagedRunner{
+		gz: gz.Bytes(),
+		stage: fmt.Sprintf("total 5000\n%d %s\n/tmp/kubectl-vmss-journal.abc.cut.gz %d %s\n",
+			len(data), hex.EncodeToString(sum[:]), gz.Len(), hex.EncodeToString(gzSum[:])),
+	}
+
+	var stdout, stderr bytes.Buffer
+	o := &journalOptions{
+		node:      "n0",
+		maxSize:   int64(len(data)),
+		chunkSize: 100,
+		runner:    runner,
+		streams:   genericclioptions.IOStreams{Out: &stdout, ErrOut: &stderr},
+	}
+	if err := o.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v\n%s", err, stderr.String())
+	}
+	if got, want := stdout.String(), "2023-11-14T22:13:21.000000Z kubelet[1234]: starting\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if strings.Contains(stderr.String(), "unparsable") || !strings.Contains(stderr.String(), fmt.Sprintf("Only the newest %d of 5000 bytes were read", len(data))) {
+		t.Errorf("stderr:\n%s", stderr.String())
+	}
+}
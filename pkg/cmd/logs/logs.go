@@ -3,6 +3,8 @@ package logs
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/matmerr/kubectl-vmss/pkg/vmss"
 	"github.com/spf13/cobra"
@@ -10,11 +12,17 @@ import (
 )
 
 type logsOptions struct {
-	namespace string
-	node      string
-	tail      int
-	previous  bool
-	pod       string
+	namespace  string
+	node       string
+	tail       int
+	previous   bool
+	follow     bool
+	interval   time.Duration
+	since      time.Duration
+	sinceTime  string
+	timestamps bool
+	grep       string
+	pod        string
 
 	runner  vmss.Runner
 	streams genericclioptions.IOStreams
@@ -24,6 +32,7 @@ type logsOptions struct {
 func NewCmdLogs(streams genericclioptions.IOStreams) *cobra.Command {
 	o := &logsOptions{
 		namespace: "kube-system",
+		interval:  10 * time.Second,
 		streams:   streams,
 	}
 
@@ -38,7 +47,13 @@ func NewCmdLogs(streams genericclioptions.IOStreams) *cobra.Command {
   kubectl vmss logs coredns-abc --tail 50
 
   # Get logs from previous container instance
-  kubectl vmss logs cilium-6jnvz --previous`,
+  kubectl vmss logs cilium-6jnvz --previous
+
+  # Keep polling for new lines until interrupted
+  kubectl vmss logs cilium-6jnvz -f
+
+  # Only errors from the last 15 minutes, filtered on the node
+  kubectl vmss logs cilium-6jnvz --since 15m --grep 'level=(error|warning)'`,
 		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) > 0 {
@@ -47,6 +62,23 @@ func NewCmdLogs(streams genericclioptions.IOStreams) *cobra.Command {
 			if o.pod == "" && o.node == "" {
 				return fmt.Errorf("specify a pod name or --node")
 			}
+			if o.follow && o.previous {
+				return fmt.Errorf("--follow cannot be used with --previous")
+			}
+			if o.interval <= 0 {
+				return fmt.Errorf("--poll-interval must be positive")
+			}
+			if o.since != 0 && o.sinceTime != "" {
+				return fmt.Errorf("at most one of --since or --since-time may be specified")
+			}
+			if o.since < 0 {
+				return fmt.Errorf("--since must be greater than 0")
+			}
+			if o.sinceTime != "" {
+				if _, err := time.Parse(time.RFC3339, o.sinceTime); err != nil {
+					return fmt.Errorf("--since-time must be an RFC3339 timestamp: %w", err)
+				}
+			}
 			if o.runner == nil {
 				o.runner = vmss.NewDefaultRunner()
 			}
@@ -58,6 +90,12 @@ func NewCmdLogs(streams genericclioptions.IOStreams) *cobra.Command {
 	cmd.Flags().StringVar(&o.node, "node", "", "Target a node directly instead of a pod")
 	cmd.Flags().IntVar(&o.tail, "tail", 0, "Number of log lines to show (0 = all)")
 	cmd.Flags().BoolVar(&o.previous, "previous", false, "Show logs from previous container instance")
+	cmd.Flags().BoolVarP(&o.follow, "follow", "f", false, "Poll for new log lines until interrupted")
+	cmd.Flags().DurationVar(&o.interval, "poll-interval", o.interval, "Time between polls when following")
+	cmd.Flags().DurationVar(&o.since, "since", 0, "Only return logs newer than a relative duration like 5s, 2m, or 3h")
+	cmd.Flags().StringVar(&o.sinceTime, "since-time", "", "Only return logs after a specific date (RFC3339)")
+	cmd.Flags().BoolVar(&o.timestamps, "timestamps", false, "Include timestamps on each line in the log output")
+	cmd.Flags().StringVar(&o.grep, "grep", "", "Only return lines matching this extended regular expression (filtered on the node)")
 
 	return cmd
 }
@@ -86,7 +124,11 @@ func (o *logsOptions) Run(ctx context.Context) error {
 		return err
 	}
 
-	script := buildLogsScript(container, o.tail, o.previous)
+	if o.follow {
+		return o.runFollow(ctx, info, container)
+	}
+
+	script := buildLogsScript(container, o.previous, o.filter())
 
 	fmt.Fprintf(o.streams.ErrOut, "Running on %s/%s...\n", info.VMSSName, info.InstanceID)
 	result, err := o.runner.RunCommand(ctx, info, script)
@@ -103,25 +145,235 @@ func (o *logsOptions) Run(ctx context.Context) error {
 	return nil
 }
 
-func buildLogsScript(container string, tail int, previous bool) string {
+// logsFilter holds the options that narrow crictl logs output on the node,
+// so the run-command output budget is spent on relevant lines.
+type logsFilter struct {
+	tail       int
+	since      string // crictl --since value: relative duration or RFC3339
+	timestamps bool
+	grep       string
+}
+
+func (o *logsOptions) filter() logsFilter {
+	f := logsFilter{tail: o.tail, timestamps: o.timestamps, grep: o.grep}
+	if o.since > 0 {
+		f.since = o.since.String()
+	} else if o.sinceTime != "" {
+		f.since = o.sinceTime
+	}
+	return f
+}
+
+// command returns the crictl logs invocation for $CID. With a grep filter
+// the tail is applied after matching, so --tail counts matching lines.
+func (f logsFilter) command() string {
+	cmd := "crictl logs"
+	if f.since != "" {
+		cmd += " --since=" + f.since
+	}
+	if f.timestamps {
+		cmd += " --timestamps"
+	}
+	if f.grep == "" {
+		if f.tail > 0 {
+			cmd += fmt.Sprintf(" --tail=%d", f.tail)
+		}
+		return cmd + " $CID"
+	}
+	cmd += " $CID 2>&1 | grep -E -- " + vmss.ShellQuote(f.grep)
+	if f.tail > 0 {
+		cmd += fmt.Sprintf(" | tail -n %d", f.tail)
+	}
+	return cmd
+}
+
+func buildLogsScript(container string, previous bool, f logsFilter) string {
 	filter := ""
 	if container != "" {
 		filter = fmt.Sprintf("--name %s", container)
 	}
 
-	tailFlag := ""
-	if tail > 0 {
-		tailFlag = fmt.Sprintf(" --tail=%d", tail)
-	}
-
 	if previous {
 		return fmt.Sprintf(
-			`RUNNING=$(crictl ps %s -q | head -1); ALL=$(crictl ps -a %s -q); if [ -n "$RUNNING" ]; then CID=$(echo "$ALL" | grep -v "$RUNNING" | head -1); else CID=$(echo "$ALL" | head -1); fi; if [ -z "$CID" ]; then echo 'No previous container found for %s' >&2; exit 1; fi; crictl logs%s $CID`,
-			filter, filter, container, tailFlag,
+			`RUNNING=$(crictl ps %s -q | head -1); ALL=$(crictl ps -a %s -q); if [ -n "$RUNNING" ]; then CID=$(echo "$ALL" | grep -v "$RUNNING" | head -1); else CID=$(echo "$ALL" | head -1); fi; if [ -z "$CID" ]; then echo 'No previous container found for %s' >&2; exit 1; fi; %s`,
+			filter, filter, container, f.command(),
 		)
 	}
 	return fmt.Sprintf(
-		`CID=$(crictl ps %s -q | head -1); if [ -z "$CID" ]; then CID=$(crictl ps -a %s -q | head -1); fi; if [ -z "$CID" ]; then echo 'No container found for %s' >&2; exit 1; fi; crictl logs%s $CID`,
-		filter, filter, container, tailFlag,
+		`CID=$(crictl ps %s -q | head -1); if [ -z "$CID" ]; then CID=$(crictl ps -a %s -q | head -1); fi; if [ -z "$CID" ]; then echo 'No container found for %s' >&2; exit 1; fi; %s`,
+		filter, filter, container, f.command(),
+	)
+}
+
+// containerIDMarker prefixes the line the follow script uses to report which
+// container it read from, so restarts can be detected between polls.
+const containerIDMarker = "__KUBECTL_VMSS_CID__="
+
+// runFollow polls the node for new log lines until ctx is cancelled. Each
+// poll asks crictl for timestamped lines since the newest one already
+// printed; lines at that boundary timestamp are deduplicated by logCursor.
+func (o *logsOptions) runFollow(ctx context.Context, info *vmss.NodeInfo, container string) error {
+	fmt.Fprintf(o.streams.ErrOut, "Following logs on %s/%s every %s (Ctrl-C to stop)...\n", info.VMSSName, info.InstanceID, o.interval)
+
+	cur := &logCursor{}
+	for {
+		script := buildFollowScript(container, cur.containerID, cur.since, o.filter())
+		result, err := o.runner.RunCommand(ctx, info, script)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if result.Stderr != "" {
+			fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+		}
+
+		cid, lines := splitFollowOutput(result.Stdout)
+		if cid != "" && cur.containerID != "" && cid != cur.containerID {
+			fmt.Fprintf(o.streams.ErrOut, "Container restarted: now following %s\n", cid)
+			cur.reset()
+		}
+		if cid != "" {
+			cur.containerID = cid
+		}
+		for _, line := range cur.advance(lines) {
+			if !o.timestamps {
+				line = stripTimestamp(line)
+			}
+			fmt.Fprintln(o.streams.Out, line)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(o.interval):
+		}
+	}
+}
+
+// buildFollowScript returns a script that prints the current container's
+// timestamped logs followed by a marker line with its ID, last so that
+// run-command's 4 KB tail keeps it. While the container
+// is still lastCID only lines since the last seen timestamp are requested;
+// a new container is read from the start. The first poll honours the
+// user's --since and --tail, applying the tail after the grep filter as
+// logsFilter.command does. The grep filter ignores the timestamp prefix
+// so anchored patterns behave as they do without --follow.
+func buildFollowScript(container, lastCID string, since time.Time, f logsFilter) string {
+	filter := ""
+	if container != "" {
+		filter = fmt.Sprintf("--name %s", container)
+	}
+
+	next := logsFilter{timestamps: true}
+	if !since.IsZero() {
+		next.since = since.UTC().Format(time.RFC3339Nano)
+	}
+
+	grep := ""
+	if f.grep != "" {
+		grep = fmt.Sprintf(` | RE=%s awk '{ l = $0; sub(/^[^ ]* /, "", l) } l ~ ENVIRON["RE"]'`, vmss.ShellQuote(f.grep))
+	}
+
+	first := logsFilter{timestamps: true}
+	firstGrep := grep
+	if lastCID == "" {
+		first.since = f.since
+		if f.grep == "" {
+			first.tail = f.tail
+		} else if f.tail > 0 {
+			firstGrep += fmt.Sprintf(" | tail -n %d", f.tail)
+		}
+	}
+
+	return fmt.Sprintf(
+		`CID=$(crictl ps %s -q | head -1); if [ -z "$CID" ]; then CID=$(crictl ps -a %s -q | head -1); fi; if [ -z "$CID" ]; then echo 'No container found for %s' >&2; exit 1; fi; if [ "$CID" = '%s' ]; then %s 2>&1%s; else %s 2>&1%s; fi; echo; echo "%s$CID"`,
+		filter, filter, container, lastCID, next.command(), grep, first.command(), firstGrep, containerIDMarker,
 	)
 }
+
+// splitFollowOutput separates the container ID marker, the last one in the
+// output, from the log lines before it. The script precedes the marker with
+// an empty line in case the logs do not end in a newline; it is dropped.
+func splitFollowOutput(stdout string) (string, []string) {
+	i := strings.LastIndex(stdout, containerIDMarker)
+	if i < 0 {
+		return "", nil
+	}
+	cid := strings.TrimSpace(stdout[i+len(containerIDMarker):])
+	logs := strings.TrimSuffix(stdout[:i], "\n")
+	logs = strings.TrimSuffix(logs, "\n")
+	if logs == "" {
+		return cid, nil
+	}
+	return cid, strings.Split(logs, "\n")
+}
+
+// logCursor tracks the position reached in a container's log stream.
+type logCursor struct {
+	containerID string
+	since       time.Time
+	// seen holds the lines already printed with timestamp equal to since.
+	// crictl's --since is inclusive, so these come back on the next poll.
+	seen map[string]bool
+}
+
+func (c *logCursor) reset() {
+	c.since = time.Time{}
+	c.seen = nil
+}
+
+// advance returns the lines that have not been printed yet and moves the
+// cursor forward. Lines without a parsable timestamp (e.g. wrapped output)
+// inherit the decision made for the preceding timestamped line.
+func (c *logCursor) advance(lines []string) []string {
+	var out []string
+	keep := true
+	for _, line := range lines {
+		ts, ok := parseTimestamp(line)
+		if !ok {
+			if keep {
+				out = append(out, line)
+			}
+			continue
+		}
+		switch {
+		case ts.Before(c.since):
+			keep = false
+		case ts.Equal(c.since) && c.seen[line]:
+			keep = false
+		default:
+			keep = true
+			if ts.After(c.since) {
+				c.since = ts
+				c.seen = map[string]bool{}
+			}
+			if c.seen == nil {
+				c.seen = map[string]bool{}
+			}
+			c.seen[line] = true
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// parseTimestamp reads the RFC3339 timestamp crictl --timestamps prepends.
+func parseTimestamp(line string) (time.Time, bool) {
+	field, _, _ := strings.Cut(line, " ")
+	ts, err := time.Parse(time.RFC3339Nano, field)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ts, true
+}
+
+// stripTimestamp removes the crictl timestamp prefix from a log line.
+func stripTimestamp(line string) string {
+	if _, ok := parseTimestamp(line); !ok {
+		return line
+	}
+	_, rest, _ := strings.Cut(line, " ")
+	return rest
+}
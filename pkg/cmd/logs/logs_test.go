@@ -0,0 +1,101 @@
+package logs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogCursorAdvance(t *testing.T) {
+	c := &logCursor{}
+
+	first := []string{
+		"2026-02-25T01:00:00.000000001Z starting",
+		"2026-02-25T01:00:01.000000000Z tick",
+		"  continuation of tick",
+		"2026-02-25T01:00:01.000000000Z tock",
+	}
+	got := c.advance(first)
+	if !reflect.DeepEqual(got, first) {
+		t.Fatalf("first poll: got %q, want %q", got, first)
+	}
+
+	// crictl --since is inclusive: the boundary lines come back and must be
+	// dropped, including the continuation line following a dropped line.
+	second := []string{
+		"2026-02-25T01:00:01.000000000Z tick",
+		"  continuation of tick",
+		"2026-02-25T01:00:01.000000000Z tock",
+		"2026-02-25T01:00:01.000000000Z tack",
+		"2026-02-25T01:00:02.000000000Z done",
+	}
+	want := []string{
+		"2026-02-25T01:00:01.000000000Z tack",
+		"2026-02-25T01:00:02.000000000Z done",
+	}
+	got = c.advance(second)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("second poll: got %q, want %q", got, want)
+	}
+
+	if got := c.advance(second); len(got) != 0 {
+		t.Fatalf("repeat poll: expected no new lines, got %q", got)
+	}
+
+	c.reset()
+	if got := c.advance(second); len(got) != len(second) {
+		t.Fatalf("after reset: expected all %d lines, got %q", len(second), got)
+	}
+}
+
+func TestSplitFollowOutput(t *testing.T) {
+	cid, lines := splitFollowOutput("2026-02-25T01:00:00Z hello\n\n" + containerIDMarker + "abc123\n")
+	if cid != "abc123" {
+		t.Errorf("container ID: got %q, want %q", cid, "abc123")
+	}
+	if len(lines) != 1 || stripTimestamp(lines[0]) != "hello" {
+		t.Errorf("lines: got %q", lines)
+	}
+
+	// Logs without a final newline, and no logs at all.
+	if cid, lines := splitFollowOutput("2026-02-25T01:00:00Z hello\n" + containerIDMarker + "abc123"); cid != "abc123" || len(lines) != 1 {
+		t.Errorf("no final newline: got %q, %q", cid, lines)
+	}
+	if cid, lines := splitFollowOutput("\n" + containerIDMarker + "abc123\n"); cid != "abc123" || lines != nil {
+		t.Errorf("no logs: got %q, %q", cid, lines)
+	}
+}
+
+func TestFollowScriptPrintsMarkerLast(t *testing.T) {
+	script := buildFollowScript("app", "", time.Time{}, logsFilter{})
+	if !strings.HasSuffix(script, `; fi; echo; echo "`+containerIDMarker+`$CID"`) {
+		t.Errorf("the container ID should be printed after the logs: %s", script)
+	}
+}
+
+func TestFollowTailAfterGrep(t *testing.T) {
+	f := logsFilter{tail: 50, grep: "error"}
+	awk := `RE='error' awk '{ l = $0; sub(/^[^ ]* /, "", l) } l ~ ENVIRON["RE"]'`
+
+	// Like f.command(), the first poll keeps the last 50 matches rather
+	// than the matches among the last 50 lines.
+	if !strings.Contains(f.command(), "| grep -E -- 'error' | tail -n 50") || strings.Contains(f.command(), "--tail") {
+		t.Errorf("command: %s", f.command())
+	}
+	script := buildFollowScript("app", "", time.Time{}, f)
+	if !strings.Contains(script, "else crictl logs --timestamps $CID 2>&1 | "+awk+" | tail -n 50; fi") || strings.Contains(script, "--tail") {
+		t.Errorf("first poll: %s", script)
+	}
+
+	// Later polls and restarted containers get no tail at all.
+	script = buildFollowScript("app", "abc123", time.Date(2026, 2, 25, 1, 0, 0, 0, time.UTC), f)
+	if strings.Contains(script, "tail") {
+		t.Errorf("later poll: %s", script)
+	}
+
+	// Without grep crictl applies the tail itself.
+	if script := buildFollowScript("app", "", time.Time{}, logsFilter{tail: 50}); !strings.Contains(script, "crictl logs --timestamps --tail=50 $CID") {
+		t.Errorf("no grep: %s", script)
+	}
+}
@@ -0,0 +1,478 @@
+package netcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// maxNodes bounds the matrix: every node reports one line per peer, and
+// run-command returns at most 4 KB of output.
+const maxNodes = 100
+
+// gatewayInterfaces hold the node's address in its pod CIDR: cilium's
+// router IP, or the bridge of kubenet and other bridge plugins.
+var gatewayInterfaces = []string{"cilium_host", "cbr0", "cni0"}
+
+type netcheckOptions struct {
+	nodes    []string
+	allNodes bool
+	selector string
+	nodeIPs  bool
+	gateways bool
+	port     int
+	window   time.Duration
+	output   string
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdNetcheck returns a cobra command for "kubectl vmss netcheck".
+func NewCmdNetcheck(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &netcheckOptions{
+		port:    10250,
+		window:  30 * time.Second,
+		streams: streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "netcheck (<node>... | -l <selector> | --all-nodes) [--nodes] [--gateways]",
+		Short: "Measure node-to-node reachability and latency as an NxN matrix",
+		Long: `Check TCP and ICMP reachability and latency between every pair of nodes and
+print one matrix per kind of address:
+
+  --nodes     the nodes' primary IPs
+  --gateways  the nodes' addresses in their pod CIDR (cilium_host, cbr0 or
+              cni0), which pod traffic to the node is routed to
+
+Without either flag both are checked. Broken pairs point at NSG rules, route
+tables (UDRs) missing a pod CIDR, and asymmetric routing, which shows up as
+pairs that work in only one direction.
+
+The check takes two rounds of run-command. The first finds each node's
+addresses. In the second, all nodes run at once: each starts a TCP
+listener on --port (unless something already listens there, as kubelet
+does on the default 10250) and connects to every peer, retrying until a
+connection succeeds or --window ends, so that peers whose run-command
+starts late are not reported as unreachable. The listener needs nc on the
+node.`,
+		Example: `  # A node pool
+  kubectl vmss netcheck --nodes -l agentpool=nodepool1
+
+  # Pod CIDR routing between two nodes, on a port of your own
+  kubectl vmss netcheck aks-nodepool1-vmss000000 aks-nodepool1-vmss000001 --gateways --port 40999`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.nodes = args
+			fanOut := o.allNodes || o.selector != ""
+			if fanOut == (len(args) > 0) {
+				return fmt.Errorf("requires node names, or one of --all-nodes and --selector")
+			}
+			if o.port < 1 || o.port > 65535 {
+				return fmt.Errorf("--port must be between 1 and 65535")
+			}
+			if o.window < 5*time.Second {
+				return fmt.Errorf("--window must be at least 5s")
+			}
+			if o.output != "" && o.output != "json" {
+				return fmt.Errorf("unsupported output format %q (supported: json)", o.output)
+			}
+			if !o.nodeIPs && !o.gateways {
+				o.nodeIPs, o.gateways = true, true
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().BoolVar(&o.allNodes, "all-nodes", false, "Check every node")
+	cmd.Flags().StringVarP(&o.selector, "selector", "l", "", "Check the nodes matching this label selector")
+	cmd.Flags().BoolVar(&o.nodeIPs, "nodes", false, "Check the nodes' IPs (default: both kinds)")
+	cmd.Flags().BoolVar(&o.gateways, "gateways", false, "Check the nodes' pod CIDR gateway addresses (default: both kinds)")
+	cmd.Flags().IntVar(&o.port, "port", o.port, "TCP port to listen on and connect to")
+	cmd.Flags().DurationVar(&o.window, "window", o.window, "How long nodes listen and retry connections")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format: json")
+
+	return cmd
+}
+
+// netNode is one node of the check.
+type netNode struct {
+	Index    int    `json:"index"`
+	Node     string `json:"node"`
+	IP       string `json:"ip,omitempty"`
+	Gateway  string `json:"gateway,omitempty"`
+	Listener string `json:"listener,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// pairResult is what one node measured towards one peer. Latencies are in
+// milliseconds; nil means not reachable, or not checked when the matching
+// Checked field is false.
+type pairResult struct {
+	From        int      `json:"from"`
+	To          int      `json:"to"`
+	Kind        string   `json:"kind"`
+	TCPMs       *float64 `json:"tcpMs"`
+	ICMPMs      *float64 `json:"icmpMs"`
+	TCPChecked  bool     `json:"-"`
+	ICMPChecked bool     `json:"-"`
+}
+
+// Address kinds of the matrix.
+const (
+	kindNode    = "node"
+	kindGateway = "gateway"
+)
+
+// netcheckReport is the JSON output of netcheck.
+type netcheckReport struct {
+	Port    int          `json:"port"`
+	Nodes   []netNode    `json:"nodes"`
+	Results []pairResult `json:"results"`
+}
+
+// Run executes the netcheck command.
+func (o *netcheckOptions) Run(ctx context.Context) error {
+	names := o.nodes
+	if len(names) == 0 {
+		var err error
+		if names, err = o.runner.ListNodes(ctx, o.selector); err != nil {
+			return err
+		}
+	}
+	if len(names) < 2 {
+		return fmt.Errorf("netcheck needs at least 2 nodes, got %d", len(names))
+	}
+	if len(names) > maxNodes {
+		return fmt.Errorf("netcheck supports at most %d nodes, got %d; narrow --selector", maxNodes, len(names))
+	}
+
+	fmt.Fprintf(o.streams.ErrOut, "Finding the addresses of %d nodes...\n", len(names))
+	discovered := vmss.RunOnNodes(ctx, o.runner, names, len(names), func(string) string { return discoverScript }, o.streams.ErrOut)
+	nodes := make([]netNode, len(names))
+	var up []string
+	for i, r := range discovered {
+		nodes[i] = parseDiscovery(i+1, r)
+		if nodes[i].Error == "" {
+			up = append(up, nodes[i].Node)
+		}
+	}
+
+	var results []pairResult
+	if len(up) > 1 {
+		fmt.Fprintf(o.streams.ErrOut, "Probing %d nodes at once for up to %s...\n", len(up), o.window)
+		byName := map[string]*netNode{}
+		for i := range nodes {
+			byName[nodes[i].Node] = &nodes[i]
+		}
+		probed := vmss.RunOnNodes(ctx, o.runner, up, len(up), func(node string) string {
+			return o.buildProbeScript(byName[node], nodes)
+		}, o.streams.ErrOut)
+		for _, r := range probed {
+			n := byName[r.Node]
+			if r.Err != nil {
+				n.Error = r.Err.Error()
+				continue
+			}
+			n.Listener, results = parseProbes(n, r.Result.Stdout, results)
+		}
+	}
+
+	if o.output == "json" {
+		if results == nil {
+			results = []pairResult{}
+		}
+		data, err := json.MarshalIndent(netcheckReport{Port: o.port, Nodes: nodes, Results: results}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.streams.Out, string(data))
+		return nil
+	}
+	return o.writeReport(o.streams.Out, nodes, results)
+}
+
+// discoverScript prints the node's primary IP, the one it reaches the
+// WireServer from, and its address in the pod CIDR, if any.
+var discoverScript = fmt.Sprintf(`echo "ip $(ip -o -4 route get 168.63.129.16 2>/dev/null | awk '{ for (i = 1; i < NF; i++) if ($i == "src") print $(i + 1) }')"
+for IF in %s; do
+  GW=$(ip -o -4 addr show dev "$IF" scope global 2>/dev/null | awk '{ sub(/\/.*/, "", $4); print $4; exit }')
+  [ -z "$GW" ] || { echo "gateway $GW"; break; }
+done`, strings.Join(gatewayInterfaces, " "))
+
+// parseDiscovery reads the output of discoverScript.
+func parseDiscovery(index int, r vmss.NodeResult) netNode {
+	n := netNode{Index: index, Node: r.Node}
+	if r.Err != nil {
+		n.Error = r.Err.Error()
+		return n
+	}
+	for _, line := range strings.Split(r.Result.Stdout, "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		if _, err := netip.ParseAddr(value); err != nil {
+			continue
+		}
+		switch key {
+		case "ip":
+			n.IP = value
+		case "gateway":
+			n.Gateway = value
+		}
+	}
+	if n.IP == "" {
+		n.Error = "could not find the node's IP"
+	}
+	return n
+}
+
+// buildProbeScript returns the script for self: start a listener on the
+// port unless one is up, then probe every other node with a background
+// job per peer, each printing
+//
+//	p <peer index> <node tcp> <node icmp> <gateway tcp> <gateway icmp>
+//
+// where a value is the latency in milliseconds, "x" for unreachable or
+// "-" for not checked.
+func (o *netcheckOptions) buildProbeScript(self *netNode, nodes []netNode) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `PORT=%d
+W=%d
+END=$(($(date +%%s) + W))
+listening() { ss -ltnH "sport = :$PORT" 2>/dev/null | grep -q .; }
+if listening; then
+  echo "listener existing"
+else
+  for ARGS in "-lk $PORT" "-lk -p $PORT"; do
+    timeout "$W" nc $ARGS </dev/null >/dev/null 2>&1 &
+    sleep 1
+    listening && break
+  done
+  if listening; then echo "listener started"; else echo "listener none"; fi
+fi
+tcp() {
+  while :; do
+    # Time the connect inside the child, leaving out its start-up.
+    T=$(timeout 2 bash -c 'S=$EPOCHREALTIME; exec 3<>"/dev/tcp/$0/$1" && echo "$S $EPOCHREALTIME"' "$1" "$PORT" 2>/dev/null)
+    if [ -n "$T" ]; then
+      echo "$T" | awk '{ printf "%%.1f\n", ($2 - $1) * 1000 }'
+      return
+    fi
+    [ "$(date +%%s)" -lt "$END" ] || { echo x; return; }
+    sleep 1
+  done
+}
+icmp() {
+  RTT=$(ping -n -c 3 -i 0.2 -W 2 "$1" 2>/dev/null | sed -n 's|.*= [0-9.]*/\([0-9.]*\)/.*|\1|p')
+  echo "${RTT:-x}"
+}
+peer() {
+  NT=-; NI=-; GT=-; GI=-
+  if [ "$2" != - ]; then NT=$(tcp "$2"); NI=$(icmp "$2"); fi
+  if [ "$3" != - ]; then GT=$(tcp "$3"); GI=$(icmp "$3"); fi
+  echo "p $1 $NT $NI $GT $GI"
+}
+`, o.port, int(o.window.Seconds()))
+	for _, peer := range nodes {
+		if peer.Index == self.Index || peer.Error != "" {
+			continue
+		}
+		ip, gw := "-", "-"
+		if o.nodeIPs {
+			ip = peer.IP
+		}
+		if o.gateways && peer.Gateway != "" {
+			gw = peer.Gateway
+		}
+		fmt.Fprintf(&b, "peer %d %s %s &\n", peer.Index, ip, gw)
+	}
+	b.WriteString("wait\n")
+	return b.String()
+}
+
+// parseProbes reads the output of buildProbeScript run on from, appending
+// one result per peer and address kind checked to results.
+func parseProbes(from *netNode, stdout string, results []pairResult) (string, []pairResult) {
+	listener := ""
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 2 && fields[0] == "listener":
+			listener = fields[1]
+		case len(fields) == 6 && fields[0] == "p":
+			to, err := strconv.Atoi(fields[1])
+			if err != nil {
+				continue
+			}
+			for _, kind := range []struct {
+				name      string
+				tcp, icmp string
+			}{{kindNode, fields[2], fields[3]}, {kindGateway, fields[4], fields[5]}} {
+				if kind.tcp == "-" && kind.icmp == "-" {
+					continue
+				}
+				r := pairResult{From: from.Index, To: to, Kind: kind.name}
+				r.TCPMs, r.TCPChecked = latency(kind.tcp)
+				r.ICMPMs, r.ICMPChecked = latency(kind.icmp)
+				results = append(results, r)
+			}
+		}
+	}
+	return listener, results
+}
+
+// latency parses a value of the probe script.
+func latency(s string) (*float64, bool) {
+	if s == "-" {
+		return nil, false
+	}
+	ms, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, true
+	}
+	return &ms, true
+}
+
+func (o *netcheckOptions) writeReport(out io.Writer, nodes []netNode, results []pairResult) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tNODE\tIP\tGATEWAY\tLISTENER")
+	for _, n := range nodes {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", n.Index, n.Node, orDash(n.IP), orDash(n.Gateway), orDash(n.Listener))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	byPair := map[string]*pairResult{}
+	for i := range results {
+		r := &results[i]
+		byPair[fmt.Sprintf("%s/%d/%d", r.Kind, r.From, r.To)] = r
+	}
+	var notes []string
+	for _, kind := range []struct {
+		name, title string
+		enabled     bool
+	}{
+		{kindNode, "Node IPs", o.nodeIPs},
+		{kindGateway, "Pod CIDR gateways", o.gateways},
+	} {
+		if !kind.enabled {
+			continue
+		}
+		fmt.Fprintf(out, "\n%s (TCP %d / ICMP, ms; x = unreachable)\n", kind.title, o.port)
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		header := []string{"FROM \\ TO"}
+		for _, n := range nodes {
+			header = append(header, strconv.Itoa(n.Index))
+		}
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		for _, from := range nodes {
+			row := []string{strconv.Itoa(from.Index)}
+			for _, to := range nodes {
+				r := byPair[fmt.Sprintf("%s/%d/%d", kind.name, from.Index, to.Index)]
+				switch {
+				case from.Index == to.Index:
+					row = append(row, "")
+				case r == nil:
+					row = append(row, "-")
+				default:
+					row = append(row, r.cell())
+				}
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		notes = append(notes, pairNotes(kind.name, nodes, byPair)...)
+	}
+
+	for _, n := range nodes {
+		switch {
+		case n.Error != "":
+			notes = append(notes, fmt.Sprintf("%d %s: %s", n.Index, n.Node, n.Error))
+		case n.Listener == "none":
+			notes = append(notes, fmt.Sprintf("%d %s: could not listen on port %d (no nc on the node?); TCP to it fails regardless of the network", n.Index, n.Node, o.port))
+		}
+		if o.gateways && n.Error == "" && n.Gateway == "" {
+			notes = append(notes, fmt.Sprintf("%d %s: no address on %s; its pod CIDR gateway is not checked", n.Index, n.Node, strings.Join(gatewayInterfaces, ", ")))
+		}
+	}
+	if len(notes) > 0 {
+		fmt.Fprintln(out)
+		for _, note := range notes {
+			fmt.Fprintln(out, note)
+		}
+	}
+	return nil
+}
+
+// cell is r as shown in the matrix.
+func (r *pairResult) cell() string {
+	part := func(ms *float64, checked bool) string {
+		switch {
+		case !checked:
+			return "-"
+		case ms == nil:
+			return "x"
+		}
+		return strconv.FormatFloat(*ms, 'f', 1, 64)
+	}
+	return part(r.TCPMs, r.TCPChecked) + "/" + part(r.ICMPMs, r.ICMPChecked)
+}
+
+// ok reports whether r found the peer reachable over every protocol checked.
+func (r *pairResult) ok() bool {
+	return (!r.TCPChecked || r.TCPMs != nil) && (!r.ICMPChecked || r.ICMPMs != nil)
+}
+
+// pairNotes lists the unreachable pairs of kind, calling out those that
+// work in the other direction.
+func pairNotes(kind string, nodes []netNode, byPair map[string]*pairResult) []string {
+	var notes []string
+	for _, from := range nodes {
+		for _, to := range nodes {
+			r := byPair[fmt.Sprintf("%s/%d/%d", kind, from.Index, to.Index)]
+			if r == nil || r.ok() {
+				continue
+			}
+			note := fmt.Sprintf("%d -> %d (%s): %s unreachable", from.Index, to.Index, kind, r.failed())
+			if back := byPair[fmt.Sprintf("%s/%d/%d", kind, to.Index, from.Index)]; back != nil && back.ok() {
+				note += "; the reverse direction works: asymmetric routing or a one-way rule"
+			}
+			notes = append(notes, note)
+		}
+	}
+	return notes
+}
+
+// failed names the protocols r could not reach its peer with.
+func (r *pairResult) failed() string {
+	var protos []string
+	if r.TCPChecked && r.TCPMs == nil {
+		protos = append(protos, "TCP")
+	}
+	if r.ICMPChecked && r.ICMPMs == nil {
+		protos = append(protos, "ICMP")
+	}
+	return strings.Join(protos, " and ")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+package netcheck
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+)
+
+func nodeResult(node, stdout string) vmss.NodeResult {
+	return vmss.NodeResult{Node: node, Result: &vmss.CommandResult{Stdout: stdout}}
+}
+
+func TestParseDiscovery(t *testing.T) {
+	n := parseDiscovery(1, nodeResult("n0", "ip 10.224.0.4\ngateway 10.244.0.1\n"))
+	if n.IP != "10.224.0.4" || n.Gateway != "10.244.0.1" || n.Error != "" {
+		t.Errorf("got %+v", n)
+	}
+	n = parseDiscovery(2, nodeResult("n1", "ip \n"))
+	if n.Error != "could not find the node's IP" {
+		t.Errorf("no IP: %+v", n)
+	}
+	n = parseDiscovery(3, vmss.NodeResult{Node: "n2", Err: errors.New("timeout")})
+	if n.Index != 3 || n.Error != "timeout" {
+		t.Errorf("failed node: %+v", n)
+	}
+}
+
+func testNodes() []netNode {
+	return []netNode{
+		{Index: 1, Node: "n0", IP: "10.224.0.4", Gateway: "10.244.0.1"},
+		{Index: 2, Node: "n1", IP: "10.224.0.5"},
+		{Index: 3, Node: "n2", Error: "timeout"},
+		{Index: 4, Node: "n3", IP: "10.224.0.6", Gateway: "10.244.2.1"},
+	}
+}
+
+func TestBuildProbeScript(t *testing.T) {
+	nodes := testNodes()
+	o := &netcheckOptions{port: 40999, window: 30 * time.Second, nodeIPs: true, gateways: true}
+	script := o.buildProbeScript(&nodes[1], nodes)
+	for _, want := range []string{
+		"PORT=40999\nW=30\n",
+		`timeout "$W" nc $ARGS`,
+		"peer 1 10.224.0.4 10.244.0.1 &\n",
+		"peer 4 10.224.0.6 10.244.2.1 &\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q", want)
+		}
+	}
+	if strings.Contains(script, "peer 2 ") || strings.Contains(script, "peer 3 ") {
+		t.Errorf("script probes itself or a failed node:\n%s", script)
+	}
+
+	o.nodeIPs = false
+	if script := o.buildProbeScript(&nodes[1], nodes); !strings.Contains(script, "peer 1 - 10.244.0.1 &\n") {
+		t.Errorf("gateways only:\n%s", script)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	nodes := testNodes()
+	var results []pairResult
+	nodes[0].Listener, results = parseProbes(&nodes[0], "listener existing\np 2 0.8 0.5 - -\np 4 0.9 0.6 x x\n", results)
+	nodes[1].Listener, results = parseProbes(&nodes[1], "listener none\np 1 x 0.4 1.1 0.3\np 4 x 0.7 x x\n", results)
+	nodes[3].Listener, results = parseProbes(&nodes[3], "listener started\np 1 1.0 0.6 1.2 0.5\np 2 x 0.7 - -\n", results)
+	if len(results) != 10 {
+		t.Fatalf("got %d results: %+v", len(results), results)
+	}
+
+	o := &netcheckOptions{port: 10250, nodeIPs: true, gateways: true}
+	var out bytes.Buffer
+	if err := o.writeReport(&out, nodes, results); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"#  NODE  IP          GATEWAY     LISTENER",
+		"2  n1    10.224.0.5  -           none",
+		"Node IPs (TCP 10250 / ICMP, ms; x = unreachable)",
+		"FROM \\ TO  1        2        3  4",
+		"1                   0.8/0.5  -  0.9/0.6",
+		"2          x/0.4             -  x/0.7",
+		"Pod CIDR gateways (TCP 10250 / ICMP, ms; x = unreachable)",
+		"1                   -  -  x/x",
+		"2 -> 1 (node): TCP unreachable; the reverse direction works: asymmetric routing or a one-way rule",
+		"1 -> 4 (gateway): TCP and ICMP unreachable; the reverse direction works: asymmetric routing or a one-way rule",
+		"2 -> 4 (gateway): TCP and ICMP unreachable\n",
+		"3 n2: timeout",
+		"2 n1: could not listen on port 10250 (no nc on the node?)",
+		"2 n1: no address on cilium_host, cbr0, cni0; its pod CIDR gateway is not checked",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("report missing %q:\n%s", want, got)
+		}
+	}
+}
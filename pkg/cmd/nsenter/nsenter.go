@@ -0,0 +1,161 @@
+package nsenter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+type nsenterOptions struct {
+	namespace string
+	container string
+	node      string
+	net       bool
+	pid       bool
+	mount     bool
+	uts       bool
+
+	pod     string
+	command []string
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdNsenter returns a cobra command for "kubectl vmss nsenter".
+func NewCmdNsenter(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &nsenterOptions{
+		namespace: "kube-system",
+		streams:   streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "nsenter <pod> [--net] [--pid] [--mount] [--uts] -- <command> [args...]",
+		Short: "Run a host command in a pod's namespaces",
+		Long: `Run a command on the pod's node inside a chosen set of the pod's Linux
+namespaces, via VMSS run-command and nsenter. Without namespace flags the
+pod's network namespace is used.
+
+The network and UTS namespaces are taken from the pod sandbox, which stays
+up while containers crash-loop, and the command binary comes from the node.
+That makes host tools such as ip, ss, curl, dig and conntrack usable
+against distroless or crashing pods.
+
+--pid and --mount enter the namespaces of a running container (-c, default
+the pod's first container). With --mount the command and its libraries are
+resolved inside the container's filesystem, not the node's; tools that
+read /proc, such as ps, need --mount alongside --pid.`,
+		Example: `  # Sockets and routes as the pod sees them
+  kubectl vmss nsenter coredns-abc -- ss -tunap
+  kubectl vmss nsenter coredns-abc -- ip route
+
+  # DNS resolution from inside a distroless pod's network namespace
+  kubectl vmss nsenter my-app -n default -- dig +short kubernetes.default.svc.cluster.local @10.0.0.10
+
+  # A container's filesystem, volumes included (ls must exist in the image)
+  kubectl vmss nsenter my-app -n default -c app --mount -- ls -l /etc`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.ArgsLenAtDash() != 1 {
+				return fmt.Errorf("usage: kubectl vmss nsenter <pod> [flags] -- <command> [args...]")
+			}
+			o.pod = args[0]
+			o.command = args[1:]
+			if !o.net && !o.pid && !o.mount && !o.uts {
+				o.net = true
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", o.namespace, "Namespace for pod lookup")
+	cmd.Flags().StringVarP(&o.container, "container", "c", "", "Container for --pid and --mount (default: first container)")
+	cmd.Flags().StringVar(&o.node, "node", "", "Target a node directly instead of resolving it from the pod")
+	cmd.Flags().BoolVar(&o.net, "net", false, "Enter the pod's network namespace (default when no namespace is chosen)")
+	cmd.Flags().BoolVar(&o.pid, "pid", false, "Enter the container's PID namespace")
+	cmd.Flags().BoolVar(&o.mount, "mount", false, "Enter the container's mount namespace")
+	cmd.Flags().BoolVar(&o.uts, "uts", false, "Enter the pod's UTS namespace")
+
+	return cmd
+}
+
+// Run executes the nsenter command.
+func (o *nsenterOptions) Run(ctx context.Context) error {
+	node := o.node
+	if node == "" {
+		var err error
+		node, err = o.runner.ResolveNodeFromPod(ctx, o.namespace, o.pod)
+		if err != nil {
+			return err
+		}
+	}
+	info, err := o.runner.ResolveVMSS(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	container := o.container
+	if (o.pid || o.mount) && container == "" {
+		container, err = o.runner.GetContainerName(ctx, o.namespace, o.pod)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(o.streams.ErrOut, "Running in pod %s/%s namespaces on %s/%s...\n", o.namespace, o.pod, info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, o.buildNsenterScript(container))
+	if err != nil {
+		return err
+	}
+
+	if result.Stdout != "" {
+		fmt.Fprintln(o.streams.Out, result.Stdout)
+	}
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+	return nil
+}
+
+// buildNsenterScript returns the script that locates the pod's sandbox
+// (and, for --pid or --mount, its container) and runs the command through
+// nsenter with one --<ns>=/proc/<pid>/ns/<ns> option per chosen namespace.
+func (o *nsenterOptions) buildNsenterScript(container string) string {
+	var b strings.Builder
+	var opts []string
+	if o.net || o.uts {
+		b.WriteString(nodescript.SandboxPID(o.namespace, o.pod) + "\n")
+		if o.net {
+			opts = append(opts, `--net="/proc/$SANDBOX_PID/ns/net"`)
+		}
+		if o.uts {
+			opts = append(opts, `--uts="/proc/$SANDBOX_PID/ns/uts"`)
+		}
+	}
+	if o.pid || o.mount {
+		b.WriteString(nodescript.ContainerPID(o.namespace, o.pod, container) + "\n")
+		if o.pid {
+			opts = append(opts, `--pid="/proc/$PID/ns/pid"`)
+		}
+		if o.mount {
+			opts = append(opts, `--mount="/proc/$PID/ns/mnt"`)
+		}
+	}
+
+	quoted := make([]string, len(o.command))
+	for i, a := range o.command {
+		quoted[i] = vmss.ShellQuote(a)
+	}
+	fmt.Fprintf(&b, `nsenter %s -- %s
+RC=$?
+[ "$RC" -eq 0 ] || echo "exit status $RC" >&2`, strings.Join(opts, " "), strings.Join(quoted, " "))
+	return b.String()
+}
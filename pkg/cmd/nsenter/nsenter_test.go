@@ -0,0 +1,49 @@
+package nsenter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildNsenterScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		o       nsenterOptions
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "net only uses the sandbox",
+			o:       nsenterOptions{namespace: "default", pod: "my-app", net: true, command: []string{"ss", "-tunap"}},
+			want:    []string{"crictl inspectp", `nsenter --net="/proc/$SANDBOX_PID/ns/net" -- 'ss' '-tunap'`},
+			notWant: []string{"crictl inspect \"$CID\"", "--mount", "--pid"},
+		},
+		{
+			name:    "mount and pid use the container",
+			o:       nsenterOptions{namespace: "default", pod: "my-app", pid: true, mount: true, command: []string{"sh", "-c", "ps; echo 'done'"}},
+			want:    []string{"--name '^app$'", `--pid="/proc/$PID/ns/pid" --mount="/proc/$PID/ns/mnt"`, `'sh' '-c' 'ps; echo '\''done'\'''`},
+			notWant: []string{"SANDBOX_PID", "--net"},
+		},
+		{
+			name: "all namespaces",
+			o:    nsenterOptions{namespace: "default", pod: "my-app", net: true, uts: true, pid: true, mount: true, command: []string{"hostname"}},
+			want: []string{`--net="/proc/$SANDBOX_PID/ns/net" --uts="/proc/$SANDBOX_PID/ns/uts" --pid="/proc/$PID/ns/pid" --mount="/proc/$PID/ns/mnt"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := tt.o.buildNsenterScript("app")
+			for _, w := range tt.want {
+				if !strings.Contains(script, w) {
+					t.Errorf("script missing %q:\n%s", w, script)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(script, w) {
+					t.Errorf("script should not contain %q:\n%s", w, script)
+				}
+			}
+		})
+	}
+}
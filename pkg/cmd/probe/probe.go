@@ -0,0 +1,437 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// Protocols that probe can test the last hop with.
+var protocols = []string{"tcp", "udp", "icmp", "dns", "http"}
+
+// hostnameRe matches the DNS names --to accepts besides IP addresses.
+var hostnameRe = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9.]*[A-Za-z0-9])?\.?$`)
+
+// Status is the outcome of one probe step.
+type Status string
+
+const (
+	Pass Status = "PASS"
+	Warn Status = "WARN"
+	Fail Status = "FAIL"
+	Skip Status = "SKIP"
+)
+
+type probeOptions struct {
+	namespace string
+	node      string
+	to        string
+	proto     string
+	timeout   time.Duration
+	query     string
+	path      string
+	output    string
+
+	pod  string
+	host string
+	port int
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdProbe returns a cobra command for "kubectl vmss probe".
+func NewCmdProbe(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &probeOptions{
+		namespace: "kube-system",
+		proto:     "tcp",
+		timeout:   5 * time.Second,
+		query:     "kubernetes.default.svc.cluster.local",
+		path:      "/",
+		streams:   streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "probe <pod> --to <ip|host>[:<port>] [--proto tcp|udp|icmp|dns|http]",
+		Short: "Probe connectivity from a pod's network namespace, hop by hop",
+		Long: `Enter the pod's network namespace on its node and check, step by step,
+what a connection to --to goes through:
+
+  resolve   a host name, with the pod's nameserver and search domains
+            (needs dig on the node, else the node's resolver is used)
+  route     ip route get: the interface, gateway and source address
+  neighbor  the ARP / NDP entry of the next hop, refreshed with a ping
+  <proto>   the connection itself: a TCP connect, a UDP datagram (refused
+            only when an ICMP port unreachable comes back), ICMP echo, a
+            DNS query for --query to the target, or an HTTP GET of --path
+
+The namespace is taken from the pod sandbox and only node tools are used,
+so this works for distroless and crash-looping pods. Probing stops at the
+first step that leaves nothing to test after it.`,
+		Example: `  # Can the pod reach a service?
+  kubectl vmss probe my-app -n default --to my-svc.other-ns:8080
+
+  # Can it reach CoreDNS and get an answer?
+  kubectl vmss probe my-app -n default --to 10.0.0.10 --proto dns
+
+  # HTTP from a crash-looping pod, as JSON
+  kubectl vmss probe my-app -n default --to 10.224.0.7:80 --proto http --path /healthz -o json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.pod = args[0]
+			if err := o.parseTarget(); err != nil {
+				return err
+			}
+			if o.timeout < time.Second {
+				return fmt.Errorf("--timeout must be at least 1s")
+			}
+			if o.proto == "http" && !strings.HasPrefix(o.path, "/") {
+				return fmt.Errorf("--path must start with /")
+			}
+			if o.output != "" && o.output != "json" {
+				return fmt.Errorf("unsupported output format %q (supported: json)", o.output)
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", o.namespace, "Namespace for pod lookup")
+	cmd.Flags().StringVar(&o.node, "node", "", "Target a node directly instead of resolving it from the pod")
+	cmd.Flags().StringVar(&o.to, "to", "", "Destination as <ip|host>[:<port>]")
+	cmd.Flags().StringVar(&o.proto, "proto", o.proto, "Protocol of the last step: "+strings.Join(protocols, ", "))
+	cmd.Flags().DurationVar(&o.timeout, "timeout", o.timeout, "Timeout of each step")
+	cmd.Flags().StringVar(&o.query, "query", o.query, "Name to look up with --proto dns")
+	cmd.Flags().StringVar(&o.path, "path", o.path, "Path to GET with --proto http")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format: json")
+	_ = cmd.MarkFlagRequired("to")
+
+	return cmd
+}
+
+// parseTarget validates --proto and splits --to into host and port. The
+// port is required except for icmp, which has none, and dns, which
+// defaults to 53.
+func (o *probeOptions) parseTarget() error {
+	valid := false
+	for _, p := range protocols {
+		valid = valid || p == o.proto
+	}
+	if !valid {
+		return fmt.Errorf("unsupported --proto %q (supported: %s)", o.proto, strings.Join(protocols, ", "))
+	}
+
+	host, port, err := net.SplitHostPort(o.to)
+	if err != nil {
+		// No port: a bare name or address, IPv6 included.
+		host, port = strings.TrimSuffix(strings.TrimPrefix(o.to, "["), "]"), ""
+	}
+	if _, err := netip.ParseAddr(host); err != nil && !hostnameRe.MatchString(host) {
+		return fmt.Errorf("invalid --to %q: want <ip|host>[:<port>]", o.to)
+	}
+	o.host = host
+
+	switch {
+	case port != "":
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q in --to", port)
+		}
+		if o.proto == "icmp" {
+			return fmt.Errorf("--proto icmp takes no port")
+		}
+		o.port = n
+	case o.proto == "dns":
+		o.port = 53
+	case o.proto != "icmp":
+		return fmt.Errorf("--to needs a port for --proto %s", o.proto)
+	}
+	return nil
+}
+
+// Run executes the probe command.
+func (o *probeOptions) Run(ctx context.Context) error {
+	node := o.node
+	if node == "" {
+		var err error
+		node, err = o.runner.ResolveNodeFromPod(ctx, o.namespace, o.pod)
+		if err != nil {
+			return err
+		}
+	}
+	info, err := o.runner.ResolveVMSS(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.streams.ErrOut, "Probing %s (%s) from pod %s/%s on %s/%s...\n", o.to, o.proto, o.namespace, o.pod, info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, o.buildProbeScript())
+	if err != nil {
+		return err
+	}
+	steps := parseSteps(result.Stdout)
+	if len(steps) == 0 {
+		if result.Stderr != "" {
+			fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+		}
+		return fmt.Errorf("probe of pod %s/%s returned no results", o.namespace, o.pod)
+	}
+
+	if o.output == "json" {
+		report := probeReport{
+			Namespace: o.namespace,
+			Pod:       o.pod,
+			Node:      node,
+			Target:    o.to,
+			Proto:     o.proto,
+			Steps:     steps,
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.streams.Out, string(data))
+		return nil
+	}
+	return writeSteps(o.streams.Out, steps)
+}
+
+// probeReport is the JSON output of probe.
+type probeReport struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Node      string `json:"node"`
+	Target    string `json:"target"`
+	Proto     string `json:"proto"`
+	Steps     []step `json:"steps"`
+}
+
+// step is the result of one hop of the probe.
+type step struct {
+	Name      string   `json:"name"`
+	Status    Status   `json:"status"`
+	LatencyMs *float64 `json:"latencyMs,omitempty"`
+	Detail    string   `json:"detail"`
+}
+
+// buildProbeScript returns the script that runs the steps in the pod's
+// network namespace, printing one "step <name> <ok|warn|fail|skip>
+// <milliseconds|-> <detail>" line per step.
+func (o *probeOptions) buildProbeScript() string {
+	t := int(o.timeout.Seconds())
+	var b strings.Builder
+	b.WriteString(nodescript.SandboxPID(o.namespace, o.pod) + "\n")
+	fmt.Fprintf(&b, `N() { nsenter -t "$SANDBOX_PID" -n "$@"; }
+ms() { awk -v s="$1" -v e="$(date +%%s%%N)" 'BEGIN { printf "%%.1f", (e - s) / 1e6 }'; }
+step() { echo "step $*" | head -1 | cut -c1-300; }
+HOST=%s
+PORT=%d
+T=%d
+`, vmss.ShellQuote(o.host), o.port, t)
+
+	if _, err := netip.ParseAddr(o.host); err == nil {
+		b.WriteString("IP=$HOST\n")
+	} else {
+		b.WriteString(resolveStep)
+	}
+	b.WriteString(routeStep)
+
+	switch o.proto {
+	case "tcp":
+		b.WriteString(`S=$(date +%s%N)
+ERR=$(N timeout "$T" bash -c 'exec 3<>"/dev/tcp/$0/$1"' "$IP" "$PORT" 2>&1)
+case $? in
+0) step tcp ok "$(ms "$S")" "connected to $IP port $PORT" ;;
+124) step tcp fail "$(ms "$S")" "no answer to SYN: dropped or filtered" ;;
+*) step tcp fail "$(ms "$S")" "$(printf '%s\n' "$ERR" | head -1 | sed 's/.*: //')" ;;
+esac
+`)
+	case "udp":
+		fmt.Fprintf(&b, `S=$(date +%%s%%N)
+ERR=$(N timeout "$T" bash -c 'exec 3<>"/dev/udp/$0/$1"; printf probe >&3; read -r -t %d -u 3 REPLY' "$IP" "$PORT" 2>&1)
+RC=$?
+if [ "$RC" -eq 0 ]; then
+  step udp ok "$(ms "$S")" "reply from $IP port $PORT"
+elif printf '%%s' "$ERR" | grep -q 'Connection refused'; then
+  step udp fail "$(ms "$S")" "ICMP port unreachable: nothing listens on $IP port $PORT"
+else
+  step udp warn - "no reply and no ICMP error: open but silent, or filtered"
+fi
+`, max(t-1, 1))
+	case "icmp":
+		b.WriteString(`OUT=$(N ping -n -c 3 -i 0.2 -W "$T" "$IP" 2>&1)
+LOSS=$(printf '%s\n' "$OUT" | sed -n 's/.* \([0-9.]*\)% packet loss.*/\1/p')
+RTT=$(printf '%s\n' "$OUT" | sed -n 's|.*= [0-9.]*/\([0-9.]*\)/.*|\1|p')
+case "$LOSS" in
+0) step icmp ok "$RTT" "3 echo replies from $IP" ;;
+100) step icmp fail - "no echo reply from $IP" ;;
+"") step icmp fail - "$(printf '%s\n' "$OUT" | head -1)" ;;
+*) step icmp warn "${RTT:--}" "$LOSS% packet loss to $IP" ;;
+esac
+`)
+	case "dns":
+		fmt.Fprintf(&b, `if command -v dig >/dev/null 2>&1; then
+  OUT=$(N dig +time="$T" +tries=1 -p "$PORT" @"$IP" %s A 2>&1)
+  RCODE=$(printf '%%s\n' "$OUT" | sed -n 's/.*status: \([A-Z]*\).*/\1/p' | head -1)
+  QT=$(printf '%%s\n' "$OUT" | sed -n 's/^;; Query time: \([0-9]*\) msec.*/\1/p')
+  ANS=$(printf '%%s\n' "$OUT" | awk '/^;; ANSWER SECTION/ { a = 1; next } a && NF == 0 { a = 0 } a { print $NF }' | paste -sd, -)
+  case "$RCODE" in
+  NOERROR) step dns ok "${QT:--}" "NOERROR ${ANS:-(no records)}" ;;
+  NXDOMAIN) step dns warn "${QT:--}" "NXDOMAIN: the server answered, but does not know the name" ;;
+  "") step dns fail - "no response from $IP port $PORT" ;;
+  *) step dns fail "${QT:--}" "$RCODE" ;;
+  esac
+else
+  step dns skip - "dig is not installed on the node"
+fi
+`, vmss.ShellQuote(o.query))
+	case "http":
+		scheme := "http"
+		if o.port == 443 {
+			scheme = "https"
+		}
+		urlHost := o.host
+		if strings.Contains(urlHost, ":") {
+			urlHost = "[" + urlHost + "]"
+		}
+		resolve := ""
+		if _, err := netip.ParseAddr(o.host); err != nil {
+			resolve = fmt.Sprintf(`--resolve %s:"$PORT":"$IP" `, vmss.ShellQuote(strings.TrimSuffix(o.host, ".")))
+		}
+		fmt.Fprintf(&b, `OUT=$(N curl -s -k -o /dev/null -m "$T" --noproxy '*' %s-w '%%{http_code} %%{time_connect} %%{time_total}' %s)
+RC=$?
+set -- $OUT
+case "$RC" in
+0) ;;
+7) step http fail - "connection refused" ;;
+28) step http fail - "timed out" ;;
+*) step http fail - "curl exit code $RC" ;;
+esac
+if [ "$RC" -eq 0 ]; then
+  TOTAL=$(awk -v t="$3" 'BEGIN { printf "%%.1f", t * 1000 }')
+  CONNECT=$(awk -v t="$2" 'BEGIN { printf "%%.1f", t * 1000 }')
+  case "$1" in
+  [23]*) step http ok "$TOTAL" "HTTP $1, connected in ${CONNECT}ms" ;;
+  *) step http warn "$TOTAL" "HTTP $1, connected in ${CONNECT}ms" ;;
+  esac
+fi
+`, resolve, vmss.ShellQuote(fmt.Sprintf("%s://%s:%d%s", scheme, strings.TrimSuffix(urlHost, "."), o.port, o.path)))
+	}
+	return b.String()
+}
+
+// resolveStep sets $IP from $HOST the way the pod would resolve it: with
+// the nameserver and search domains of the sandbox's resolv.conf, which
+// the node's resolver knows nothing about.
+const resolveStep = `RESOLV=/var/lib/containerd/io.containerd.grpc.v1.cri/sandboxes/$SANDBOX_ID/resolv.conf
+[ -f "$RESOLV" ] || RESOLV=/etc/resolv.conf
+NS=$(awk '/^nameserver/ { print $2; exit }' "$RESOLV")
+case "$HOST" in
+*.) NAMES=$HOST ;;
+*) NAMES="$(awk -v h="$HOST" '/^search/ { for (i = 2; i <= NF; i++) printf "%s.%s ", h, $i }' "$RESOLV")$HOST" ;;
+esac
+IP=""
+S=$(date +%s%N)
+if command -v dig >/dev/null 2>&1; then
+  for NAME in $NAMES; do
+    IP=$(N dig +short +time="$T" +tries=1 @"$NS" "$NAME" A 2>/dev/null | grep -E '^[0-9.]+$' | head -1)
+    [ -z "$IP" ] || break
+  done
+  if [ -z "$IP" ]; then
+    step resolve fail "$(ms "$S")" "$HOST: no A record from $NS"
+    exit 0
+  fi
+  step resolve ok "$(ms "$S")" "$NAME is $IP (nameserver $NS)"
+else
+  IP=$(timeout "$T" getent ahostsv4 "$HOST" 2>/dev/null | awk 'NR == 1 { print $1 }')
+  if [ -z "$IP" ]; then
+    step resolve fail "$(ms "$S")" "$HOST: not found by the node's resolver (dig is not installed)"
+    exit 0
+  fi
+  step resolve warn "$(ms "$S")" "$HOST is $IP by the node's resolver; dig is not installed to ask $NS"
+fi
+`
+
+// routeStep looks up the route to $IP and the neighbor entry of its next
+// hop. An incomplete neighbor entry is refreshed with one ping first.
+const routeStep = `ROUTE=$(N ip route get "$IP" 2>&1 | head -1)
+DEV=$(printf '%s\n' "$ROUTE" | awk '{ for (i = 1; i < NF; i++) if ($i == "dev") print $(i + 1) }')
+VIA=$(printf '%s\n' "$ROUTE" | awk '{ for (i = 1; i < NF; i++) if ($i == "via") print $(i + 1) }')
+if [ -z "$DEV" ]; then
+  step route fail - "${ROUTE:-no route to $IP}"
+  exit 0
+fi
+step route ok - "$(printf '%s\n' "$ROUTE" | sed 's/ uid [0-9]*//; s/ *$//')"
+HOP=${VIA:-$IP}
+if [ "$DEV" = lo ]; then
+  step neighbor skip - "$IP is local to the pod"
+else
+  neigh() { N ip neigh show "$HOP" dev "$DEV" 2>/dev/null | head -1 | sed 's/ *$//'; }
+  NEIGH=$(neigh)
+  case "$NEIGH" in
+  *REACHABLE|*PERMANENT|*NOARP) ;;
+  *) N ping -n -c 1 -W 1 "$HOP" >/dev/null 2>&1; NEIGH=$(neigh) ;;
+  esac
+  case "$NEIGH" in
+  *REACHABLE|*STALE|*DELAY|*PROBE|*PERMANENT|*NOARP) step neighbor ok - "$NEIGH" ;;
+  "") step neighbor warn - "no entry for $HOP on $DEV (point-to-point or proxied)" ;;
+  *) step neighbor fail - "$NEIGH: $HOP does not answer ARP / NDP" ;;
+  esac
+fi
+`
+
+// parseSteps reads the step lines of the probe script.
+func parseSteps(stdout string) []step {
+	statuses := map[string]Status{"ok": Pass, "warn": Warn, "fail": Fail, "skip": Skip}
+	var steps []step
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 5)
+		if len(fields) < 4 || fields[0] != "step" {
+			continue
+		}
+		s := step{Name: fields[1], Status: statuses[fields[2]]}
+		if ms, err := strconv.ParseFloat(fields[3], 64); err == nil {
+			s.LatencyMs = &ms
+		}
+		if len(fields) == 5 {
+			s.Detail = fields[4]
+		}
+		steps = append(steps, s)
+	}
+	return steps
+}
+
+func writeSteps(out io.Writer, steps []step) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tSTATUS\tTIME\tDETAIL")
+	for _, s := range steps {
+		latency := "-"
+		if s.LatencyMs != nil {
+			latency = formatLatency(*s.LatencyMs)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Name, s.Status, latency, s.Detail)
+	}
+	return w.Flush()
+}
+
+func formatLatency(ms float64) string {
+	if ms < 10 {
+		return fmt.Sprintf("%.1fms", ms)
+	}
+	return fmt.Sprintf("%.0fms", ms)
+}
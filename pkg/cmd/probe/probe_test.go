@@ -0,0 +1,133 @@
+package probe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		to, proto string
+		host      string
+		port      int
+		err       string
+	}{
+		{to: "10.0.0.5:80", proto: "tcp", host: "10.0.0.5", port: 80},
+		{to: "my-svc.default:8080", proto: "http", host: "my-svc.default", port: 8080},
+		{to: "[fd00::5]:443", proto: "tcp", host: "fd00::5", port: 443},
+		{to: "fd00::5", proto: "icmp", host: "fd00::5"},
+		{to: "10.0.0.10", proto: "dns", host: "10.0.0.10", port: 53},
+		{to: "10.0.0.5", proto: "tcp", err: "needs a port"},
+		{to: "10.0.0.5:22", proto: "icmp", err: "takes no port"},
+		{to: "10.0.0.5:99999", proto: "udp", err: "invalid port"},
+		{to: "$(reboot):80", proto: "tcp", err: "invalid --to"},
+		{to: "10.0.0.5:80", proto: "sctp", err: "unsupported --proto"},
+	}
+	for _, tt := range tests {
+		o := &probeOptions{to: tt.to, proto: tt.proto}
+		err := o.parseTarget()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s %s: err = %v, want %q", tt.proto, tt.to, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || o.host != tt.host || o.port != tt.port {
+			t.Errorf("%s %s: got %q %d %v, want %q %d", tt.proto, tt.to, o.host, o.port, err, tt.host, tt.port)
+		}
+	}
+}
+
+func TestBuildProbeScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		o       probeOptions
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "tcp to an address skips resolution",
+			o:       probeOptions{to: "10.0.0.5:80", proto: "tcp"},
+			want:    []string{"crictl pods --namespace '^default$' --name '^web-0$'", "IP=$HOST\n", `ip route get "$IP"`, `bash -c 'exec 3<>"/dev/tcp/$0/$1"' "$IP" "$PORT"`},
+			notWant: []string{"resolv.conf"},
+		},
+		{
+			name: "http to a name resolves like the pod",
+			o:    probeOptions{to: "my-svc:8080", proto: "http", path: "/healthz"},
+			want: []string{"sandboxes/$SANDBOX_ID/resolv.conf", `dig +short +time="$T"`, `--resolve 'my-svc':"$PORT":"$IP"`, "'http://my-svc:8080/healthz'"},
+		},
+		{
+			name: "https on 443",
+			o:    probeOptions{to: "[fd00::5]:443", proto: "http", path: "/"},
+			want: []string{"'https://[fd00::5]:443/'"},
+		},
+		{
+			name: "dns query",
+			o:    probeOptions{to: "10.0.0.10", proto: "dns", query: "kubernetes.default.svc.cluster.local"},
+			want: []string{"PORT=53\n", `@"$IP" 'kubernetes.default.svc.cluster.local' A`},
+		},
+		{
+			name: "udp",
+			o:    probeOptions{to: "10.0.0.10:53", proto: "udp"},
+			want: []string{`read -r -t 4 -u 3`, "Connection refused"},
+		},
+		{
+			name: "icmp",
+			o:    probeOptions{to: "10.0.0.5", proto: "icmp"},
+			want: []string{`ping -n -c 3 -i 0.2 -W "$T" "$IP"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.o
+			o.namespace, o.pod, o.timeout = "default", "web-0", 5*time.Second
+			if err := o.parseTarget(); err != nil {
+				t.Fatal(err)
+			}
+			script := o.buildProbeScript()
+			for _, w := range tt.want {
+				if !strings.Contains(script, w) {
+					t.Errorf("script missing %q:\n%s", w, script)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(script, w) {
+					t.Errorf("script should not contain %q", w)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSteps(t *testing.T) {
+	steps := parseSteps(`step resolve ok 5.1 my-svc.default.svc.cluster.local is 10.0.0.5 (nameserver 10.0.0.10)
+step route ok - 10.0.0.5 via 169.254.1.1 dev eth0 src 10.224.0.9
+step neighbor ok - 169.254.1.1 lladdr 12:34:56:78:9a:bc PERMANENT
+step tcp fail 5001.2 no answer to SYN: dropped or filtered`)
+	if len(steps) != 4 {
+		t.Fatalf("got %d steps", len(steps))
+	}
+	if s := steps[0]; s.Name != "resolve" || s.Status != Pass || *s.LatencyMs != 5.1 || s.Detail != "my-svc.default.svc.cluster.local is 10.0.0.5 (nameserver 10.0.0.10)" {
+		t.Errorf("steps[0] = %+v", s)
+	}
+	if s := steps[1]; s.LatencyMs != nil || s.Detail != "10.0.0.5 via 169.254.1.1 dev eth0 src 10.224.0.9" {
+		t.Errorf("steps[1] = %+v", s)
+	}
+
+	var out bytes.Buffer
+	if err := writeSteps(&out, steps); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"STEP      STATUS  TIME    DETAIL",
+		"resolve   PASS    5.1ms   my-svc",
+		"neighbor  PASS    -       169.254.1.1",
+		"tcp       FAIL    5001ms  no answer to SYN",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("table missing %q:\n%s", want, out.String())
+		}
+	}
+}
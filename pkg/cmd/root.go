@@ -4,11 +4,24 @@ import (
 	"fmt"
 
 	"github.com/matmerr/kubectl-vmss/pkg/cmd/acn"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/audit"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/capture"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/check"
 	"github.com/matmerr/kubectl-vmss/pkg/cmd/cilium"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/collect"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/cp"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/describe"
 	"github.com/matmerr/kubectl-vmss/pkg/cmd/exec"
 	"github.com/matmerr/kubectl-vmss/pkg/cmd/get"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/hubble"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/imageexec"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/journal"
 	"github.com/matmerr/kubectl-vmss/pkg/cmd/logs"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/netcheck"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/nsenter"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/probe"
 	"github.com/matmerr/kubectl-vmss/pkg/cmd/run"
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/timeline"
 	"github.com/matmerr/kubectl-vmss/pkg/version"
 	"github.com/spf13/cobra"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
@@ -27,8 +40,21 @@ func NewCmdVMSS(streams genericclioptions.IOStreams) *cobra.Command {
 	cmd.AddCommand(exec.NewCmdExec(streams))
 	cmd.AddCommand(run.NewCmdRun(streams))
 	cmd.AddCommand(get.NewCmdGet(streams))
+	cmd.AddCommand(describe.NewCmdDescribe(streams))
+	cmd.AddCommand(collect.NewCmdCollect(streams))
+	cmd.AddCommand(cp.NewCmdCp(streams))
+	cmd.AddCommand(capture.NewCmdCapture(streams))
+	cmd.AddCommand(nsenter.NewCmdNsenter(streams))
+	cmd.AddCommand(imageexec.NewCmdImageExec(streams))
+	cmd.AddCommand(journal.NewCmdJournal(streams))
+	cmd.AddCommand(timeline.NewCmdTimeline(streams))
 	cmd.AddCommand(acn.NewCmdACN(streams))
 	cmd.AddCommand(cilium.NewCmdCilium(streams))
+	cmd.AddCommand(hubble.NewCmdHubble(streams))
+	cmd.AddCommand(audit.NewCmdAudit(streams))
+	cmd.AddCommand(check.NewCmdCheck(streams))
+	cmd.AddCommand(probe.NewCmdProbe(streams))
+	cmd.AddCommand(netcheck.NewCmdNetcheck(streams))
 	cmd.AddCommand(newCmdVersion(streams))
 
 	return cmd
@@ -31,10 +31,10 @@ func NewCmdRun(streams genericclioptions.IOStreams) *cobra.Command {
 		Short: "Run an arbitrary command on a node",
 		Long:  "Run an arbitrary shell command on an AKS node via VMSS run-command.",
 		Example: `  # Run a command on a specific node
-  kubectl vmss run aks-nodepool1-vmss000000 "journalctl -u kubelet -n 50"
+  kubectl vmss run aks-nodepool1-vmss000000 "systemctl status kubelet"
 
   # Run a command on a pod's node
-  kubectl vmss run --pod cilium-6jnvz "journalctl -u kubelet -n 20"`,
+  kubectl vmss run --pod cilium-6jnvz "ip route"`,
 		Args: cobra.RangeArgs(1, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if o.pod != "" {
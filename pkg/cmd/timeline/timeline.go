@@ -0,0 +1,244 @@
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/matmerr/kubectl-vmss/pkg/cmd/acn"
+	"github.com/matmerr/kubectl-vmss/pkg/nodelog"
+	"github.com/matmerr/kubectl-vmss/pkg/nodescript"
+	"github.com/matmerr/kubectl-vmss/pkg/transfer"
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+type timelineOptions struct {
+	namespace string
+	pod       string
+	units     []string
+	tail      int
+	chunkSize int
+
+	runner  vmss.Runner
+	streams genericclioptions.IOStreams
+}
+
+// NewCmdTimeline returns a cobra command for "kubectl vmss timeline".
+func NewCmdTimeline(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &timelineOptions{
+		namespace: "kube-system",
+		units:     []string{"kubelet", "containerd"},
+		chunkSize: transfer.DefaultChunkSize,
+		streams:   streams,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "timeline <pod>",
+		Short: "Show kubelet, containerd and CNI log lines for one pod as a timeline",
+		Long: `Collect every kubelet and containerd journal entry and Azure CNI / CNS log
+line that mentions a pod, and print them as one merged timeline.
+
+The pod is matched by namespace/name, UID, and the IDs of all of its
+sandboxes and containers found on the node. Matching happens on the node, so
+only relevant lines are returned. Useful when a pod is stuck in
+ContainerCreating. The matching lines are staged on the node and downloaded
+in chunks of a few KB; narrow with --tail for long-lived pods.`,
+		Example: `  # Why is this pod stuck in ContainerCreating?
+  kubectl vmss timeline coredns-abc -n kube-system
+
+  # Only the last 100 matching lines per source
+  kubectl vmss timeline coredns-abc --tail 100`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.pod = args[0]
+			if o.chunkSize < 0 {
+				return fmt.Errorf("--chunk-size must be positive")
+			}
+			if o.chunkSize == 0 {
+				o.chunkSize = transfer.DefaultChunkSize
+			}
+			if o.runner == nil {
+				o.runner = vmss.NewDefaultRunner()
+			}
+			return o.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", o.namespace, "Namespace for pod lookup")
+	cmd.Flags().StringArrayVarP(&o.units, "unit", "u", o.units, "Systemd units to search (repeatable)")
+	cmd.Flags().IntVar(&o.tail, "tail", 0, "Number of matching lines to keep per source (0 = all)")
+	cmd.Flags().IntVar(&o.chunkSize, "chunk-size", o.chunkSize, "Raw bytes transferred per run-command call")
+
+	return cmd
+}
+
+// Run executes the timeline command.
+func (o *timelineOptions) Run(ctx context.Context) error {
+	node, err := o.runner.ResolveNodeFromPod(ctx, o.namespace, o.pod)
+	if err != nil {
+		return err
+	}
+	uid, err := o.runner.GetPodUID(ctx, o.namespace, o.pod)
+	if err != nil {
+		return err
+	}
+
+	info, err := o.runner.ResolveVMSS(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	script := buildTimelineScript(o.namespace, o.pod, uid, o.units, o.tail)
+
+	fmt.Fprintf(o.streams.ErrOut, "Running on %s/%s...\n", info.VMSSName, info.InstanceID)
+	result, err := o.runner.RunCommand(ctx, info, script)
+	if err != nil {
+		return err
+	}
+	if result.Stderr != "" {
+		fmt.Fprintln(o.streams.ErrOut, result.Stderr)
+	}
+
+	d := &transfer.Downloader{Runner: o.runner, Info: info, ChunkSize: o.chunkSize, Progress: o.streams.ErrOut}
+	data, err := d.FetchStaged(ctx, result.Stdout)
+	if err != nil {
+		return err
+	}
+
+	ids, events, skipped := parseTimelineOutput(string(data))
+	fmt.Fprintf(o.streams.ErrOut, "Pod %s/%s uid=%s\n", o.namespace, o.pod, uid)
+	if len(ids) > 0 {
+		fmt.Fprintf(o.streams.ErrOut, "Matched sandbox/container IDs: %s\n", strings.Join(ids, " "))
+	} else {
+		fmt.Fprintln(o.streams.ErrOut, "No sandboxes or containers found on the node; matching by name and UID only")
+	}
+	if skipped > 0 {
+		fmt.Fprintf(o.streams.ErrOut, "Warning: skipped %d unparsable journal line(s); output may have been truncated, narrow with --tail\n", skipped)
+	}
+
+	for i := range events {
+		fmt.Fprintln(o.streams.Out, events[i].String())
+	}
+	return nil
+}
+
+// Section markers separating the parts of the timeline script's output.
+const (
+	idsMarker     = "### ids"
+	journalMarker = "### journal"
+	fileMarker    = "### file "
+)
+
+// podPatterns returns the extended regexes that identify a pod in kubelet,
+// containerd and CNI logs before its sandbox and container IDs are known.
+// Names are bounded so "coredns-abc" does not match "coredns-abcd".
+func podPatterns(namespace, pod, uid string) []string {
+	name := regexp.QuoteMeta(pod)
+	ns := regexp.QuoteMeta(namespace)
+	end := `([^a-z0-9.-]|$)`
+	pats := []string{
+		ns + "/" + name + end,
+		"K8S_POD_NAME=" + name + end,
+		`[Pp]od[Nn]ame[":= ]+` + name + end,
+	}
+	if uid != "" {
+		pats = append(pats, regexp.QuoteMeta(uid))
+	}
+	return pats
+}
+
+// buildTimelineScript returns one script that finds the pod's sandboxes and
+// containers with crictl, then greps the journal and every CNI log file for
+// any of the pod's identifiers. The sections are written to a file staged
+// with nodescript.StageGzip, as they rarely fit in run-command's output.
+func buildTimelineScript(namespace, pod, uid string, units []string, tail int) string {
+	quoted := make([]string, 0, 4)
+	for _, p := range podPatterns(namespace, pod, uid) {
+		quoted = append(quoted, vmss.ShellQuote(p))
+	}
+
+	unitArgs := ""
+	for _, u := range units {
+		unitArgs += " -u " + vmss.ShellQuote(u)
+	}
+
+	limit := ""
+	if tail > 0 {
+		limit = fmt.Sprintf(" | tail -n %d", tail)
+	}
+
+	return fmt.Sprintf(`OUT=$(mktemp /tmp/kubectl-vmss-timeline.XXXXXX)
+PAT=$(mktemp)
+trap 'rm -f "$PAT"' EXIT
+printf '%%s\n' %s > "$PAT"
+SANDBOXES=$(crictl pods --namespace %s --name %s -q 2>/dev/null)
+IDS="$SANDBOXES"
+for sb in $SANDBOXES; do
+  IDS="$IDS $(crictl ps -a --pod "$sb" -q 2>/dev/null)"
+done
+for id in $IDS; do echo "$id" >> "$PAT"; done
+{
+  echo "%s" $IDS
+  echo "%s"
+  journalctl --no-pager -o json --output-fields=%s%s | grep -E -f "$PAT"%s
+  for f in %s; do
+    if [ -f "$f" ]; then
+      echo "%s$f"
+      grep -E -f "$PAT" "$f"%s
+    fi
+  done
+} > "$OUT"
+%s`,
+		strings.Join(quoted, " "),
+		vmss.ShellQuote("^"+regexp.QuoteMeta(namespace)+"$"), vmss.ShellQuote("^"+regexp.QuoteMeta(pod)+"$"),
+		idsMarker, journalMarker,
+		strings.Join(nodelog.JournalFields, ","), unitArgs, limit,
+		strings.Join(acn.LogFiles, " "),
+		fileMarker, limit,
+		nodescript.StageGzip("$OUT"),
+	)
+}
+
+// parseTimelineOutput splits the script output into the IDs found on the
+// node and the merged, time-ordered events from every source.
+func parseTimelineOutput(out string) (ids []string, events []nodelog.Event, skipped int) {
+	var journal []string
+	var file string
+	var fileLines []string
+	section := ""
+
+	flush := func() {
+		if file != "" {
+			events = append(events, nodelog.LineEvents(path.Base(file), fileLines)...)
+		}
+		file, fileLines = "", nil
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, idsMarker):
+			ids = strings.Fields(strings.TrimPrefix(line, idsMarker))
+			section = ""
+		case line == journalMarker:
+			section = "journal"
+		case strings.HasPrefix(line, fileMarker):
+			flush()
+			file = strings.TrimPrefix(line, fileMarker)
+			section = "file"
+		case section == "journal":
+			journal = append(journal, line)
+		case section == "file":
+			fileLines = append(fileLines, line)
+		}
+	}
+	flush()
+
+	entries, skipped := nodelog.ParseJournal(strings.Join(journal, "\n"))
+	events = append(events, nodelog.JournalEvents(entries)...)
+	nodelog.SortEvents(events)
+	return ids, events, skipped
+}
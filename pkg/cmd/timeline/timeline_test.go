@@ -0,0 +1,52 @@
+package timeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildTimelineScript(t *testing.T) {
+	script := buildTimelineScript("kube-system", "coredns-abc", "0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0", []string{"kubelet", "containerd"}, 100)
+	for _, want := range []string{
+		"crictl pods --namespace '^kube-system$' --name '^coredns-abc$' -q",
+		"'kube-system/coredns-abc([^a-z0-9.-]|$)'",
+		"'0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0'",
+		"--output-fields=MESSAGE,PRIORITY,_SYSTEMD_UNIT,SYSLOG_IDENTIFIER,_PID -u 'kubelet' -u 'containerd' | grep -E -f \"$PAT\" | tail -n 100\n",
+		`echo "### file $f"`,
+		"} > \"$OUT\"\n",
+		`gzip -n "$OUT"`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, "%!") {
+		t.Error("script has a formatting error")
+	}
+}
+
+func TestParseTimelineOutput(t *testing.T) {
+	ids, events, skipped := parseTimelineOutput(`### ids 4f1c2a9b8d7e 9a8b7c6d5e4f
+### journal
+{"__REALTIME_TIMESTAMP":"1700000003000000","SYSLOG_IDENTIFIER":"kubelet","_PID":"1234","PRIORITY":"6","MESSAGE":"pod sandbox changed"}
+{"__REALTIME_TIMESTAMP":"17000000
+### file /var/log/azure-vnet.log
+2023/11/14 22:13:21.500000 [1] [cni-net] Processing ADD command K8S_POD_NAME=coredns-abc
+### file /var/log/azure-cns/azure-cns.log
+2023/11/14 22:13:22.000000 [1] Released IP for coredns-abc
+`)
+	if strings.Join(ids, " ") != "4f1c2a9b8d7e 9a8b7c6d5e4f" {
+		t.Errorf("ids = %q", ids)
+	}
+	if skipped != 1 {
+		t.Errorf("skipped: got %d, want 1", skipped)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events: %+v", len(events), events)
+	}
+	for i, want := range []string{"azure-vnet.log", "azure-cns.log", "kubelet"} {
+		if events[i].Source != want {
+			t.Errorf("events[%d].Source = %q, want %q (events not in time order?)", i, events[i].Source, want)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package nodelog
+
+import (
+	"encoding/json"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CNIEntry is one line of an azure-vnet, azure-vnet-ipam or CNS log with
+// the fields CNI triage needs pulled out.
+type CNIEntry struct {
+	Time        time.Time `json:"time"`
+	Source      string    `json:"source"`
+	Level       string    `json:"level"`
+	Operation   string    `json:"operation,omitempty"`
+	ContainerID string    `json:"containerID,omitempty"`
+	Pod         string    `json:"pod,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Message     string    `json:"message"`
+}
+
+var (
+	// legacyPrefixRe matches the "2006/01/02 15:04:05.000000 [pid] " prefix
+	// of the azure-vnet, azure-vnet-ipam and older CNS loggers.
+	legacyPrefixRe = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(\.\d+)? (\[\d+\] )?`)
+	// consolePrefixRe matches zap's console encoder: "<RFC3339>\t<LEVEL>\t".
+	consolePrefixRe = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\S+\s+`)
+	levelTokenRe    = regexp.MustCompile(`^\[?(?i:(debug|info|warn|warning|error|fatal|panic))\]?(\s+|:\s*|$)`)
+
+	operationRe   = regexp.MustCompile(`\b(ADD|DEL|CHECK|UPDATE)\b`)
+	containerIDRe = regexp.MustCompile(`(?i)(?:containerid["]?[:=]\s*"?|K8S_POD_INFRA_CONTAINER_ID=)([0-9a-f]{12,64})`)
+	podNameRe     = regexp.MustCompile(`(?:K8S_POD_NAME=|PodName:|"(?i:podname)":\s*"|for pod )([a-z0-9][-a-z0-9.]*[a-z0-9])`)
+	podNSRe       = regexp.MustCompile(`(?:K8S_POD_NAMESPACE=|PodNamespace:|"(?i:podnamespace)":\s*")([a-z0-9][-a-z0-9]*[a-z0-9])`)
+	errFieldRe    = regexp.MustCompile(`(?i)\berr(?:or)?\s*[:=]\s*(.*)$`)
+	failedRe      = regexp.MustCompile(`(?i)\b(failed|failure|unable to|cannot|panic)\b.*$`)
+)
+
+// ParseCNILine parses one log line from source. It understands the legacy
+// "2006/01/02 15:04:05 [pid] [component] message" format, zap JSON lines
+// and zap console lines. The level comes from the line when it has one;
+// otherwise a line carrying an error counts as "error".
+func ParseCNILine(source, line string) CNIEntry {
+	e := CNIEntry{Source: source}
+	e.Time, _ = ParseTimestamp(line)
+
+	if strings.HasPrefix(strings.TrimSpace(line), "{") && parseCNIJSON(&e, line) {
+		return finishCNIEntry(e, line)
+	}
+
+	msg := line
+	if loc := legacyPrefixRe.FindStringIndex(msg); loc != nil {
+		msg = msg[loc[1]:]
+	} else if loc := consolePrefixRe.FindStringIndex(msg); loc != nil {
+		msg = msg[loc[1]:]
+	}
+	if m := levelTokenRe.FindStringSubmatch(msg); m != nil {
+		e.Level = normalizeLevel(m[1])
+		msg = msg[len(m[0]):]
+	}
+	e.Message = strings.TrimSpace(msg)
+
+	if m := errFieldRe.FindStringSubmatch(e.Message); m != nil {
+		e.Error = cleanError(m[1])
+	}
+	if e.Error == "" && !isNilError(e.Message) {
+		if m := failedRe.FindString(e.Message); m != "" {
+			e.Error = cleanError(m)
+		} else if e.Level == "error" || e.Level == "fatal" {
+			e.Error = e.Message
+		}
+	}
+	return finishCNIEntry(e, line)
+}
+
+// parseCNIJSON fills e from a zap JSON line, reporting whether it was one.
+func parseCNIJSON(e *CNIEntry, line string) bool {
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return false
+	}
+	str := func(keys ...string) string {
+		for _, k := range keys {
+			if s, ok := fields[k].(string); ok && s != "" {
+				return s
+			}
+		}
+		return ""
+	}
+	e.Level = normalizeLevel(str("level", "lvl", "severity"))
+	e.Message = str("msg", "message")
+	e.Error = cleanError(str("error", "err", "Error"))
+	if e.Error == "" && (e.Level == "error" || e.Level == "fatal") {
+		e.Error = e.Message
+	}
+	e.Operation = str("command", "cniCommand", "op")
+	e.ContainerID = str("containerID", "containerId", "ContainerID")
+	if pod := str("pod", "podName", "PodName"); pod != "" {
+		if ns := str("namespace", "podNamespace", "PodNamespace"); ns != "" && !strings.Contains(pod, "/") {
+			pod = ns + "/" + pod
+		}
+		e.Pod = pod
+	}
+	return true
+}
+
+// finishCNIEntry fills whatever the format-specific parsing left out from
+// the raw line, which carries the CNI args for both formats.
+func finishCNIEntry(e CNIEntry, line string) CNIEntry {
+	if e.Operation == "" {
+		if m := operationRe.FindStringSubmatch(line); m != nil {
+			e.Operation = m[1]
+		}
+	}
+	e.Operation = strings.ToUpper(e.Operation)
+	if e.ContainerID == "" {
+		if m := containerIDRe.FindStringSubmatch(line); m != nil {
+			e.ContainerID = m[1]
+		}
+	}
+	if e.Pod == "" {
+		if m := podNameRe.FindStringSubmatch(line); m != nil {
+			e.Pod = m[1]
+			if ns := podNSRe.FindStringSubmatch(line); ns != nil {
+				e.Pod = ns[1] + "/" + e.Pod
+			}
+		}
+	}
+	if e.Level == "" {
+		e.Level = "info"
+		if e.Error != "" {
+			e.Level = "error"
+		}
+	}
+	return e
+}
+
+func normalizeLevel(l string) string {
+	switch l = strings.ToLower(l); l {
+	case "warning":
+		return "warn"
+	case "dpanic", "panic":
+		return "fatal"
+	}
+	return l
+}
+
+// isNilError reports whether a line only mentions a nil error, as in the
+// "ADD command completed ... err:<nil>." summary of a successful call.
+func isNilError(msg string) bool {
+	m := errFieldRe.FindStringSubmatch(msg)
+	return m != nil && cleanError(m[1]) == ""
+}
+
+func cleanError(s string) string {
+	s = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(s), "."))
+	if s == "<nil>" || s == "nil" || s == "null" {
+		return ""
+	}
+	return s
+}
+
+// ParseCNILog parses the lines of one log file. Lines without a timestamp,
+// such as the rest of a wrapped message, take the time of the line before
+// them.
+func ParseCNILog(source string, lines []string) []CNIEntry {
+	var entries []CNIEntry
+	var last time.Time
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		e := ParseCNILine(source, line)
+		if e.Time.IsZero() {
+			e.Time = last
+		}
+		last = e.Time
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// signatureRes replace the parts of an error that differ between
+// occurrences of the same problem, most specific first.
+var signatureRes = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{12,64}\b`), "<id>"},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(/\d{1,2})?(:\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`/var/run/netns/\S+`), "<netns>"},
+	{regexp.MustCompile(`\b\d+(\.\d+)?(ms|s|m|h)?\b`), "<n>"},
+}
+
+// ipv6Re finds IPv6 address candidates; ErrorSignature only replaces the
+// ones that parse, so times such as 12:34:56 are left alone.
+var ipv6Re = regexp.MustCompile(`(?i)[0-9a-f]*:[0-9a-f]*:[0-9a-f:]*(/\d{1,3})?`)
+
+// maxSignature bounds signatures; errors that differ only after this many
+// characters are grouped together.
+const maxSignature = 200
+
+// ErrorSignature returns e's error with IDs, addresses, numbers and the
+// entry's own pod name replaced by placeholders, so that the same failure
+// for different pods and containers groups together.
+func ErrorSignature(e *CNIEntry) string {
+	sig := e.Error
+	if e.Pod != "" {
+		sig = strings.ReplaceAll(sig, e.Pod, "<pod>")
+		if _, name, ok := strings.Cut(e.Pod, "/"); ok {
+			sig = strings.ReplaceAll(sig, name, "<pod>")
+		}
+	}
+	sig = ipv6Re.ReplaceAllStringFunc(sig, func(s string) string {
+		addr, _, _ := strings.Cut(s, "/")
+		if _, err := netip.ParseAddr(addr); err != nil {
+			return s
+		}
+		return "<ip>"
+	})
+	for _, r := range signatureRes {
+		sig = r.re.ReplaceAllString(sig, r.repl)
+	}
+	if len(sig) > maxSignature {
+		sig = sig[:maxSignature] + "..."
+	}
+	return sig
+}
+
+// ErrorGroup is every occurrence of one error signature.
+type ErrorGroup struct {
+	Signature string    `json:"signature"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Sources   []string  `json:"sources"`
+	Pods      []string  `json:"pods,omitempty"`
+	// Example is the most recent occurrence.
+	Example string `json:"example"`
+}
+
+// GroupErrors groups the entries that carry an error by ErrorSignature,
+// most frequent first.
+func GroupErrors(entries []CNIEntry) []ErrorGroup {
+	var groups []ErrorGroup
+	index := map[string]int{}
+	for i := range entries {
+		e := &entries[i]
+		if e.Error == "" {
+			continue
+		}
+		sig := ErrorSignature(e)
+		g, ok := index[sig]
+		if !ok {
+			g = len(groups)
+			index[sig] = g
+			groups = append(groups, ErrorGroup{Signature: sig, FirstSeen: e.Time})
+		}
+		grp := &groups[g]
+		grp.Count++
+		if !e.Time.IsZero() && (grp.FirstSeen.IsZero() || e.Time.Before(grp.FirstSeen)) {
+			grp.FirstSeen = e.Time
+		}
+		if !e.Time.Before(grp.LastSeen) {
+			grp.LastSeen = e.Time
+			grp.Example = e.Message
+		}
+		grp.Sources = appendUnique(grp.Sources, e.Source)
+		if e.Pod != "" {
+			grp.Pods = appendUnique(grp.Pods, e.Pod)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].LastSeen.After(groups[j].LastSeen)
+	})
+	return groups
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
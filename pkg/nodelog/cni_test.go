@@ -0,0 +1,127 @@
+package nodelog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCNILine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want CNIEntry
+	}{
+		{
+			name: "azure-vnet ADD",
+			line: "2023/11/14 22:13:21.500000 [1234] [cni-net] Processing ADD command with args {ContainerID:4f1c2a9b8d7e6f50 Netns:/var/run/netns/cni-1 IfName:eth0 Args:IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=web-0;K8S_POD_INFRA_CONTAINER_ID=4f1c2a9b8d7e6f50 Path:/opt/cni/bin}.",
+			want: CNIEntry{
+				Time: time.Date(2023, 11, 14, 22, 13, 21, 500000000, time.UTC), Level: "info",
+				Operation: "ADD", ContainerID: "4f1c2a9b8d7e6f50", Pod: "default/web-0",
+			},
+		},
+		{
+			name: "azure-vnet ADD failed",
+			line: "2023/11/14 22:13:22 [1234] [cni-net] ADD command completed for pod web-0 with IPs:[] err:Failed to allocate address: No available addresses.",
+			want: CNIEntry{
+				Time: time.Date(2023, 11, 14, 22, 13, 22, 0, time.UTC), Level: "error",
+				Operation: "ADD", Pod: "web-0", Error: "Failed to allocate address: No available addresses",
+			},
+		},
+		{
+			name: "azure-vnet ADD succeeded",
+			line: "2023/11/14 22:13:22 [1234] [cni-net] ADD command completed for pod web-0 with IPs:[10.224.0.9/16] err:<nil>.",
+			want: CNIEntry{
+				Time: time.Date(2023, 11, 14, 22, 13, 22, 0, time.UTC), Level: "info",
+				Operation: "ADD", Pod: "web-0",
+			},
+		},
+		{
+			name: "level token",
+			line: "2023/11/14 22:13:23 [1] [ERROR] unable to reach NMAgent",
+			want: CNIEntry{
+				Time: time.Date(2023, 11, 14, 22, 13, 23, 0, time.UTC), Level: "error",
+				Error: "unable to reach NMAgent",
+			},
+		},
+		{
+			name: "zap JSON",
+			line: `{"level":"error","ts":"2023-11-14T22:13:24.000Z","msg":"failed to release IP","containerID":"abcdef0123456789","podName":"api-1","podNamespace":"prod","error":"no IP held"}`,
+			want: CNIEntry{
+				Time: time.Date(2023, 11, 14, 22, 13, 24, 0, time.UTC), Level: "error",
+				ContainerID: "abcdef0123456789", Pod: "prod/api-1", Error: "no IP held",
+			},
+		},
+		{
+			name: "zap console",
+			line: "2023-11-14T22:13:25.000Z\twarn\tpool is scaling down",
+			want: CNIEntry{Time: time.Date(2023, 11, 14, 22, 13, 25, 0, time.UTC), Level: "warn"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseCNILine("azure-vnet.log", tt.line)
+			got.Source, got.Message = "", ""
+			if got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCNILogContinuation(t *testing.T) {
+	entries := ParseCNILog("azure-cns.log", []string{
+		"2023/11/14 22:13:21 [1] panic: runtime error",
+		"goroutine 1 [running]:",
+		"",
+	})
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if !entries[1].Time.Equal(entries[0].Time) {
+		t.Errorf("continuation line time = %v, want %v", entries[1].Time, entries[0].Time)
+	}
+}
+
+func TestErrorSignature(t *testing.T) {
+	a := ParseCNILine("azure-vnet.log", "2023/11/14 22:13:22 [1] [cni-net] ADD command completed for pod web-0 with IPs:[] err:Failed to set up web-0 on 10.224.0.9/16 via fd00::9 after 3 tries: netns /var/run/netns/cni-4f1c2a9b-8d7e-6f50-1234-567890abcdef.")
+	b := ParseCNILine("azure-vnet.log", "2023/11/14 22:14:40 [1] [cni-net] ADD command completed for pod api-1 with IPs:[] err:Failed to set up api-1 on 10.224.0.12/16 via fd00::c after 5 tries: netns /var/run/netns/cni-0a1b2c3d-8d7e-6f50-1234-567890abcdef.")
+	want := "Failed to set up <pod> on <ip> via <ip> after <n> tries: netns <netns>"
+	if got := ErrorSignature(&a); got != want {
+		t.Errorf("ErrorSignature = %q, want %q", got, want)
+	}
+	if ErrorSignature(&a) != ErrorSignature(&b) {
+		t.Errorf("signatures differ:\n%s\n%s", ErrorSignature(&a), ErrorSignature(&b))
+	}
+	c := ParseCNILine("azure-vnet.log", "2023/11/14 22:13:22 [1] Failed to connect at 12:34:56")
+	if got := ErrorSignature(&c); got != "Failed to connect at <n>:<n>:<n>" {
+		t.Errorf("time taken for an IPv6 address: %q", got)
+	}
+}
+
+func TestGroupErrors(t *testing.T) {
+	entries := ParseCNILog("azure-vnet.log", []string{
+		"2023/11/14 22:13:22 [1] [cni-net] ADD command completed for pod web-0 with IPs:[] err:Failed to allocate address: No available addresses.",
+		"2023/11/14 22:13:30 [1] [cni-net] Processing DEL command",
+		"2023/11/14 22:15:00 [1] [cni-net] ADD command completed for pod api-1 with IPs:[] err:Failed to allocate address: No available addresses.",
+		"2023/11/14 22:16:00 [1] [cni-net] ADD command completed for pod web-0 with IPs:[] err:Failed to allocate address: No available addresses.",
+		"2023/11/14 22:14:00 [1] [cni-net] Failed to delete endpoint 4f1c2a9b8d7e6f50",
+	})
+	groups := GroupErrors(entries)
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2: %+v", len(groups), groups)
+	}
+	g := groups[0]
+	if g.Count != 3 || g.Signature != "Failed to allocate address: No available addresses" {
+		t.Errorf("top group = %+v", g)
+	}
+	if !g.FirstSeen.Equal(time.Date(2023, 11, 14, 22, 13, 22, 0, time.UTC)) || !g.LastSeen.Equal(time.Date(2023, 11, 14, 22, 16, 0, 0, time.UTC)) {
+		t.Errorf("seen %v .. %v", g.FirstSeen, g.LastSeen)
+	}
+	if len(g.Pods) != 2 || g.Pods[0] != "web-0" || g.Pods[1] != "api-1" {
+		t.Errorf("pods = %v", g.Pods)
+	}
+	if groups[1].Signature != "Failed to delete endpoint <id>" {
+		t.Errorf("second group = %q", groups[1].Signature)
+	}
+}
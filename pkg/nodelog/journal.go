@@ -0,0 +1,117 @@
+// Package nodelog parses log formats produced on AKS nodes (journald,
+// Azure CNI / CNS) into structured entries.
+package nodelog
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JournalFields are the journald fields requested with
+// journalctl -o json --output-fields, keeping each entry small enough that
+// many fit in the run-command output budget.
+var JournalFields = []string{"MESSAGE", "PRIORITY", "_SYSTEMD_UNIT", "SYSLOG_IDENTIFIER", "_PID"}
+
+// JournalEntry is one record from journalctl -o json.
+type JournalEntry struct {
+	Time       time.Time `json:"time"`
+	Unit       string    `json:"unit,omitempty"`
+	Identifier string    `json:"identifier,omitempty"`
+	PID        string    `json:"pid,omitempty"`
+	Priority   int       `json:"priority"`
+	Message    string    `json:"message"`
+}
+
+// priorityNames maps syslog priorities to the names journalctl -p accepts.
+var priorityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// PriorityName returns the syslog name for the entry's priority.
+func (e *JournalEntry) PriorityName() string {
+	if e.Priority >= 0 && e.Priority < len(priorityNames) {
+		return priorityNames[e.Priority]
+	}
+	return strconv.Itoa(e.Priority)
+}
+
+// String formats the entry like journalctl -o short-iso-precise.
+func (e *JournalEntry) String() string {
+	src := e.Identifier
+	if src == "" {
+		src = strings.TrimSuffix(e.Unit, ".service")
+	}
+	if e.PID != "" {
+		src += "[" + e.PID + "]"
+	}
+	return e.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00") + " " + src + ": " + e.Message
+}
+
+// rawJournalEntry mirrors the journald JSON export format, where every
+// value is a string except binary fields, which are arrays of bytes.
+type rawJournalEntry struct {
+	RealtimeTimestamp string          `json:"__REALTIME_TIMESTAMP"`
+	Message           json.RawMessage `json:"MESSAGE"`
+	Priority          string          `json:"PRIORITY"`
+	Unit              string          `json:"_SYSTEMD_UNIT"`
+	Identifier        string          `json:"SYSLOG_IDENTIFIER"`
+	PID               string          `json:"_PID"`
+}
+
+// ParseJournal parses journalctl -o json output (one object per line) and
+// returns the entries in timestamp order, so output from several units is
+// merged into one timeline. Lines that are not valid entries, such as a
+// record cut off by output truncation, are counted in skipped.
+func ParseJournal(out string) (entries []JournalEntry, skipped int) {
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var raw rawJournalEntry
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			skipped++
+			continue
+		}
+		usec, err := strconv.ParseInt(raw.RealtimeTimestamp, 10, 64)
+		if err != nil {
+			skipped++
+			continue
+		}
+		prio, err := strconv.Atoi(raw.Priority)
+		if err != nil {
+			prio = 6 // journald default: info
+		}
+		entries = append(entries, JournalEntry{
+			Time:       time.UnixMicro(usec).UTC(),
+			Unit:       raw.Unit,
+			Identifier: raw.Identifier,
+			PID:        raw.PID,
+			Priority:   prio,
+			Message:    journalMessage(raw.Message),
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, skipped
+}
+
+// journalMessage decodes MESSAGE, which journald exports as a byte array
+// instead of a string when it is not valid UTF-8.
+func journalMessage(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var b []byte
+	var ints []int
+	if err := json.Unmarshal(raw, &ints); err == nil {
+		for _, i := range ints {
+			b = append(b, byte(i))
+		}
+		return string(b)
+	}
+	return ""
+}
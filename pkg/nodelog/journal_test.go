@@ -0,0 +1,36 @@
+package nodelog
+
+import (
+	"testing"
+)
+
+func TestParseJournal(t *testing.T) {
+	out := `{"__REALTIME_TIMESTAMP":"1700000002000000","_SYSTEMD_UNIT":"containerd.service","SYSLOG_IDENTIFIER":"containerd","_PID":"900","PRIORITY":"3","MESSAGE":"failed to pull"}
+{"__REALTIME_TIMESTAMP":"1700000001000000","_SYSTEMD_UNIT":"kubelet.service","SYSLOG_IDENTIFIER":"kubelet","_PID":"1234","PRIORITY":"6","MESSAGE":"starting"}
+{"__REALTIME_TIMESTAMP":"1700000003000000","_SYSTEMD_UNIT":"kubelet.service","PRIORITY":"4","MESSAGE":[104,105]}
+{"__REALTIME_TIMESTAMP":"17000000`
+
+	entries, skipped := ParseJournal(out)
+	if skipped != 1 {
+		t.Errorf("skipped: got %d, want 1", skipped)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("entries: got %d, want 3", len(entries))
+	}
+	if entries[0].Identifier != "kubelet" || entries[1].Identifier != "containerd" {
+		t.Errorf("entries not merged in timestamp order: %+v", entries)
+	}
+	if entries[1].PriorityName() != "err" {
+		t.Errorf("PriorityName: got %q, want %q", entries[1].PriorityName(), "err")
+	}
+	if entries[2].Message != "hi" {
+		t.Errorf("byte-array MESSAGE: got %q, want %q", entries[2].Message, "hi")
+	}
+	want := "2023-11-14T22:13:21.000000Z kubelet[1234]: starting"
+	if got := entries[0].String(); got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+	if got := entries[2].String(); got != "2023-11-14T22:13:23.000000Z kubelet: hi" {
+		t.Errorf("String without identifier: got %q", got)
+	}
+}
@@ -0,0 +1,91 @@
+package nodelog
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Event is one line of a merged timeline built from several node sources.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Message string    `json:"message"`
+}
+
+// String formats the event as "<time> <source> <message>".
+func (e *Event) String() string {
+	ts := "-"
+	if !e.Time.IsZero() {
+		ts = e.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00")
+	}
+	return ts + " " + e.Source + " " + e.Message
+}
+
+// timestampRe matches the timestamps used by azure-vnet ("2006/01/02
+// 15:04:05.000000"), CNS and most Go loggers (RFC3339, optionally quoted
+// inside JSON).
+var timestampRe = regexp.MustCompile(`\d{4}[-/]\d{2}[-/]\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`)
+
+var timestampLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// ParseTimestamp returns the first timestamp found in a log line. Timestamps
+// without a zone are taken as UTC, which is what AKS nodes run in.
+func ParseTimestamp(line string) (time.Time, bool) {
+	m := timestampRe.FindString(line)
+	if m == "" {
+		return time.Time{}, false
+	}
+	m = strings.Replace(m[:10], "/", "-", 2) + "T" + m[11:]
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, m); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// LineEvents turns plain log lines into events. Lines without a timestamp
+// (stack traces, wrapped output) take the time of the line before them so
+// they stay attached to it after sorting.
+func LineEvents(source string, lines []string) []Event {
+	var events []Event
+	var last time.Time
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if t, ok := ParseTimestamp(line); ok {
+			last = t
+		}
+		events = append(events, Event{Time: last, Source: source, Message: line})
+	}
+	return events
+}
+
+// JournalEvents converts journal entries into events, using the unit (or
+// syslog identifier) as the source.
+func JournalEvents(entries []JournalEntry) []Event {
+	events := make([]Event, 0, len(entries))
+	for _, e := range entries {
+		src := strings.TrimSuffix(e.Unit, ".service")
+		if src == "" {
+			src = e.Identifier
+		}
+		events = append(events, Event{Time: e.Time, Source: src, Message: e.Message})
+	}
+	return events
+}
+
+// SortEvents orders events by time, keeping the original order of events
+// with equal timestamps.
+func SortEvents(events []Event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+}
@@ -0,0 +1,62 @@
+package nodelog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want time.Time
+		ok   bool
+	}{
+		{
+			name: "azure-vnet format",
+			line: "2023/11/14 22:13:21.500000 [1234] Processing ADD command",
+			want: time.Date(2023, 11, 14, 22, 13, 21, 500000000, time.UTC),
+			ok:   true,
+		},
+		{
+			name: "CNS JSON",
+			line: `{"level":"info","ts":"2023-11-14T22:13:21.250Z","msg":"ok"}`,
+			want: time.Date(2023, 11, 14, 22, 13, 21, 250000000, time.UTC),
+			ok:   true,
+		},
+		{
+			name: "offset zone",
+			line: "2023-11-14T23:13:21+01:00 started",
+			want: time.Date(2023, 11, 14, 22, 13, 21, 0, time.UTC),
+			ok:   true,
+		},
+		{
+			name: "no timestamp",
+			line: "    at main.go:42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseTimestamp(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("ok: got %v, want %v", ok, tt.ok)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("time: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineEventsContinuation(t *testing.T) {
+	events := LineEvents("azure-vnet.log", []string{
+		"2023/11/14 22:13:22.000000 second",
+		"2023/11/14 22:13:21.000000 first",
+		"  detail of first",
+	})
+	SortEvents(events)
+	if events[0].Message != "2023/11/14 22:13:21.000000 first" || events[1].Message != "  detail of first" {
+		t.Errorf("continuation line should stay after its parent, got %+v", events)
+	}
+}
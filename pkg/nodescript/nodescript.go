@@ -0,0 +1,210 @@
+// Package nodescript provides shell snippets shared by the scripts that
+// commands send to nodes through VMSS run-command. Snippets only rely on
+// tools present on every AKS node image (sh, crictl, coreutils, grep, sed,
+// awk); in particular there is no python3 on minimal and hardened images, so
+// JSON fields are read with JSONString and JSONNumber, or JSONFlatten for
+// whole documents.
+package nodescript
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+)
+
+// ContainerPID returns a snippet that sets $CID to the running container
+// named container in pod namespace/pod, and $PID to its init process.
+// The script exits with an error message when either cannot be found.
+// /proc/$PID/root then gives the container's filesystem, volumes included.
+func ContainerPID(namespace, pod, container string) string {
+	return fmt.Sprintf(`CID=$(crictl ps --label io.kubernetes.pod.namespace=%s --label io.kubernetes.pod.name=%s --name %s -q 2>/dev/null | head -1)
+if [ -z "$CID" ]; then
+  echo "Error: no running container %s in pod %s/%s on this node" >&2
+  exit 1
+fi
+PID=$(crictl inspect "$CID" 2>/dev/null | %s)
+if [ -z "$PID" ] || [ "$PID" = "0" ]; then
+  echo "Error: could not determine PID of container $CID" >&2
+  exit 1
+fi`,
+		vmss.ShellQuote(namespace), vmss.ShellQuote(pod), vmss.ShellQuote(anchored(container)),
+		container, namespace, pod, JSONNumber("pid"))
+}
+
+// SandboxPID returns a snippet that sets $SANDBOX_ID to the ready sandbox of
+// pod namespace/pod and $SANDBOX_PID to its pause process. The sandbox
+// stays up while the pod's containers crash-loop, so nsenter -t
+// $SANDBOX_PID -n reaches the pod's network namespace regardless.
+func SandboxPID(namespace, pod string) string {
+	return fmt.Sprintf(`SANDBOX_ID=$(crictl pods --namespace %s --name %s --state ready -q 2>/dev/null | head -1)
+if [ -z "$SANDBOX_ID" ]; then
+  echo "Error: no ready sandbox for pod %s/%s on this node" >&2
+  exit 1
+fi
+SANDBOX_PID=$(crictl inspectp "$SANDBOX_ID" 2>/dev/null | %s)
+if [ -z "$SANDBOX_PID" ] || [ "$SANDBOX_PID" = "0" ]; then
+  echo "Error: could not determine sandbox PID for pod %s/%s" >&2
+  exit 1
+fi`,
+		vmss.ShellQuote(anchored(namespace)), vmss.ShellQuote(anchored(pod)),
+		namespace, pod, JSONNumber("pid"), namespace, pod)
+}
+
+// LabeledSandboxPID returns a snippet that sets $SANDBOX_ID and
+// $SANDBOX_PID for the first ready sandbox in namespace carrying label
+// (key=value), leaving both empty when there is none.
+func LabeledSandboxPID(namespace, label string) string {
+	return fmt.Sprintf(`SANDBOX_PID=""
+SANDBOX_ID=$(crictl pods --namespace %s --label %s --state ready -q 2>/dev/null | head -1)
+if [ -n "$SANDBOX_ID" ]; then
+  SANDBOX_PID=$(crictl inspectp "$SANDBOX_ID" 2>/dev/null | %s)
+  [ "$SANDBOX_PID" != "0" ] || SANDBOX_PID=""
+fi`, vmss.ShellQuote(anchored(namespace)), vmss.ShellQuote(label), JSONNumber("pid"))
+}
+
+// ContainerImage returns a snippet that sets $IMAGE to the image of the
+// most recent container named container in pod namespace/pod, running or
+// exited. The repo digest reference is preferred because containerd keeps
+// it as an image name that "ctr images mount" accepts.
+func ContainerImage(namespace, pod, container string) string {
+	return fmt.Sprintf(`IMAGE_CID=$(crictl ps -a --label io.kubernetes.pod.namespace=%s --label io.kubernetes.pod.name=%s --name %s -q 2>/dev/null | head -1)
+if [ -z "$IMAGE_CID" ]; then
+  echo "Error: no container %s in pod %s/%s on this node" >&2
+  exit 1
+fi
+IMAGE=$(crictl inspect "$IMAGE_CID" 2>/dev/null | %s)
+if [ -z "$IMAGE" ]; then
+  IMAGE=$(crictl inspect "$IMAGE_CID" 2>/dev/null | %s)
+fi
+if [ -z "$IMAGE" ]; then
+  echo "Error: could not determine the image of container $IMAGE_CID" >&2
+  exit 1
+fi`,
+		vmss.ShellQuote(namespace), vmss.ShellQuote(pod), vmss.ShellQuote(anchored(container)),
+		container, namespace, pod, JSONString("imageRef"), JSONString("image"))
+}
+
+// MountImage returns a snippet that mounts the containerd image named by
+// $IMAGE read-write at $MNT and unmounts it, removing the temporary
+// snapshot, when the script exits. The image must already be on the node.
+func MountImage() string {
+	return `MNT=$(mktemp -d /tmp/kubectl-vmss-image.XXXXXX)
+cleanup() { ctr -n k8s.io images unmount --rm "$MNT" >/dev/null 2>&1 || true; rmdir "$MNT" 2>/dev/null || true; }
+trap cleanup EXIT
+if ! ctr -n k8s.io images mount "$IMAGE" "$MNT" >/dev/null 2>&1; then
+  echo "Error: could not mount image $IMAGE" >&2
+  exit 1
+fi`
+}
+
+// PullImage returns a snippet that pulls the image named by $IMAGE through
+// the CRI unless it is already on the node.
+func PullImage() string {
+	return `if ! crictl inspecti "$IMAGE" >/dev/null 2>&1; then
+  echo "Pulling $IMAGE..." >&2
+  crictl pull "$IMAGE" >/dev/null || exit 1
+fi`
+}
+
+// NormalizeImageRef expands a short image reference the way containerd
+// names images ("nicolaka/netshoot" -> "docker.io/nicolaka/netshoot:latest"),
+// since "ctr images mount" (see MountImage) does not resolve short names.
+func NormalizeImageRef(ref string) string {
+	name, digest, hasDigest := strings.Cut(ref, "@")
+	domain, rest, found := strings.Cut(name, "/")
+	if !found || (!strings.ContainsAny(domain, ".:") && domain != "localhost") {
+		domain, rest = "docker.io", name
+	}
+	if domain == "docker.io" && !strings.Contains(rest, "/") {
+		rest = "library/" + rest
+	}
+	if !hasDigest && !strings.Contains(rest[strings.LastIndex(rest, "/")+1:], ":") {
+		rest += ":latest"
+	}
+	out := domain + "/" + rest
+	if hasDigest {
+		out += "@" + digest
+	}
+	return out
+}
+
+// JSONString returns a filter that prints the first string value of key in
+// the indented JSON on its stdin, as printed by crictl inspect/inspectp,
+// which put every scalar field on its own line.
+func JSONString(key string) string {
+	return fmt.Sprintf(`sed -n 's/.*"%s": *"\([^"]*\)".*/\1/p' | head -1`, key)
+}
+
+// JSONNumber returns a filter that prints the first numeric value of key in
+// the indented JSON on its stdin, skipping string values such as the "POD"
+// namespace mode that precedes info.pid in crictl inspectp output.
+func JSONNumber(key string) string {
+	return fmt.Sprintf(`grep -m1 '"%s": *[0-9]' | tr -cd '0-9'`, key)
+}
+
+// JSONFlatten returns a filter that turns indented JSON on its stdin, as
+// printed by "cilium-dbg ... -o json" or crictl, into one "path=value"
+// line per scalar, e.g. "controllers.3.status.consecutive-failure-count=0".
+// Array elements are numbered from 0 and string values are unquoted. It
+// lets scripts reduce large documents on the node, where run-command's
+// 4 KB output limit would otherwise truncate them.
+func JSONFlatten() string {
+	return `awk '
+{
+  t = $0
+  sub(/^[ \t]+/, "", t)
+  if (t ~ /^[]}],?$/) { d--; next }
+  key = ""
+  if (match(t, /^"[^"]*": /)) {
+    key = substr(t, 2, RLENGTH - 4)
+    t = substr(t, RLENGTH + 1)
+  } else if (d > 0 && arr[d]) {
+    key = idx[d]++
+  }
+  p = key
+  if (d > 0 && path[d] != "") p = path[d] "." key
+  if (t ~ /^[[{],?$/) {
+    d++
+    path[d] = p
+    arr[d] = (substr(t, 1, 1) == "[")
+    idx[d] = 0
+    next
+  }
+  if (t ~ /^([[][]]|{}),?$/) next
+  sub(/,$/, "", t)
+  if (t ~ /^".*"$/) t = substr(t, 2, length(t) - 2)
+  print p "=" t
+}'`
+}
+
+// StageGzip returns a snippet that gzips file in place for a chunked
+// download and prints "<size> <sha256>" of the original and "<path> <size>
+// <sha256>" of file.gz, the two lines transfer.ParseStaged reads. file is
+// expanded inside double quotes, so pass a variable such as "$OUT".
+func StageGzip(file string) string {
+	return fmt.Sprintf(`SIZE=$(stat -c %%s "%[1]s")
+SUM=$(sha256sum "%[1]s" | cut -d' ' -f1)
+gzip -n "%[1]s"
+echo "$SIZE $SUM"
+%[2]s`, file, stagedLine(file+".gz"))
+}
+
+// PrintStaged returns a snippet that prints the lines of StageGzip for a
+// gzipped copy staged next to the original, whose SHA-256 the script has
+// already computed. file, sum and staged are expanded like StageGzip's.
+func PrintStaged(file, sum, staged string) string {
+	return fmt.Sprintf(`echo "$(stat -c %%s "%s") %s"
+%s`, file, sum, stagedLine(staged))
+}
+
+func stagedLine(gz string) string {
+	return fmt.Sprintf(`echo "%[1]s $(stat -c %%s "%[1]s") $(sha256sum "%[1]s" | cut -d' ' -f1)"`, gz)
+}
+
+// anchored returns an exact-match regular expression for crictl's
+// --name and --namespace filters, which otherwise match substrings.
+func anchored(s string) string {
+	return "^" + regexp.QuoteMeta(s) + "$"
+}
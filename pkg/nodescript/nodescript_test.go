@@ -0,0 +1,178 @@
+package nodescript
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matmerr/kubectl-vmss/pkg/transfer"
+)
+
+func TestNormalizeImageRef(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"busybox", "docker.io/library/busybox:latest"},
+		{"nicolaka/netshoot", "docker.io/nicolaka/netshoot:latest"},
+		{"nicolaka/netshoot:v0.13", "docker.io/nicolaka/netshoot:v0.13"},
+		{"docker.io/library/alpine:3.20", "docker.io/library/alpine:3.20"},
+		{"mcr.microsoft.com/oss/cilium/cilium:v1.16.6", "mcr.microsoft.com/oss/cilium/cilium:v1.16.6"},
+		{"myacr.azurecr.io/tools@sha256:0123", "myacr.azurecr.io/tools@sha256:0123"},
+		{"localhost:5000/debug", "localhost:5000/debug:latest"},
+		{"localhost/debug", "localhost/debug:latest"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeImageRef(tt.ref); got != tt.want {
+			t.Errorf("NormalizeImageRef(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+// inspectp is trimmed crictl inspectp output; the namespace options carry a
+// string "pid" before the numeric info.pid.
+const inspectp = `{
+  "status": {
+    "id": "0f4c6a0d9e21",
+    "linux": {
+      "namespaces": {
+        "options": {
+          "ipc": "POD",
+          "network": "NODE",
+          "pid": "CONTAINER",
+          "targetId": ""
+        }
+      }
+    }
+  },
+  "info": {
+    "image": "mcr.microsoft.com/oss/kubernetes/pause:3.6",
+    "pid": 4242,
+    "runtimeSpec": {
+      "process": {
+        "user": {
+          "uid": 65535
+        }
+      }
+    }
+  }
+}`
+
+func TestJSONFilters(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{JSONNumber("pid"), "4242"},
+		{JSONString("image"), "mcr.microsoft.com/oss/kubernetes/pause:3.6"},
+		{JSONString("network"), "NODE"},
+		{JSONString("imageRef"), ""},
+	}
+
+	for _, tt := range tests {
+		cmd := exec.Command("sh", "-c", tt.filter)
+		cmd.Stdin = strings.NewReader(inspectp)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.filter, err)
+		}
+		if got := strings.TrimSpace(string(out)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestJSONFlatten(t *testing.T) {
+	cmd := exec.Command("sh", "-c", JSONFlatten())
+	cmd.Stdin = strings.NewReader(`[
+  {
+    "id": 12,
+    "labels": [],
+    "status": {
+      "controllers": [
+        {
+          "name": "sync-lb",
+          "consecutive-failure-count": 0
+        }
+      ],
+      "msg": "a, b: c",
+      "state": "ready"
+    }
+  },
+  {
+    "id": 13,
+    "ports": [
+      [
+        80,
+        443
+      ]
+    ]
+  }
+]`)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `0.id=12
+0.status.controllers.0.name=sync-lb
+0.status.controllers.0.consecutive-failure-count=0
+0.status.msg=a, b: c
+0.status.state=ready
+1.id=13
+1.ports.0.0=80
+1.ports.0.1=443
+`
+	if string(out) != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	cmd = exec.Command("sh", "-c", JSONFlatten())
+	cmd.Stdin = strings.NewReader(inspectp)
+	out, _ = cmd.Output()
+	for _, line := range []string{"info.pid=4242", "status.linux.namespaces.options.targetId=", "info.runtimeSpec.process.user.uid=65535"} {
+		if !strings.Contains(string(out), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+}
+
+func TestStageGzip(t *testing.T) {
+	dir := t.TempDir()
+	data := strings.Repeat("cns log line\n", 200)
+	file := filepath.Join(dir, "out")
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	copied := filepath.Join(dir, "copy")
+	if err := os.WriteFile(copied, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, script string
+	}{
+		{"in place", `OUT="$1"` + "\n" + StageGzip("$OUT")},
+		{"copy", `F="$2"; SUM=$(sha256sum "$F" | cut -d' ' -f1); STAGE="$F.$SUM.gz"; gzip -nc "$F" > "$STAGE"` + "\n" + PrintStaged("$F", "$SUM", "$STAGE")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := exec.Command("sh", "-c", tt.script, "sh", file, copied).Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			orig, staged, err := transfer.ParseStaged(string(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			local := filepath.Join(dir, tt.name)
+			if err := transfer.GunzipVerify(staged.Path, local, orig); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(local); string(got) != data {
+				t.Errorf("unstaged %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
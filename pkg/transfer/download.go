@@ -0,0 +1,242 @@
+// Package transfer moves files between the local machine and an AKS node
+// over VMSS run-command, which only carries text and caps output at a few
+// kilobytes per invocation. Files are sent as base64 chunks and verified
+// with SHA-256.
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+)
+
+// DefaultChunkSize is the number of raw bytes fetched per run-command call.
+// Run-command keeps only the last 4096 bytes of output; 2700 bytes encode
+// to 3600 base64 characters, leaving room for the [stdout]/[stderr] framing.
+const DefaultChunkSize = 2700
+
+// FileInfo describes a file on the node.
+type FileInfo struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// Stat returns the size and SHA-256 of a regular file on the node.
+func Stat(ctx context.Context, runner vmss.Runner, info *vmss.NodeInfo, path string) (*FileInfo, error) {
+	q := vmss.ShellQuote(path)
+	script := fmt.Sprintf(`if [ ! -f %s ]; then echo "not a regular file" >&2; exit 1; fi; stat -c %%s %s; sha256sum %s | cut -d' ' -f1`,
+		q, q, q)
+	result, err := runner.RunCommand(ctx, info, script)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(result.Stdout)
+	if len(fields) != 2 {
+		if result.Stderr != "" {
+			return nil, fmt.Errorf("stat %s: %s", path, result.Stderr)
+		}
+		return nil, fmt.Errorf("stat %s: unexpected output %q", path, result.Stdout)
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: bad size %q", path, fields[0])
+	}
+	return &FileInfo{Path: path, Size: size, SHA256: fields[1]}, nil
+}
+
+// ParseStaged parses the last two lines printed by a staging script:
+// "<size> <sha256>" of the original file and "<path> <size> <sha256>" of
+// its gzipped copy on the node.
+func ParseStaged(out string) (orig, staged *FileInfo, err error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) < 2 {
+		return nil, nil, fmt.Errorf("unexpected staging output: %q", out)
+	}
+	o := strings.Fields(lines[len(lines)-2])
+	s := strings.Fields(lines[len(lines)-1])
+	if len(o) != 2 || len(s) != 3 {
+		return nil, nil, fmt.Errorf("unexpected staging output: %q", out)
+	}
+	osize, err1 := strconv.ParseInt(o[0], 10, 64)
+	ssize, err2 := strconv.ParseInt(s[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, nil, fmt.Errorf("unexpected staging output: %q", out)
+	}
+	return &FileInfo{Size: osize, SHA256: o[1]},
+		&FileInfo{Path: s[0], Size: ssize, SHA256: s[2]}, nil
+}
+
+// Downloader copies a file from a node in base64 chunks.
+type Downloader struct {
+	Runner vmss.Runner
+	Info   *vmss.NodeInfo
+	// ChunkSize is the number of raw bytes per run-command call;
+	// DefaultChunkSize when zero.
+	ChunkSize int
+	// Progress, if set, receives one status line per chunk.
+	Progress io.Writer
+}
+
+// chunkScript returns a script that prints length bytes of path starting at
+// offset, base64 encoded on one line.
+func chunkScript(path string, offset int64, length int) string {
+	return fmt.Sprintf("dd if=%s iflag=skip_bytes,count_bytes skip=%d count=%d bs=64K 2>/dev/null | base64 -w0",
+		vmss.ShellQuote(path), offset, length)
+}
+
+// Download writes bytes [offset, f.Size) of the remote file to w. The caller
+// is responsible for w already holding bytes [0, offset) when resuming, and
+// for verifying the result with Verify.
+func (d *Downloader) Download(ctx context.Context, f *FileInfo, w io.Writer, offset int64) error {
+	chunk := d.ChunkSize
+	if chunk <= 0 {
+		chunk = DefaultChunkSize
+	}
+	total := (f.Size - offset + int64(chunk) - 1) / int64(chunk)
+
+	for n := int64(1); offset < f.Size; n++ {
+		length := chunk
+		if rem := f.Size - offset; rem < int64(length) {
+			length = int(rem)
+		}
+		result, err := d.Runner.RunCommand(ctx, d.Info, chunkScript(f.Path, offset, length))
+		if err != nil {
+			return fmt.Errorf("chunk at offset %d: %w", offset, err)
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(result.Stdout))
+		if err != nil {
+			return fmt.Errorf("chunk at offset %d: bad base64 (output truncated? try a smaller chunk size): %w", offset, err)
+		}
+		if len(data) != length {
+			return fmt.Errorf("chunk at offset %d: got %d bytes, want %d (file changed on the node?)", offset, len(data), length)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		offset += int64(length)
+		if d.Progress != nil {
+			fmt.Fprintf(d.Progress, "  chunk %d/%d: %d/%d bytes\n", n, total, offset, f.Size)
+		}
+	}
+	return nil
+}
+
+// DownloadFile downloads f to localPath. Data is written to localPath+".part"
+// and renamed into place only after the SHA-256 matches. With resume set, an
+// existing .part file is continued from its current size instead of being
+// started over.
+func (d *Downloader) DownloadFile(ctx context.Context, f *FileInfo, localPath string, resume bool) error {
+	part := localPath + ".part"
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	var offset int64
+	if resume {
+		if st, err := os.Stat(part); err == nil && st.Size() <= f.Size {
+			offset = st.Size()
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+	}
+	if offset > 0 && d.Progress != nil {
+		fmt.Fprintf(d.Progress, "Resuming %s at %d/%d bytes\n", part, offset, f.Size)
+	}
+
+	out, err := os.OpenFile(part, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := d.Download(ctx, f, out, offset); err != nil {
+		out.Close()
+		return fmt.Errorf("%w (partial data kept in %s)", err, part)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	in, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	err = Verify(in, f)
+	in.Close()
+	if err != nil {
+		os.Remove(part)
+		return err
+	}
+	return os.Rename(part, localPath)
+}
+
+// FetchStagedFile downloads staged, the gzipped copy of orig staged on the
+// node (see ParseStaged), to localPath+".gz" and unpacks it to localPath,
+// checking the result against orig. With resume set, an interrupted
+// download of the same staged copy is continued.
+func (d *Downloader) FetchStagedFile(ctx context.Context, orig, staged *FileInfo, localPath string, resume bool) error {
+	chunk := d.ChunkSize
+	if chunk <= 0 {
+		chunk = DefaultChunkSize
+	}
+	if calls := (staged.Size + int64(chunk) - 1) / int64(chunk); calls > 1 && d.Progress != nil {
+		fmt.Fprintf(d.Progress, "Downloading %d bytes (%d uncompressed) in %d chunks...\n", staged.Size, orig.Size, calls)
+	}
+	gz := localPath + ".gz"
+	if err := d.DownloadFile(ctx, staged, gz, resume); err != nil {
+		return err
+	}
+	if err := GunzipVerify(gz, localPath, orig); err != nil {
+		return err
+	}
+	os.Remove(gz)
+	return nil
+}
+
+// FetchStaged reads the output of a script ending in nodescript.StageGzip,
+// downloads the staged copy, removes it from the node and returns its
+// unpacked content.
+func (d *Downloader) FetchStaged(ctx context.Context, stdout string) ([]byte, error) {
+	orig, staged, err := ParseStaged(stdout)
+	if err != nil {
+		return nil, err
+	}
+	defer Remove(context.WithoutCancel(ctx), d.Runner, d.Info, staged.Path)
+
+	dir, err := os.MkdirTemp("", "kubectl-vmss-staged")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "data")
+	if err := d.FetchStagedFile(ctx, orig, staged, out, false); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out)
+}
+
+// Verify reads r to the end and checks it against the remote SHA-256.
+func Verify(r io.Reader, f *FileInfo) error {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	if n != f.Size {
+		return fmt.Errorf("size mismatch for %s: got %d bytes, want %d", f.Path, n, f.Size)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != f.SHA256 {
+		return fmt.Errorf("SHA-256 mismatch for %s: got %s, want %s", f.Path, got, f.SHA256)
+	}
+	return nil
+}
+
+// Remove deletes a file on the node, ignoring errors. It is used to clean
+// up staged archives after a download.
+func Remove(ctx context.Context, runner vmss.Runner, info *vmss.NodeInfo, path string) {
+	_, _ = runner.RunCommand(ctx, info, "rm -f "+vmss.ShellQuote(path))
+}
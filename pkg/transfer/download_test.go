@@ -0,0 +1,128 @@
+package transfer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+)
+
+// fakeNode serves chunk scripts from an in-memory file.
+type fakeNode struct {
+	data  []byte
+	calls int
+	last  string
+}
+
+var chunkRe = regexp.MustCompile(`skip=(\d+) count=(\d+)`)
+
+func (f *fakeNode) ResolveNodeFromPod(context.Context, string, string) (string, error) {
+	return "", nil
+}
+func (f *fakeNode) ResolveVMSS(context.Context, string) (*vmss.NodeInfo, error) { return nil, nil }
+func (f *fakeNode) GetContainerName(context.Context, string, string) (string, error) {
+	return "", nil
+}
+func (f *fakeNode) GetPodUID(context.Context, string, string) (string, error) { return "", nil }
+func (f *fakeNode) GetDaemonSetImage(context.Context, string, string, string) (string, error) {
+	return "", nil
+}
+func (f *fakeNode) ListNodes(context.Context, string) ([]string, error) { return nil, nil }
+func (f *fakeNode) ListPodsOnNode(context.Context, string) ([]vmss.Pod, error) {
+	return nil, nil
+}
+
+func (f *fakeNode) RunCommand(_ context.Context, _ *vmss.NodeInfo, script string) (*vmss.CommandResult, error) {
+	f.calls++
+	f.last = script
+	m := chunkRe.FindStringSubmatch(script)
+	if m == nil {
+		sum := sha256.Sum256(f.data)
+		return &vmss.CommandResult{Stdout: fmt.Sprintf("%d\n%s", len(f.data), hex.EncodeToString(sum[:]))}, nil
+	}
+	off, _ := strconv.Atoi(m[1])
+	n, _ := strconv.Atoi(m[2])
+	end := off + n
+	if end > len(f.data) {
+		end = len(f.data)
+	}
+	return &vmss.CommandResult{Stdout: base64.StdEncoding.EncodeToString(f.data[off:end])}, nil
+}
+
+func TestDownloadAndVerify(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 100) // 1600 bytes
+	node := &fakeNode{data: data}
+	ctx := context.Background()
+
+	f, err := Stat(ctx, node, nil, "/var/log/test.log")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if f.Size != int64(len(data)) {
+		t.Fatalf("Size: got %d, want %d", f.Size, len(data))
+	}
+
+	// Resume from an offset that is not a multiple of the chunk size.
+	var buf bytes.Buffer
+	buf.Write(data[:100])
+	node.calls = 0
+	d := &Downloader{Runner: node, ChunkSize: 500}
+	if err := d.Download(ctx, f, &buf, 100); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if node.calls != 3 {
+		t.Errorf("calls: got %d, want 3", node.calls)
+	}
+	if err := Verify(bytes.NewReader(buf.Bytes()), f); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	corrupt := append([]byte{}, buf.Bytes()...)
+	corrupt[42] ^= 0xff
+	if err := Verify(bytes.NewReader(corrupt), f); err == nil {
+		t.Error("Verify should fail on corrupted data")
+	}
+}
+
+func TestFetchStaged(t *testing.T) {
+	data := bytes.Repeat([]byte("hubble flow\n"), 500)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+	node := &fakeNode{data: gz.Bytes()}
+
+	sum := sha256.Sum256(data)
+	gzSum := sha256.Sum256(gz.Bytes())
+	stdout := fmt.Sprintf("total 6000\n%d %s\n/tmp/kubectl-vmss-hubble.abc.gz %d %s\n",
+		len(data), hex.EncodeToString(sum[:]), gz.Len(), hex.EncodeToString(gzSum[:]))
+
+	var progress bytes.Buffer
+	d := &Downloader{Runner: node, ChunkSize: 40, Progress: &progress}
+	got, err := d.FetchStaged(context.Background(), stdout)
+	if err != nil {
+		t.Fatalf("FetchStaged: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes, want %d", len(got), len(data))
+	}
+	if node.last != "rm -f '/tmp/kubectl-vmss-hubble.abc.gz'" {
+		t.Errorf("staged copy not removed, last script %q", node.last)
+	}
+	if !strings.Contains(progress.String(), fmt.Sprintf("Downloading %d bytes (%d uncompressed)", gz.Len(), len(data))) {
+		t.Errorf("progress: %s", progress.String())
+	}
+
+	if _, err := d.FetchStaged(context.Background(), "total 0\n"); err == nil {
+		t.Error("FetchStaged should fail without staging lines")
+	}
+}
@@ -0,0 +1,112 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+)
+
+// DefaultUploadChunkSize is the number of raw bytes sent per run-command
+// call. Uploads travel inside the script rather than its output, so they are
+// limited by the script argument passed to az (a single argument is capped
+// at 128 KiB on Linux) instead of the 4096-byte output window. 48 KiB
+// encodes to 64 KiB of base64.
+const DefaultUploadChunkSize = 48 << 10
+
+// Uploader copies data to a file on a node in base64 chunks.
+type Uploader struct {
+	Runner vmss.Runner
+	Info   *vmss.NodeInfo
+	// ChunkSize is the number of raw bytes per run-command call;
+	// DefaultUploadChunkSize when zero.
+	ChunkSize int
+	// Progress, if set, receives one status line per chunk.
+	Progress io.Writer
+}
+
+// writeScript returns a script that truncates path to offset, appends the
+// decoded b64 and prints the new file size.
+// Writing at an explicit offset makes a retried chunk idempotent.
+func writeScript(path string, offset int64, b64 string) string {
+	q := vmss.ShellQuote(path)
+	return fmt.Sprintf("truncate -s %d %s && printf '%%s' '%s' | base64 -d | dd of=%s oflag=seek_bytes seek=%d bs=64K conv=notrunc 2>/dev/null && stat -c %%s %s",
+		offset, q, b64, q, offset, q)
+}
+
+// remoteSize returns the size of path on the node, or 0 if it does not exist.
+func (u *Uploader) remoteSize(ctx context.Context, path string) (int64, error) {
+	result, err := u.Runner.RunCommand(ctx, u.Info, fmt.Sprintf("stat -c %%s %s 2>/dev/null || echo 0", vmss.ShellQuote(path)))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(result.Stdout), 10, 64)
+}
+
+// Upload writes data to remotePath on the node and verifies its SHA-256.
+// With resume set, a partial file left by an earlier attempt is continued
+// from its current size; the final checksum catches a stale prefix.
+func (u *Uploader) Upload(ctx context.Context, data []byte, remotePath string, resume bool) (*FileInfo, error) {
+	chunk := u.ChunkSize
+	if chunk <= 0 {
+		chunk = DefaultUploadChunkSize
+	}
+	size := int64(len(data))
+
+	var offset int64
+	if resume {
+		if n, err := u.remoteSize(ctx, remotePath); err == nil && n <= size {
+			offset = n
+		}
+		if offset > 0 && u.Progress != nil {
+			fmt.Fprintf(u.Progress, "Resuming %s at %d/%d bytes\n", remotePath, offset, size)
+		}
+	}
+	if size == 0 {
+		if _, err := u.Runner.RunCommand(ctx, u.Info, ": > "+vmss.ShellQuote(remotePath)); err != nil {
+			return nil, err
+		}
+	}
+
+	total := (size - offset + int64(chunk) - 1) / int64(chunk)
+	for n := int64(1); offset < size; n++ {
+		end := offset + int64(chunk)
+		if end > size {
+			end = size
+		}
+		b64 := base64.StdEncoding.EncodeToString(data[offset:end])
+		result, err := u.Runner.RunCommand(ctx, u.Info, writeScript(remotePath, offset, b64))
+		if err != nil {
+			return nil, fmt.Errorf("chunk at offset %d: %w", offset, err)
+		}
+		got, err := strconv.ParseInt(strings.TrimSpace(result.Stdout), 10, 64)
+		if err != nil || got != end {
+			if result.Stderr != "" {
+				return nil, fmt.Errorf("chunk at offset %d: %s", offset, strings.TrimSpace(result.Stderr))
+			}
+			return nil, fmt.Errorf("chunk at offset %d: remote file is %q bytes, want %d", offset, strings.TrimSpace(result.Stdout), end)
+		}
+		offset = end
+		if u.Progress != nil {
+			fmt.Fprintf(u.Progress, "  chunk %d/%d: %d/%d bytes\n", n, total, offset, size)
+		}
+	}
+
+	f, err := Stat(ctx, u.Runner, u.Info, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if f.Size != size || f.SHA256 != hex.EncodeToString(sum[:]) {
+		Remove(ctx, u.Runner, u.Info, remotePath)
+		return nil, fmt.Errorf("SHA-256 mismatch for %s after upload: got %s (%d bytes), want %s (%d bytes)",
+			remotePath, f.SHA256, f.Size, hex.EncodeToString(sum[:]), size)
+	}
+	return f, nil
+}
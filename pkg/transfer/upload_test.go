@@ -0,0 +1,71 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/matmerr/kubectl-vmss/pkg/vmss"
+)
+
+// shellNode runs scripts with the local sh, standing in for a node.
+type shellNode struct {
+	fakeNode
+}
+
+func (s *shellNode) RunCommand(ctx context.Context, _ *vmss.NodeInfo, script string) (*vmss.CommandResult, error) {
+	s.calls++
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", script)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	_ = cmd.Run()
+	return &vmss.CommandResult{Stdout: stdout.String(), Stderr: stderr.String()}, nil
+}
+
+func TestUploadResume(t *testing.T) {
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum not available")
+	}
+	data := bytes.Repeat([]byte("kubectl-vmss\x00\xff"), 500) // 7000 bytes
+	remote := filepath.Join(t.TempDir(), "upload.part")
+	ctx := context.Background()
+
+	// A previous attempt left the first 1234 bytes plus a stale tail.
+	stale := append(append([]byte{}, data[:1234]...), []byte("garbage")...)
+	if err := os.WriteFile(remote, stale[:1234], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &shellNode{}
+	u := &Uploader{Runner: node, ChunkSize: 3000}
+	f, err := u.Upload(ctx, data, remote, true)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if f.Size != int64(len(data)) {
+		t.Errorf("Size: got %d, want %d", f.Size, len(data))
+	}
+	// size query + 2 chunks (1234..4234, 4234..7000) + stat
+	if node.calls != 4 {
+		t.Errorf("calls: got %d, want 4", node.calls)
+	}
+	got, _ := os.ReadFile(remote)
+	if !bytes.Equal(got, data) {
+		t.Error("remote file does not match the uploaded data")
+	}
+
+	// Re-uploading without resume truncates and rewrites from the start.
+	if err := os.WriteFile(remote, stale, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Upload(ctx, data[:100], remote, false); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	got, _ = os.ReadFile(remote)
+	if !bytes.Equal(got, data[:100]) {
+		t.Errorf("remote file: got %d bytes, want 100", len(got))
+	}
+}
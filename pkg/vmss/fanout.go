@@ -0,0 +1,58 @@
+package vmss
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// NodeResult is the outcome of running a script on one node of a fan-out.
+type NodeResult struct {
+	Node   string
+	Result *CommandResult
+	Err    error
+}
+
+// RunOnNodes runs the script built by script for every node, at most
+// parallel at a time (one when parallel is not positive), and returns the
+// results in the order of nodes. A "[i/n] <node> done" line per finished
+// node goes to progress. Run-command allows one invocation per VM, so nodes
+// are the natural unit of fan-out.
+func RunOnNodes(ctx context.Context, runner Runner, nodes []string, parallel int, script func(node string) string, progress io.Writer) []NodeResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]NodeResult, len(nodes))
+	sem := make(chan struct{}, parallel)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	done := 0
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			r := NodeResult{Node: node}
+			info, err := runner.ResolveVMSS(ctx, node)
+			if err == nil {
+				r.Result, err = runner.RunCommand(ctx, info, script(node))
+			}
+			r.Err = err
+			results[i] = r
+
+			mu.Lock()
+			done++
+			status := "done"
+			if err != nil {
+				status = "failed: " + err.Error()
+			}
+			fmt.Fprintf(progress, "[%d/%d] %s %s\n", done, len(nodes), node, status)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
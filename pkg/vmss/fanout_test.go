@@ -0,0 +1,81 @@
+package vmss
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fanoutRunner answers RunCommand with the node's script and fails
+// ResolveVMSS for the node named "bad".
+type fanoutRunner struct {
+	Runner
+	running, peak atomic.Int32
+}
+
+func (r *fanoutRunner) ResolveVMSS(ctx context.Context, node string) (*NodeInfo, error) {
+	if node == "bad" {
+		return nil, errors.New("no providerID")
+	}
+	return &NodeInfo{InstanceID: node}, nil
+}
+
+func (r *fanoutRunner) RunCommand(ctx context.Context, info *NodeInfo, script string) (*CommandResult, error) {
+	n := r.running.Add(1)
+	defer r.running.Add(-1)
+	for p := r.peak.Load(); n > p && !r.peak.CompareAndSwap(p, n); p = r.peak.Load() {
+	}
+	time.Sleep(10 * time.Millisecond)
+	return &CommandResult{Stdout: script}, nil
+}
+
+func TestRunOnNodes(t *testing.T) {
+	r := &fanoutRunner{}
+	nodes := []string{"n0", "n1", "bad", "n3", "n4"}
+	var progress bytes.Buffer
+	results := RunOnNodes(context.Background(), r, nodes, 2, func(node string) string { return "echo " + node }, &progress)
+
+	for i, res := range results {
+		if res.Node != nodes[i] {
+			t.Errorf("results[%d] is %s, want %s", i, res.Node, nodes[i])
+		}
+	}
+	if results[1].Result.Stdout != "echo n1" {
+		t.Errorf("n1 ran %q", results[1].Result.Stdout)
+	}
+	if results[2].Err == nil || results[2].Result != nil {
+		t.Errorf("bad node: %+v", results[2])
+	}
+	if p := r.peak.Load(); p > 2 {
+		t.Errorf("%d commands ran at once, want at most 2", p)
+	}
+	if got := strings.Count(progress.String(), "\n"); got != len(nodes) || !strings.Contains(progress.String(), "bad failed: no providerID") {
+		t.Errorf("progress:\n%s", progress.String())
+	}
+}
+
+func TestRunOnNodesNoParallelism(t *testing.T) {
+	for _, parallel := range []int{0, -1} {
+		r := &fanoutRunner{}
+		done := make(chan []NodeResult)
+		go func() {
+			done <- RunOnNodes(context.Background(), r, []string{"n0", "n1", "n2"}, parallel, func(node string) string { return node }, io.Discard)
+		}()
+		select {
+		case results := <-done:
+			if len(results) != 3 || results[2].Result.Stdout != "n2" {
+				t.Errorf("parallel %d: %+v", parallel, results)
+			}
+			if p := r.peak.Load(); p != 1 {
+				t.Errorf("parallel %d: %d commands ran at once, want 1", parallel, p)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("parallel %d: RunOnNodes blocked", parallel)
+		}
+	}
+}
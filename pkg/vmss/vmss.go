@@ -3,6 +3,7 @@ package vmss
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -23,12 +24,33 @@ type CommandResult struct {
 	Stderr string
 }
 
+// Pod is what the API server knows about a pod bound to a node.
+type Pod struct {
+	Namespace   string   `json:"namespace"`
+	Name        string   `json:"name"`
+	UID         string   `json:"uid"`
+	Phase       string   `json:"phase"`
+	IPs         []string `json:"ips,omitempty"`
+	HostNetwork bool     `json:"hostNetwork,omitempty"`
+	// Mirror is set on mirror pods to the UID of the static pod they stand
+	// for, which is the UID the kubelet and the CRI know it by.
+	Mirror string `json:"mirror,omitempty"`
+}
+
+// ErrPodNotFound is returned, wrapped, by ResolveNodeFromPod when the pod
+// does not exist.
+var ErrPodNotFound = errors.New("pod not found")
+
 // Runner is the interface for executing commands on VMSS instances.
 // This abstraction enables testing with mock implementations.
 type Runner interface {
 	ResolveNodeFromPod(ctx context.Context, namespace, pod string) (string, error)
 	ResolveVMSS(ctx context.Context, node string) (*NodeInfo, error)
 	GetContainerName(ctx context.Context, namespace, pod string) (string, error)
+	GetPodUID(ctx context.Context, namespace, pod string) (string, error)
+	GetDaemonSetImage(ctx context.Context, namespace, daemonSet, container string) (string, error)
+	ListNodes(ctx context.Context, selector string) ([]string, error)
+	ListPodsOnNode(ctx context.Context, node string) ([]Pod, error)
 	RunCommand(ctx context.Context, info *NodeInfo, script string) (*CommandResult, error)
 }
 
@@ -46,11 +68,15 @@ func NewDefaultRunner() *DefaultRunner {
 	}
 }
 
-// ResolveNodeFromPod returns the node name a pod is scheduled on.
+// ResolveNodeFromPod returns the node name a pod is scheduled on, or an
+// error wrapping ErrPodNotFound if there is no such pod.
 func (r *DefaultRunner) ResolveNodeFromPod(ctx context.Context, namespace, pod string) (string, error) {
 	out, err := r.kubectl(ctx, "get", "pod", pod, "-n", namespace, "-o", "jsonpath={.spec.nodeName}")
 	if err != nil {
-		return "", fmt.Errorf("could not find pod %s/%s or it has no node assigned: %w", namespace, pod, err)
+		if strings.Contains(out, "(NotFound)") {
+			return "", fmt.Errorf("pod %s/%s: %w", namespace, pod, ErrPodNotFound)
+		}
+		return "", fmt.Errorf("could not find pod %s/%s or it has no node assigned: %w: %s", namespace, pod, err, strings.TrimSpace(out))
 	}
 	node := strings.TrimSpace(out)
 	if node == "" {
@@ -123,6 +149,102 @@ func (r *DefaultRunner) GetContainerName(ctx context.Context, namespace, pod str
 	return strings.TrimSpace(out), nil
 }
 
+// GetPodUID returns the metadata.uid of a pod.
+func (r *DefaultRunner) GetPodUID(ctx context.Context, namespace, pod string) (string, error) {
+	out, err := r.kubectl(ctx, "get", "pod", pod, "-n", namespace, "-o", "jsonpath={.metadata.uid}")
+	if err != nil {
+		return "", fmt.Errorf("could not get UID for pod %s/%s: %w", namespace, pod, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// GetDaemonSetImage returns the image of a container in a DaemonSet's pod
+// template.
+func (r *DefaultRunner) GetDaemonSetImage(ctx context.Context, namespace, daemonSet, container string) (string, error) {
+	out, err := r.kubectl(ctx, "get", "daemonset", daemonSet, "-n", namespace,
+		"-o", fmt.Sprintf(`jsonpath={.spec.template.spec.containers[?(@.name=="%s")].image}`, container))
+	if err != nil {
+		return "", fmt.Errorf("could not get daemonset %s/%s: %w", namespace, daemonSet, err)
+	}
+	image := strings.TrimSpace(out)
+	if image == "" {
+		return "", fmt.Errorf("daemonset %s/%s has no container %s", namespace, daemonSet, container)
+	}
+	return image, nil
+}
+
+// ListNodes returns the names of the nodes matching a label selector, or of
+// all nodes when selector is empty.
+func (r *DefaultRunner) ListNodes(ctx context.Context, selector string) ([]string, error) {
+	args := []string{"get", "nodes", "-o", "jsonpath={.items[*].metadata.name}"}
+	if selector != "" {
+		args = append(args, "-l", selector)
+	}
+	out, err := r.kubectl(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list nodes: %w", err)
+	}
+	nodes := strings.Fields(out)
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes match selector %q", selector)
+	}
+	return nodes, nil
+}
+
+// podList is the part of "kubectl get pods -o json" that ListPodsOnNode reads.
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Namespace   string            `json:"namespace"`
+			Name        string            `json:"name"`
+			UID         string            `json:"uid"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Spec struct {
+			HostNetwork bool `json:"hostNetwork"`
+		} `json:"spec"`
+		Status struct {
+			Phase  string `json:"phase"`
+			PodIPs []struct {
+				IP string `json:"ip"`
+			} `json:"podIPs"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// ListPodsOnNode returns the pods in all namespaces that the API server has
+// bound to node (spec.nodeName).
+func (r *DefaultRunner) ListPodsOnNode(ctx context.Context, node string) ([]Pod, error) {
+	out, err := r.kubectlStdout(ctx, "get", "pods", "-A", "--field-selector", "spec.nodeName="+node, "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("could not list pods on node %s: %w", node, err)
+	}
+	return parsePodList(out)
+}
+
+func parsePodList(raw string) ([]Pod, error) {
+	var list podList
+	if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		return nil, fmt.Errorf("failed to parse pod list: %w", err)
+	}
+	pods := make([]Pod, 0, len(list.Items))
+	for _, item := range list.Items {
+		p := Pod{
+			Namespace:   item.Metadata.Namespace,
+			Name:        item.Metadata.Name,
+			UID:         item.Metadata.UID,
+			Phase:       item.Status.Phase,
+			HostNetwork: item.Spec.HostNetwork,
+			Mirror:      item.Metadata.Annotations["kubernetes.io/config.mirror"],
+		}
+		for _, ip := range item.Status.PodIPs {
+			p.IPs = append(p.IPs, ip.IP)
+		}
+		pods = append(pods, p)
+	}
+	return pods, nil
+}
+
 // runCommandResponse is the JSON shape returned by az vmss run-command invoke.
 type runCommandResponse struct {
 	Value []struct {
@@ -191,12 +313,31 @@ func (r *DefaultRunner) PickFirstNode(ctx context.Context) (string, error) {
 	return node, nil
 }
 
+// ShellQuote returns s wrapped in single quotes so it can be embedded in a
+// run-command script as one literal shell word.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 func (r *DefaultRunner) kubectl(ctx context.Context, args ...string) (string, error) {
 	cmd := exec.CommandContext(ctx, r.KubectlPath, args...)
 	out, err := cmd.CombinedOutput()
 	return string(out), err
 }
 
+// kubectlStdout is kubectl for commands whose stdout is parsed, keeping
+// warnings on stderr out of it. Stderr is returned in the error instead.
+func (r *DefaultRunner) kubectlStdout(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, r.KubectlPath, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
 func (r *DefaultRunner) az(ctx context.Context, args ...string) (string, error) {
 	cmd := exec.CommandContext(ctx, r.AzPath, args...)
 	out, err := cmd.CombinedOutput()
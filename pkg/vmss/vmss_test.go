@@ -147,3 +147,50 @@ func TestParseRunCommandOutput(t *testing.T) {
 		})
 	}
 }
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: `''`},
+		{in: "plain", want: `'plain'`},
+		{in: "error|fail$", want: `'error|fail$'`},
+		{in: "it's", want: `'it'\''s'`},
+	}
+
+	for _, tt := range tests {
+		if got := ShellQuote(tt.in); got != tt.want {
+			t.Errorf("ShellQuote(%q): got %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParsePodList(t *testing.T) {
+	raw := `{"items": [
+  {"metadata": {"namespace": "default", "name": "web-0", "uid": "u1"},
+   "spec": {},
+   "status": {"phase": "Running", "podIP": "10.0.0.9", "podIPs": [{"ip": "10.0.0.9"}, {"ip": "fd00::9"}]}},
+  {"metadata": {"namespace": "kube-system", "name": "kube-proxy-x", "uid": "u2", "annotations": {"kubernetes.io/config.mirror": "abc123"}},
+   "spec": {"hostNetwork": true},
+   "status": {"phase": "Pending"}}
+]}`
+	pods, err := parsePodList(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 2 {
+		t.Fatalf("got %d pods, want 2", len(pods))
+	}
+	if p := pods[0]; p.Namespace != "default" || p.Name != "web-0" || p.UID != "u1" || p.Phase != "Running" ||
+		len(p.IPs) != 2 || p.IPs[1] != "fd00::9" || p.HostNetwork {
+		t.Errorf("pods[0] = %+v", p)
+	}
+	if p := pods[1]; !p.HostNetwork || p.IPs != nil || p.Phase != "Pending" || p.Mirror != "abc123" {
+		t.Errorf("pods[1] = %+v", p)
+	}
+
+	if _, err := parsePodList("error: the server doesn't have a resource type"); err == nil {
+		t.Error("expected an error for non-JSON output")
+	}
+}
@@ -14,6 +14,10 @@ import (
 type mockRunner struct {
 	node            string
 	container       string
+	podUID          string
+	dsImage         string
+	nodes           []string
+	pods            []vmss.Pod
 	nodeInfo        *vmss.NodeInfo
 	result          *vmss.CommandResult
 	capturedScript  string
@@ -44,6 +48,22 @@ func (m *mockRunner) GetContainerName(_ context.Context, namespace, pod string)
 	return m.container, nil
 }
 
+func (m *mockRunner) GetPodUID(_ context.Context, namespace, pod string) (string, error) {
+	return m.podUID, nil
+}
+
+func (m *mockRunner) GetDaemonSetImage(_ context.Context, namespace, daemonSet, container string) (string, error) {
+	return m.dsImage, nil
+}
+
+func (m *mockRunner) ListNodes(_ context.Context, selector string) ([]string, error) {
+	return m.nodes, nil
+}
+
+func (m *mockRunner) ListPodsOnNode(_ context.Context, node string) ([]vmss.Pod, error) {
+	return m.pods, nil
+}
+
 func (m *mockRunner) RunCommand(_ context.Context, info *vmss.NodeInfo, script string) (*vmss.CommandResult, error) {
 	m.capturedScript = script
 	if m.runCommandErr != nil {
@@ -56,6 +76,7 @@ func defaultMock() *mockRunner {
 	return &mockRunner{
 		node:      "aks-nodepool1-12345678-vmss000000",
 		container: "cilium-agent",
+		podUID:    "11111111-2222-3333-4444-555555555555",
 		nodeInfo: &vmss.NodeInfo{
 			Subscription:  "00000000-0000-0000-0000-000000000000",
 			ResourceGroup: "MC_my-rg_my-aks_eastus",
@@ -637,10 +658,10 @@ func (o *ciliumTestOptions) Run(ctx context.Context, stdout, stderr *bytes.Buffe
 POD_NAME=%q
 CILIUM_ARGS=%q
 SANDBOX_ID=$(crictl pods --name "$POD_NAME" -q | head -1)
-SANDBOX_PID=$(crictl inspectp "$SANDBOX_ID" 2>/dev/null | python3 -c "import sys,json; print(json.load(sys.stdin)['info']['pid'])" 2>/dev/null || true)
+SANDBOX_PID=$(crictl inspectp "$SANDBOX_ID" 2>/dev/null | grep -m1 '"pid": *[0-9]' | tr -cd '0-9')
 CID=$(crictl ps --name cilium-agent -q | head -1)
 if [ -z "$CID" ]; then CID=$(crictl ps -a --name cilium-agent -q | head -1); fi
-IMAGE=$(crictl inspect "$CID" 2>/dev/null | python3 -c "import sys,json; d=json.load(sys.stdin); print(d['info']['config']['image']['image'])" 2>/dev/null || true)
+IMAGE=$(crictl inspect "$CID" 2>/dev/null | sed -n 's/.*"imageRef": *"\([^"]*\)".*/\1/p' | head -1)
 MNT="/tmp/cilium-rootfs-$$"
 mkdir -p "$MNT"
 ctr -n k8s.io images mount "$IMAGE" "$MNT" >/dev/null 2>&1